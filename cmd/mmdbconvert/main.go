@@ -127,10 +127,15 @@ func run(configPath string, quiet, disableCache bool) error {
 		}
 	}
 
-	err := mmdbconvert.Run(mmdbconvert.Options{
+	opts := mmdbconvert.Options{
 		ConfigPath:   configPath,
 		DisableCache: disableCache,
-	})
+		Version:      version,
+	}
+	if !quiet {
+		opts.Warnings = os.Stderr
+	}
+	err := mmdbconvert.Run(opts)
 	if err != nil {
 		return err
 	}
@@ -7,9 +7,13 @@ import (
 	"math"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/pelletier/go-toml/v2"
+
+	"github.com/maxmind/mmdbconvert/internal/expr"
 )
 
 const (
@@ -21,6 +25,10 @@ const (
 	IPv6BucketTypeString = "string"
 	// IPv6BucketTypeInt stores IPv6 bucket values as int64 (first 60 bits).
 	IPv6BucketTypeInt = "int"
+
+	// ExpandKeys expands a column whose path ends at a map into one column per
+	// language listed in the database metadata.
+	ExpandKeys = "keys"
 )
 
 // Config represents the complete configuration file structure.
@@ -29,7 +37,13 @@ type Config struct {
 	Network      NetworkConfig `toml:"network"`
 	Databases    []Database    `toml:"databases"`
 	Columns      []Column      `toml:"columns"`
+	Filters      []Filter      `toml:"filters"`       // Row filters; a row is kept only if all match
 	DisableCache bool          `toml:"disable_cache"` // Disable MMDB unmarshaler caching (default: false)
+	Locales      []string      `toml:"locales"`       // Keep only these keys of names maps (default: all)
+
+	// IPv4OnlySubtrees selects the subtrees of the IPv6 space that IPv4-only
+	// databases merged with IPv6 databases are mapped into (default: "both")
+	IPv4OnlySubtrees string `toml:"ipv4_only_subtrees"`
 }
 
 // OutputConfig defines output file settings.
@@ -42,6 +56,21 @@ type OutputConfig struct {
 	IPv4File         string        `toml:"ipv4_file"`
 	IPv6File         string        `toml:"ipv6_file"`
 	IncludeEmptyRows *bool         `toml:"include_empty_rows"` // Include rows with no MMDB data (default: false)
+	IPVersion        int           `toml:"ip_version"`         // Only write networks of this IP version, 4 or 6 (default: both)
+	Provenance       bool          `toml:"provenance"`         // Record the source databases in Parquet and MMDB metadata (default: false)
+}
+
+// NetworkIPVersion returns the IP version the written networks are
+// restricted to, or 0 if networks of both versions are written. An IPv4 MMDB
+// only holds IPv4 networks, while an IPv6 MMDB holds both.
+func (o OutputConfig) NetworkIPVersion() int {
+	if o.Format == formatMMDB {
+		if o.MMDB.IPVersion == 4 {
+			return 4
+		}
+		return 0
+	}
+	return o.IPVersion
 }
 
 // CSVConfig defines CSV output options.
@@ -51,15 +80,53 @@ type CSVConfig struct {
 	IPv4BucketSize int    `toml:"ipv4_bucket_size"` // Bucket prefix length for IPv4 (default: 16)
 	IPv6BucketSize int    `toml:"ipv6_bucket_size"` // Bucket prefix length for IPv6 (default: 16)
 	IPv6BucketType string `toml:"ipv6_bucket_type"` // "string" or "int" (default: "string")
+	ListSeparator  string `toml:"list_separator"`   // Separator for joining list column values (default: ",")
+	ProvenanceFile string `toml:"provenance_file"`  // Sidecar JSON file recording the source databases (default: none)
 }
 
 // ParquetConfig defines Parquet output options.
 type ParquetConfig struct {
-	Compression    string `toml:"compression"`      // "none", "snappy", "gzip", "lz4", "zstd" (default: "snappy")
-	RowGroupSize   int    `toml:"row_group_size"`   // Rows per row group (default: 500000)
-	IPv4BucketSize int    `toml:"ipv4_bucket_size"` // Bucket prefix length for IPv4 (default: 16)
-	IPv6BucketSize int    `toml:"ipv6_bucket_size"` // Bucket prefix length for IPv6 (default: 16)
-	IPv6BucketType string `toml:"ipv6_bucket_type"` // "string" or "int" (default: "string")
+	Compression             string   `toml:"compression"`                 // "none", "snappy", "gzip", "lz4", "zstd" (default: "snappy")
+	RowGroupSize            int      `toml:"row_group_size"`              // Rows per row group (default: 500000, unlimited if row_group_bytes is set)
+	RowGroupBytes           int      `toml:"row_group_bytes"`             // Approximate bytes per row group (default: unlimited)
+	IPv4RowGroupBoundary    int      `toml:"ipv4_row_group_boundary"`     // Prefix length at which IPv4 row groups may be cut (default: any row)
+	IPv6RowGroupBoundary    int      `toml:"ipv6_row_group_boundary"`     // Prefix length at which IPv6 row groups may be cut (default: any row)
+	SchemaSampleRows        int      `toml:"schema_sample_rows"`          // Rows sampled to infer nested computed column types (default: 10000)
+	PageSize                int      `toml:"page_size"`                   // Target uncompressed page size in bytes (default: 262144)
+	ColumnIndexSizeLimit    int      `toml:"column_index_size_limit"`     // Max bytes of each page's min/max kept in the column index (default: 16)
+	BloomFilterColumns      []string `toml:"bloom_filter_columns"`        // Columns to write bloom filters for
+	BloomFilterBitsPerValue int      `toml:"bloom_filter_bits_per_value"` // Bloom filter size per distinct value (default: 10)
+	IPv4BucketSize          int      `toml:"ipv4_bucket_size"`            // Bucket prefix length for IPv4 (default: 16)
+	IPv6BucketSize          int      `toml:"ipv6_bucket_size"`            // Bucket prefix length for IPv6 (default: 16)
+	IPv6BucketType          string   `toml:"ipv6_bucket_type"`            // "string" or "int" (default: "string")
+	PartitionBy             []string `toml:"partition_by"`                // Data columns, "ip_version", or "network_prefix" to partition a dataset directory by
+	IPv4PartitionPrefix     int      `toml:"ipv4_partition_prefix"`       // Prefix length of IPv4 network_prefix partitions (default: 8)
+	IPv6PartitionPrefix     int      `toml:"ipv6_partition_prefix"`       // Prefix length of IPv6 network_prefix partitions (default: 16)
+	MaxRowsPerFile          int      `toml:"max_rows_per_file"`           // Rows per dataset file (default: unlimited)
+	MaxOpenFiles            int      `toml:"max_open_files"`              // Dataset files kept open at once (default: 64)
+	TableFormat             string   `toml:"table_format"`                // "delta" to commit the dataset as a Delta Lake table version (default: none)
+}
+
+// TableFormatDelta writes the output as a Delta Lake table.
+const TableFormatDelta = "delta"
+
+// Partition keys that are derived from the network rather than a data column.
+const (
+	PartitionIPVersion     = "ip_version"     // "4" or "6"
+	PartitionNetworkPrefix = "network_prefix" // Enclosing ipv4/ipv6_partition_prefix block, e.g. "1.0.0.0/8"
+)
+
+// IsDataset reports whether the output is a directory of Parquet files rather
+// than a single file.
+func (p ParquetConfig) IsDataset() bool {
+	return len(p.PartitionBy) > 0 || p.MaxRowsPerFile > 0 || p.TableFormat != ""
+}
+
+// SplitsIPVersions reports whether every dataset file holds a single IP
+// version because the dataset is partitioned by ip_version or network_prefix.
+func (p ParquetConfig) SplitsIPVersions() bool {
+	return slices.Contains(p.PartitionBy, PartitionIPVersion) ||
+		slices.Contains(p.PartitionBy, PartitionNetworkPrefix)
 }
 
 // MMDBConfig defines MMDB output options.
@@ -69,8 +136,22 @@ type MMDBConfig struct {
 	Languages               []string          `toml:"languages"`                 // List of languages (auto-populated from description if empty)
 	RecordSize              *int              `toml:"record_size"`               // 24, 28, or 32 (default: 28)
 	IncludeReservedNetworks *bool             `toml:"include_reserved_networks"` // Include reserved networks (default: false)
+	LowMemory               bool              `toml:"low_memory"`                // Share identical values between records (default: false)
+	DisableIPv4Aliasing     bool              `toml:"disable_ipv4_aliasing"`     // Do not alias IPv4-mapped IPv6 networks to IPv4 (default: false)
+	DisableMetadataPointers bool              `toml:"disable_metadata_pointers"` // Do not use pointers in the metadata section (default: false)
+	BuildEpoch              int64             `toml:"build_epoch"`               // Build timestamp as a Unix epoch (default: time of the run)
+	MetadataFrom            string            `toml:"metadata_from"`             // Database whose description and languages are used if not set
+	IPVersion               int               `toml:"ip_version"`                // 4 or 6 (default: 6 if any database is IPv6, otherwise 4)
+	Verify                  string            `toml:"verify"`                    // "full" or "sample" to check the written file against the merge (default: off)
+	VerifySampleRate        float64           `toml:"verify_sample_rate"`        // Fraction of networks checked by "sample" (default: 0.01)
 }
 
+// MMDB output verification modes.
+const (
+	VerifyFull   = "full"   // Check every network of the written file
+	VerifySample = "sample" // Check a sample of the networks of the written file
+)
+
 // NetworkConfig defines network column configuration.
 type NetworkConfig struct {
 	Columns []NetworkColumn `toml:"columns"`
@@ -78,8 +159,9 @@ type NetworkConfig struct {
 
 // NetworkColumn defines a network column in the output.
 type NetworkColumn struct {
-	Name mmdbtype.String `toml:"name"` // Column name
-	Type string          `toml:"type"` // "cidr", "start_ip", "end_ip", "start_int", "end_int"
+	Name     mmdbtype.String `toml:"name"`     // Column name
+	Type     string          `toml:"type"`     // "cidr", "start_ip", "end_ip", "start_int", "end_int", "start_int_hi", ...
+	Encoding string          `toml:"encoding"` // Optional Parquet encoding: "dictionary", "plain", "delta"
 }
 
 // Database defines an MMDB database source.
@@ -88,18 +170,161 @@ type Database struct {
 	Path string `toml:"path"` // Path to MMDB file
 }
 
-// Column defines a data column mapping from MMDB to output.
+// Column defines a data column mapping from MMDB to output. A column with an
+// Expression is computed from other columns and has no Database or Path.
 type Column struct {
 	Name       mmdbtype.String `toml:"name"`        // Output column name
 	Database   string          `toml:"database"`    // Database to read from (references Database.Name)
 	Path       Path            `toml:"path"`        // Path segments to the field
 	OutputPath *Path           `toml:"output_path"` // Path segments for MMDB output (defaults to [name])
-	Type       string          `toml:"type"`        // Optional type hint: "string", "int64", "float64", "bool", "binary" (Parquet only)
+	Type       string          `toml:"type"`        // Optional type hint for Parquet or MMDB output, e.g. "uint32" or "decimal(p,s)"
+	Expression string          `toml:"expression"`  // Computes the value from earlier columns instead of reading database/path
+	Transforms []Transform     `toml:"transforms"`  // Value transforms applied in order after extraction
+	Expand     string          `toml:"expand"`      // "keys": one column per database language (see ExpandColumns)
+	Encoding   string          `toml:"encoding"`    // Optional Parquet encoding: "dictionary", "plain", "delta"
+
+	// MergeStrategy decides how the value is combined with a value already
+	// written to the same MMDB key (default: "error")
+	MergeStrategy string `toml:"merge_strategy"`
+
+	// IncludeKeys, ExcludeKeys, and Rename slim down map values for MMDB
+	// output. Include and exclude patterns are paths within the value that may
+	// contain PathWildcard segments; an include pattern keeps only the listed
+	// keys of the maps it points into. Rename maps dotted key paths, e.g.
+	// "location.time_zone", to new key names.
+	IncludeKeys []Path            `toml:"include_keys"`
+	ExcludeKeys []Path            `toml:"exclude_keys"`
+	Rename      map[string]string `toml:"rename"`
+}
+
+// SplitKeyPath splits a dotted rename key path into its segments. Numeric
+// segments are array indexes.
+func SplitKeyPath(keyPath string) Path {
+	parts := strings.Split(keyPath, ".")
+	path := make(Path, len(parts))
+	for i, part := range parts {
+		if index, err := strconv.ParseInt(part, 10, 64); err == nil && index >= 0 {
+			path[i] = index
+			continue
+		}
+		path[i] = part
+	}
+	return path
+}
+
+// Subtrees that IPv4-only databases are mapped into when merged with IPv6
+// databases.
+const (
+	IPv4OnlySubtreesBoth   = "both"        // ::/96 and the IPv4-mapped ::ffff:0:0/96
+	IPv4OnlySubtreesMapped = "ipv4_mapped" // Only the IPv4-mapped ::ffff:0:0/96
+)
+
+// Merge strategies for MMDB output.
+const (
+	MergeStrategyError     = "error"      // Merge maps recursively, fail on other conflicts
+	MergeStrategyKeepFirst = "keep_first" // Keep the value already written
+	MergeStrategyOverwrite = "overwrite"  // Replace the value already written
+	MergeStrategyDeepMerge = "deep_merge" // Merge maps recursively, the new value wins other conflicts
+	MergeStrategyAppend    = "append"     // Merge maps recursively, collect other conflicting values in an array
+)
+
+// Parquet column encodings.
+const (
+	EncodingDictionary = "dictionary" // Dictionary of distinct values, for low-cardinality columns
+	EncodingPlain      = "plain"      // Values as-is
+	EncodingDelta      = "delta"      // Deltas between consecutive values, for integer columns
+)
+
+// Transform defines a single step in a column's value transform pipeline.
+// Which of the parameter fields apply depends on Op.
+type Transform struct {
+	Op        string  `toml:"op"`        // "lower", "upper", "trim", "round", "bool_map", "join", "truncate"
+	Digits    *int    `toml:"digits"`    // round: decimal places to keep (0-15)
+	Separator *string `toml:"separator"` // join: separator between elements (default: ",")
+	Length    int     `toml:"length"`    // truncate: maximum length in characters
+	True      *string `toml:"true"`      // bool_map: string for true values
+	False     *string `toml:"false"`     // bool_map: string for false values
+}
+
+// IsList reports whether the column produces a list of values, which is the
+// case when its path contains a wildcard and no join transform turns the list
+// back into a single string.
+func (c Column) IsList() bool {
+	if !c.Path.HasWildcard() {
+		return false
+	}
+	for _, t := range c.Transforms {
+		if t.Op == "join" {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxDecimalPrecision is the largest precision of a "decimal(p,s)" type hint.
+const MaxDecimalPrecision = 38
+
+// ParseDecimalType parses a "decimal(p,s)" type hint into its precision and
+// scale. ok is false if typeHint is not a decimal type hint at all; err is set
+// if it is one but p or s is out of range.
+func ParseDecimalType(typeHint string) (precision, scale int, ok bool, err error) {
+	args, ok := strings.CutPrefix(typeHint, "decimal(")
+	if !ok {
+		return 0, 0, false, nil
+	}
+	args, ok = strings.CutSuffix(args, ")")
+	if !ok {
+		return 0, 0, true, fmt.Errorf("invalid decimal type '%s', expected decimal(p,s)", typeHint)
+	}
+	p, s, ok := strings.Cut(args, ",")
+	if !ok {
+		return 0, 0, true, fmt.Errorf("invalid decimal type '%s', expected decimal(p,s)", typeHint)
+	}
+	precision, pErr := strconv.Atoi(strings.TrimSpace(p))
+	scale, sErr := strconv.Atoi(strings.TrimSpace(s))
+	if pErr != nil || sErr != nil {
+		return 0, 0, true, fmt.Errorf("invalid decimal type '%s', expected decimal(p,s)", typeHint)
+	}
+	if precision < 1 || precision > MaxDecimalPrecision {
+		return 0, 0, true, fmt.Errorf(
+			"decimal precision must be between 1 and %d, got %d",
+			MaxDecimalPrecision,
+			precision,
+		)
+	}
+	if scale < 0 || scale > precision {
+		return 0, 0, true, fmt.Errorf(
+			"decimal scale must be between 0 and the precision %d, got %d",
+			precision,
+			scale,
+		)
+	}
+	return precision, scale, true, nil
+}
+
+// Filter defines a predicate over data column values. Rows for which the
+// expression is not true are dropped before adjacent networks are merged.
+type Filter struct {
+	Expression string `toml:"expression"` // Boolean expression, e.g. `country_iso in ["RU", "CN"]`
 }
 
+// PathWildcard is the path segment that matches every element of a slice or
+// every value of a map.
+const PathWildcard = "*"
+
 // Path represents the decoded path segments for MMDB lookup.
 type Path []any
 
+// HasWildcard reports whether the path contains a PathWildcard segment.
+func (p Path) HasWildcard() bool {
+	for _, seg := range p {
+		if seg == PathWildcard {
+			return true
+		}
+	}
+	return false
+}
+
 // UnmarshalTOML implements toml.Unmarshaler allowing mixed string/int arrays.
 // Empty arrays are allowed - path = [] means "copy entire record".
 func (p *Path) UnmarshalTOML(v any) error {
@@ -165,6 +390,10 @@ func LoadConfig(path string) (*Config, error) {
 func applyDefaults(config *Config) {
 	// DisableCache defaults to false (zero value), no action needed
 
+	if config.IPv4OnlySubtrees == "" {
+		config.IPv4OnlySubtrees = IPv4OnlySubtreesBoth
+	}
+
 	// Output defaults
 	if config.Output.IncludeEmptyRows == nil {
 		config.Output.IncludeEmptyRows = boolPtr(false)
@@ -186,14 +415,29 @@ func applyDefaults(config *Config) {
 	if config.Output.CSV.IPv6BucketType == "" {
 		config.Output.CSV.IPv6BucketType = IPv6BucketTypeString
 	}
+	if config.Output.CSV.ListSeparator == "" {
+		config.Output.CSV.ListSeparator = ","
+	}
 
 	// Parquet defaults
 	if config.Output.Parquet.Compression == "" {
 		config.Output.Parquet.Compression = "snappy"
 	}
-	if config.Output.Parquet.RowGroupSize == 0 {
+	if config.Output.Parquet.RowGroupSize == 0 && config.Output.Parquet.RowGroupBytes == 0 {
 		config.Output.Parquet.RowGroupSize = 500000
 	}
+	if config.Output.Parquet.SchemaSampleRows == 0 {
+		config.Output.Parquet.SchemaSampleRows = 10000
+	}
+	if config.Output.Parquet.PageSize == 0 {
+		config.Output.Parquet.PageSize = 256 * 1024
+	}
+	if config.Output.Parquet.ColumnIndexSizeLimit == 0 {
+		config.Output.Parquet.ColumnIndexSizeLimit = 16
+	}
+	if config.Output.Parquet.BloomFilterBitsPerValue == 0 {
+		config.Output.Parquet.BloomFilterBitsPerValue = 10
+	}
 	if config.Output.Parquet.IPv4BucketSize == 0 {
 		config.Output.Parquet.IPv4BucketSize = 16
 	}
@@ -203,6 +447,15 @@ func applyDefaults(config *Config) {
 	if config.Output.Parquet.IPv6BucketType == "" {
 		config.Output.Parquet.IPv6BucketType = IPv6BucketTypeString
 	}
+	if config.Output.Parquet.IPv4PartitionPrefix == 0 {
+		config.Output.Parquet.IPv4PartitionPrefix = 8
+	}
+	if config.Output.Parquet.IPv6PartitionPrefix == 0 {
+		config.Output.Parquet.IPv6PartitionPrefix = 16
+	}
+	if config.Output.Parquet.MaxOpenFiles == 0 {
+		config.Output.Parquet.MaxOpenFiles = 64
+	}
 
 	// MMDB defaults
 	if config.Output.Format == formatMMDB {
@@ -212,14 +465,24 @@ func applyDefaults(config *Config) {
 		if config.Output.MMDB.IncludeReservedNetworks == nil {
 			config.Output.MMDB.IncludeReservedNetworks = boolPtr(false)
 		}
-		// Auto-populate languages from description keys if not specified
-		if len(config.Output.MMDB.Languages) == 0 {
-			for lang := range config.Output.MMDB.Description {
-				config.Output.MMDB.Languages = append(config.Output.MMDB.Languages, lang)
+		// Auto-populate languages from the locales or the description keys
+		// if not specified. With metadata_from, they are taken from the
+		// source database instead once it is opened.
+		if len(config.Output.MMDB.Languages) == 0 && config.Output.MMDB.MetadataFrom == "" {
+			if len(config.Locales) > 0 {
+				config.Output.MMDB.Languages = slices.Clone(config.Locales)
+			} else {
+				for lang := range config.Output.MMDB.Description {
+					config.Output.MMDB.Languages = append(config.Output.MMDB.Languages, lang)
+				}
 			}
 			// Sort for deterministic output
 			slices.Sort(config.Output.MMDB.Languages)
 		}
+		config.Output.MMDB.Languages = RestrictToLocales(config.Output.MMDB.Languages, config.Locales)
+		if config.Output.MMDB.Verify == VerifySample && config.Output.MMDB.VerifySampleRate == 0 {
+			config.Output.MMDB.VerifySampleRate = 0.01
+		}
 	}
 
 	// Network column defaults - apply format-specific defaults if no columns specified
@@ -276,6 +539,26 @@ func validate(config *Config) error {
 			"output.ipv4_file and output.ipv6_file cannot be used together with output.file",
 		)
 	}
+	if v := config.Output.IPVersion; v != 0 {
+		switch {
+		case v != 4 && v != 6:
+			return fmt.Errorf("output.ip_version must be 4 or 6, got %d", v)
+		case config.Output.Format == formatMMDB:
+			return errors.New(
+				"output.ip_version is not supported for MMDB output, use output.mmdb.ip_version",
+			)
+		case config.Output.IPv4File != "" || config.Output.IPv6File != "":
+			return errors.New(
+				"output.ip_version cannot be used with output.ipv4_file and output.ipv6_file",
+			)
+		}
+	}
+
+	if config.Output.Provenance && config.Output.Format == formatCSV {
+		return errors.New(
+			"output.provenance is not supported for CSV output, use output.csv.provenance_file",
+		)
+	}
 
 	// Validate Parquet compression
 	if config.Output.Format == formatParquet {
@@ -288,6 +571,54 @@ func validate(config *Config) error {
 				config.Output.Parquet.Compression,
 			)
 		}
+		if config.Output.Parquet.RowGroupSize < 0 {
+			return fmt.Errorf(
+				"output.parquet.row_group_size cannot be negative, got %d",
+				config.Output.Parquet.RowGroupSize,
+			)
+		}
+		if config.Output.Parquet.RowGroupBytes < 0 {
+			return fmt.Errorf(
+				"output.parquet.row_group_bytes cannot be negative, got %d",
+				config.Output.Parquet.RowGroupBytes,
+			)
+		}
+		if b := config.Output.Parquet.IPv4RowGroupBoundary; b < 0 || b > 32 {
+			return fmt.Errorf(
+				"output.parquet.ipv4_row_group_boundary must be between 0 and 32, got %d",
+				b,
+			)
+		}
+		if b := config.Output.Parquet.IPv6RowGroupBoundary; b < 0 || b > 128 {
+			return fmt.Errorf(
+				"output.parquet.ipv6_row_group_boundary must be between 0 and 128, got %d",
+				b,
+			)
+		}
+		if config.Output.Parquet.SchemaSampleRows < 1 {
+			return fmt.Errorf(
+				"output.parquet.schema_sample_rows must be at least 1, got %d",
+				config.Output.Parquet.SchemaSampleRows,
+			)
+		}
+		if config.Output.Parquet.PageSize < 1 {
+			return fmt.Errorf(
+				"output.parquet.page_size must be at least 1, got %d",
+				config.Output.Parquet.PageSize,
+			)
+		}
+		if config.Output.Parquet.ColumnIndexSizeLimit < 1 {
+			return fmt.Errorf(
+				"output.parquet.column_index_size_limit must be at least 1, got %d",
+				config.Output.Parquet.ColumnIndexSizeLimit,
+			)
+		}
+		if config.Output.Parquet.BloomFilterBitsPerValue < 1 {
+			return fmt.Errorf(
+				"output.parquet.bloom_filter_bits_per_value must be at least 1, got %d",
+				config.Output.Parquet.BloomFilterBitsPerValue,
+			)
+		}
 	}
 
 	// Validate MMDB configuration
@@ -307,20 +638,93 @@ func validate(config *Config) error {
 		if config.Output.IPv4File != "" || config.Output.IPv6File != "" {
 			return errors.New("split IPv4/IPv6 files not supported for MMDB output")
 		}
+
+		if v := config.Output.MMDB.IPVersion; v != 0 && v != 4 && v != 6 {
+			return fmt.Errorf("output.mmdb.ip_version must be 4 or 6, got %d", v)
+		}
+
+		if config.Output.MMDB.BuildEpoch < 0 {
+			return fmt.Errorf(
+				"output.mmdb.build_epoch must not be negative, got %d",
+				config.Output.MMDB.BuildEpoch,
+			)
+		}
+
+		if from := config.Output.MMDB.MetadataFrom; from != "" {
+			if !slices.ContainsFunc(config.Databases, func(db Database) bool {
+				return db.Name == from
+			}) {
+				return fmt.Errorf("output.mmdb.metadata_from references unknown database '%s'", from)
+			}
+		}
+
+		switch config.Output.MMDB.Verify {
+		case "", VerifyFull:
+			if config.Output.MMDB.VerifySampleRate != 0 {
+				return errors.New(
+					"output.mmdb.verify_sample_rate requires output.mmdb.verify = \"sample\"",
+				)
+			}
+		case VerifySample:
+			if rate := config.Output.MMDB.VerifySampleRate; rate <= 0 || rate > 1 {
+				return fmt.Errorf(
+					"output.mmdb.verify_sample_rate must be greater than 0 and at most 1, got %g",
+					rate,
+				)
+			}
+		default:
+			return fmt.Errorf(
+				"output.mmdb.verify must be '%s' or '%s', got '%s'",
+				VerifyFull,
+				VerifySample,
+				config.Output.MMDB.Verify,
+			)
+		}
 	}
 
-	// Validate type hints only allowed for Parquet
-	if config.Output.Format == formatCSV || config.Output.Format == formatMMDB {
+	// Validate type hints only allowed for Parquet and MMDB
+	if config.Output.Format == formatCSV {
 		for _, col := range config.Columns {
 			if col.Type != "" {
 				return fmt.Errorf(
-					"column '%s': type hints not supported for %s output (only for parquet)",
+					"column '%s': type hints not supported for %s output (only for parquet and mmdb)",
 					col.Name, config.Output.Format,
 				)
 			}
 		}
 	}
 
+	// Validate the subtrees IPv4-only databases are mapped into
+	switch config.IPv4OnlySubtrees {
+	case "", IPv4OnlySubtreesBoth:
+	case IPv4OnlySubtreesMapped:
+		if config.Output.NetworkIPVersion() == 4 {
+			return errors.New(
+				"ipv4_only_subtrees = \"ipv4_mapped\" cannot be used with IPv4-only output, " +
+					"which only has the ::/96 subtree",
+			)
+		}
+	default:
+		return fmt.Errorf(
+			"ipv4_only_subtrees must be '%s' or '%s', got '%s'",
+			IPv4OnlySubtreesBoth,
+			IPv4OnlySubtreesMapped,
+			config.IPv4OnlySubtrees,
+		)
+	}
+
+	// Validate locales
+	seenLocales := map[string]bool{}
+	for _, locale := range config.Locales {
+		if locale == "" {
+			return errors.New("locales must not contain empty entries")
+		}
+		if seenLocales[locale] {
+			return fmt.Errorf("duplicate locale '%s'", locale)
+		}
+		seenLocales[locale] = true
+	}
+
 	// Validate databases
 	if len(config.Databases) == 0 {
 		return errors.New("at least one database is required")
@@ -344,6 +748,7 @@ func validate(config *Config) error {
 	// Validate network columns
 	validNetworkTypes := map[string]bool{
 		"cidr": true, "start_ip": true, "end_ip": true, "start_int": true, "end_int": true,
+		"start_int_hi": true, "start_int_lo": true, "end_int_hi": true, "end_int_lo": true,
 		"network_bucket": true,
 	}
 	networkColNames := map[mmdbtype.String]bool{}
@@ -357,11 +762,14 @@ func validate(config *Config) error {
 		}
 		if !validNetworkTypes[col.Type] {
 			return fmt.Errorf(
-				"invalid network column type '%s' for column '%s', must be one of: cidr, start_ip, end_ip, start_int, end_int, network_bucket",
+				"invalid network column type '%s' for column '%s', must be one of: cidr, start_ip, end_ip, start_int, end_int, start_int_hi, start_int_lo, end_int_hi, end_int_lo, network_bucket",
 				col.Type,
 				col.Name,
 			)
 		}
+		if err := validateEncoding(config.Output.Format, string(col.Name), col.Encoding); err != nil {
+			return err
+		}
 		if col.Type == "network_bucket" {
 			hasBucketColumn = true
 		}
@@ -379,10 +787,12 @@ func validate(config *Config) error {
 		}
 
 		// network_bucket column requires split files (different types for IPv4 vs
-		// IPv6)
-		if config.Output.IPv4File == "" || config.Output.IPv6File == "" {
+		// IPv6) unless the output is restricted to one IP version
+		if (config.Output.IPv4File == "" || config.Output.IPv6File == "") &&
+			!(config.Output.Format == formatParquet && config.Output.Parquet.SplitsIPVersions()) &&
+			config.Output.IPVersion == 0 {
 			return errors.New(
-				"network_bucket column requires split files (ipv4_file and ipv6_file)",
+				"network_bucket column requires split files (ipv4_file and ipv6_file), output.ip_version, or a dataset partitioned by ip_version or network_prefix",
 			)
 		}
 
@@ -393,33 +803,69 @@ func validate(config *Config) error {
 
 	// Validate data columns
 	validDataTypes := map[string]bool{
-		"": true, "string": true, "int64": true, "float64": true, "bool": true, "binary": true,
+		"": true, "string": true, "int64": true, "uint32": true, "uint64": true, "uint128": true,
+		"float64": true, "bool": true, "binary": true,
+		"struct": true, "list": true, "map": true, "auto": true,
+	}
+	validMMDBTypes := map[string]bool{
+		"": true, "string": true, "bool": true, "uint16": true, "uint32": true, "uint64": true,
+		"int32": true, "float32": true, "double": true,
 	}
 	dataColNames := map[mmdbtype.String]bool{}
-	for _, col := range config.Columns {
+	for i, col := range config.Columns {
 		if col.Name == "" {
 			return errors.New("column name is required")
 		}
-		if col.Database == "" {
-			return fmt.Errorf("column database is required for column '%s'", col.Name)
+		if err := validateColumnSource(config.Columns[:i], col, dbNames); err != nil {
+			return err
 		}
-		// Empty path is allowed - path = [] means "copy entire record"
 
-		// Validate database reference
-		if !dbNames[col.Database] {
-			return fmt.Errorf(
-				"column '%s' references unknown database '%s'",
-				col.Name,
-				col.Database,
-			)
+		if err := validateTransforms(col); err != nil {
+			return err
+		}
+
+		if err := validateExpand(col); err != nil {
+			return err
 		}
 
 		// Validate type hint
-		if !validDataTypes[col.Type] {
+		if config.Output.Format == formatMMDB {
+			if !validMMDBTypes[col.Type] {
+				return fmt.Errorf(
+					"invalid type '%s' for column '%s' in MMDB output, must be one of: string, bool, uint16, uint32, uint64, int32, float32, double",
+					col.Type,
+					col.Name,
+				)
+			}
+		} else {
+			_, _, isDecimal, err := ParseDecimalType(col.Type)
+			if err != nil {
+				return fmt.Errorf("column '%s': %w", col.Name, err)
+			}
+			if !isDecimal && !validDataTypes[col.Type] {
+				return fmt.Errorf(
+					"invalid type '%s' for column '%s', must be one of: string, int64, uint32, uint64, uint128, decimal(p,s), float64, bool, binary, struct, list, map, auto",
+					col.Type,
+					col.Name,
+				)
+			}
+		}
+
+		if err := validateEncoding(config.Output.Format, string(col.Name), col.Encoding); err != nil {
+			return err
+		}
+		if err := validateMergeStrategy(config.Output.Format, col); err != nil {
+			return err
+		}
+		if err := validateKeyOptions(config.Output.Format, col); err != nil {
+			return err
+		}
+		if col.Encoding != "" &&
+			(col.Type == "struct" || col.Type == "list" || col.Type == "map" || col.Type == "auto") {
 			return fmt.Errorf(
-				"invalid type '%s' for column '%s', must be one of: string, int64, float64, bool, binary",
-				col.Type,
+				"column '%s': encoding is not supported for nested type '%s'",
 				col.Name,
+				col.Type,
 			)
 		}
 
@@ -436,11 +882,526 @@ func validate(config *Config) error {
 		dataColNames[col.Name] = true
 
 		// Empty output_path is allowed - it means merge into root for MMDB output
+		if col.OutputPath != nil && col.OutputPath.HasWildcard() {
+			return fmt.Errorf(
+				"column '%s': output_path cannot contain wildcard segment '%s'",
+				col.Name,
+				PathWildcard,
+			)
+		}
+		if col.OutputPath != nil && len(*col.OutputPath) > 0 {
+			if err := validateKeyPath(*col.OutputPath); err != nil {
+				return fmt.Errorf("column '%s': invalid output_path: %w", col.Name, err)
+			}
+			if _, ok := (*col.OutputPath)[0].(string); !ok {
+				return fmt.Errorf(
+					"column '%s': output_path must start with a key name, not an array index",
+					col.Name,
+				)
+			}
+		}
+	}
+
+	if err := validateBloomFilterColumns(config); err != nil {
+		return err
+	}
+
+	if err := validateDataset(config); err != nil {
+		return err
+	}
+
+	return validateFilters(config)
+}
+
+// validateBloomFilterColumns checks that each bloom filter column names a
+// network column or a data column with a scalar Parquet type.
+func validateBloomFilterColumns(config *Config) error {
+	if len(config.Output.Parquet.BloomFilterColumns) == 0 {
+		return nil
+	}
+	if config.Output.Format != formatParquet {
+		return errors.New("output.parquet.bloom_filter_columns requires Parquet output")
+	}
+	if hasPendingExpansion(config.Columns) {
+		// Expanded column names are only known after ExpandColumns.
+		return nil
 	}
 
+	columns := map[string]*Column{}
+	for _, col := range config.Network.Columns {
+		columns[string(col.Name)] = nil
+	}
+	for i := range config.Columns {
+		columns[string(config.Columns[i].Name)] = &config.Columns[i]
+	}
+
+	seen := map[string]bool{}
+	for _, name := range config.Output.Parquet.BloomFilterColumns {
+		col, exists := columns[name]
+		if !exists {
+			return fmt.Errorf("bloom filter column '%s' is not a configured column", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate bloom filter column '%s'", name)
+		}
+		seen[name] = true
+
+		if col == nil {
+			continue // Network column
+		}
+		switch {
+		case col.IsList():
+			return fmt.Errorf("bloom filter column '%s' is a list column", name)
+		case col.Type == "struct" || col.Type == "list" || col.Type == "map" || col.Type == "auto":
+			return fmt.Errorf("bloom filter column '%s' has nested type '%s'", name, col.Type)
+		}
+	}
+	return nil
+}
+
+// validateDataset checks the Parquet dataset and table options. Each
+// partition key must be ip_version, network_prefix, or a data column with
+// scalar values, and is not also written as a column of the dataset files.
+func validateDataset(config *Config) error {
+	p := config.Output.Parquet
+	if p.MaxRowsPerFile < 0 {
+		return fmt.Errorf(
+			"output.parquet.max_rows_per_file cannot be negative, got %d",
+			p.MaxRowsPerFile,
+		)
+	}
+	if p.TableFormat != "" && p.TableFormat != TableFormatDelta {
+		return fmt.Errorf(
+			"output.parquet.table_format must be '%s', got '%s'",
+			TableFormatDelta,
+			p.TableFormat,
+		)
+	}
+	if !p.IsDataset() {
+		return nil
+	}
+	if config.Output.Format != formatParquet {
+		return errors.New(
+			"output.parquet.partition_by, max_rows_per_file, and table_format require Parquet output",
+		)
+	}
+	if config.Output.IPv4File != "" || config.Output.IPv6File != "" {
+		return errors.New(
+			"output.ipv4_file and output.ipv6_file cannot be used with a partitioned dataset; partition by ip_version instead",
+		)
+	}
+	if p.MaxOpenFiles < 1 {
+		return fmt.Errorf(
+			"output.parquet.max_open_files must be at least 1, got %d",
+			p.MaxOpenFiles,
+		)
+	}
+	if p.IPv4PartitionPrefix < 1 || p.IPv4PartitionPrefix > 32 {
+		return fmt.Errorf(
+			"output.parquet.ipv4_partition_prefix must be between 1 and 32, got %d",
+			p.IPv4PartitionPrefix,
+		)
+	}
+	if p.IPv6PartitionPrefix < 1 || p.IPv6PartitionPrefix > 128 {
+		return fmt.Errorf(
+			"output.parquet.ipv6_partition_prefix must be between 1 and 128, got %d",
+			p.IPv6PartitionPrefix,
+		)
+	}
+
+	columns := map[string]*Column{}
+	for i := range config.Columns {
+		columns[string(config.Columns[i].Name)] = &config.Columns[i]
+	}
+	networkColumns := map[string]bool{}
+	for _, col := range config.Network.Columns {
+		networkColumns[string(col.Name)] = true
+	}
+
+	seen := map[string]bool{}
+	for _, key := range p.PartitionBy {
+		if seen[key] {
+			return fmt.Errorf("duplicate partition key '%s'", key)
+		}
+		seen[key] = true
+
+		if slices.Contains(p.BloomFilterColumns, key) {
+			return fmt.Errorf(
+				"bloom filter column '%s' is a partition key and is not written to the dataset files",
+				key,
+			)
+		}
+
+		col, isColumn := columns[key]
+		if key == PartitionIPVersion || key == PartitionNetworkPrefix {
+			if isColumn || networkColumns[key] {
+				return fmt.Errorf(
+					"partition key '%s' is ambiguous: a column has the same name",
+					key,
+				)
+			}
+			continue
+		}
+		if networkColumns[key] {
+			return fmt.Errorf(
+				"partition key '%s' is a network column; partition by ip_version or network_prefix instead",
+				key,
+			)
+		}
+		if hasPendingExpansion(config.Columns) {
+			// Expanded column names are only known after ExpandColumns.
+			continue
+		}
+		switch {
+		case !isColumn:
+			return fmt.Errorf("partition key '%s' is not a configured column", key)
+		case col.IsList():
+			return fmt.Errorf("partition key '%s' is a list column", key)
+		case col.Type == "struct" || col.Type == "list" || col.Type == "map" || col.Type == "auto":
+			return fmt.Errorf("partition key '%s' has nested type '%s'", key, col.Type)
+		}
+	}
+	return nil
+}
+
+// validateColumnSource checks that a data column either reads from a
+// configured database or is computed from an expression over the columns
+// defined before it.
+func validateColumnSource(previous []Column, col Column, dbNames map[string]bool) error {
+	if col.Expression != "" {
+		if col.Database != "" || col.Path != nil {
+			return fmt.Errorf(
+				"column '%s': expression cannot be combined with database or path",
+				col.Name,
+			)
+		}
+		if hasPendingExpansion(previous) {
+			// Expression identifiers are bound once ExpandColumns has
+			// produced the final column names.
+			return nil
+		}
+		if _, err := expr.Compile(col.Expression, ColumnNames(previous)); err != nil {
+			return fmt.Errorf(
+				"column '%s': invalid expression (computed columns may only reference columns defined before them): %w",
+				col.Name,
+				err,
+			)
+		}
+		return nil
+	}
+
+	if col.Database == "" {
+		return fmt.Errorf("column database is required for column '%s'", col.Name)
+	}
+	// Empty path is allowed - path = [] means "copy entire record"
+
+	// Validate database reference
+	if !dbNames[col.Database] {
+		return fmt.Errorf(
+			"column '%s' references unknown database '%s'",
+			col.Name,
+			col.Database,
+		)
+	}
+	return nil
+}
+
+// validateTransforms checks that each transform of a column uses a known op
+// with the parameters that op requires.
+func validateTransforms(col Column) error {
+	for i, t := range col.Transforms {
+		prefix := fmt.Sprintf("column '%s' transform %d", col.Name, i+1)
+		switch t.Op {
+		case "lower", "upper", "trim":
+		case "round":
+			if t.Digits == nil {
+				return fmt.Errorf("%s: round requires digits", prefix)
+			}
+			if *t.Digits < 0 || *t.Digits > 15 {
+				return fmt.Errorf(
+					"%s: round digits must be between 0 and 15, got %d",
+					prefix,
+					*t.Digits,
+				)
+			}
+		case "bool_map":
+			if t.True == nil || t.False == nil {
+				return fmt.Errorf("%s: bool_map requires both true and false", prefix)
+			}
+		case "join":
+		case "truncate":
+			if t.Length < 1 {
+				return fmt.Errorf(
+					"%s: truncate length must be at least 1, got %d",
+					prefix,
+					t.Length,
+				)
+			}
+		case "":
+			return fmt.Errorf("%s: op is required", prefix)
+		default:
+			return fmt.Errorf(
+				"%s: invalid op '%s', must be one of: lower, upper, trim, round, bool_map, join, truncate",
+				prefix,
+				t.Op,
+			)
+		}
+	}
+	return nil
+}
+
+// validateFilters checks that every filter expression parses and only
+// references configured data columns.
+func validateFilters(config *Config) error {
+	columnNames := ColumnNames(config.Columns)
+	pending := hasPendingExpansion(config.Columns)
+	for i, filter := range config.Filters {
+		if filter.Expression == "" {
+			return fmt.Errorf("filter %d: expression is required", i+1)
+		}
+		if pending {
+			continue
+		}
+		if _, err := expr.Compile(filter.Expression, columnNames); err != nil {
+			return fmt.Errorf("filter %d (%s): %w", i+1, filter.Expression, err)
+		}
+	}
 	return nil
 }
 
+// validateExpand checks a column's expand option.
+func validateExpand(col Column) error {
+	switch col.Expand {
+	case "":
+		return nil
+	case ExpandKeys:
+	default:
+		return fmt.Errorf(
+			"invalid expand '%s' for column '%s', must be: %s",
+			col.Expand,
+			col.Name,
+			ExpandKeys,
+		)
+	}
+
+	if col.Expression != "" {
+		return fmt.Errorf("column '%s': expand cannot be combined with expression", col.Name)
+	}
+	if col.Path.HasWildcard() {
+		return fmt.Errorf(
+			"column '%s': expand cannot be combined with wildcard path segments",
+			col.Name,
+		)
+	}
+	return nil
+}
+
+// validateEncoding checks the encoding of a Parquet column.
+func validateEncoding(format, columnName, encoding string) error {
+	switch encoding {
+	case "":
+		return nil
+	case EncodingDictionary, EncodingPlain, EncodingDelta:
+	default:
+		return fmt.Errorf(
+			"invalid encoding '%s' for column '%s', must be one of: dictionary, plain, delta",
+			encoding,
+			columnName,
+		)
+	}
+	if format != formatParquet {
+		return fmt.Errorf("column '%s': encoding is only supported for parquet output", columnName)
+	}
+	return nil
+}
+
+// validateMergeStrategy checks the MMDB merge strategy of a data column.
+func validateMergeStrategy(format string, col Column) error {
+	switch col.MergeStrategy {
+	case "":
+		return nil
+	case MergeStrategyError, MergeStrategyKeepFirst, MergeStrategyOverwrite,
+		MergeStrategyDeepMerge, MergeStrategyAppend:
+	default:
+		return fmt.Errorf(
+			"invalid merge_strategy '%s' for column '%s', must be one of: error, keep_first, overwrite, deep_merge, append",
+			col.MergeStrategy,
+			col.Name,
+		)
+	}
+	if format != formatMMDB {
+		return fmt.Errorf("column '%s': merge_strategy is only supported for mmdb output", col.Name)
+	}
+	return nil
+}
+
+// validateKeyOptions checks a column's include_keys, exclude_keys, and rename
+// options, which are only supported for MMDB output.
+func validateKeyOptions(format string, col Column) error {
+	if len(col.IncludeKeys) == 0 && len(col.ExcludeKeys) == 0 && len(col.Rename) == 0 {
+		return nil
+	}
+	if format != formatMMDB {
+		return fmt.Errorf(
+			"column '%s': include_keys, exclude_keys, and rename are only supported for mmdb output",
+			col.Name,
+		)
+	}
+
+	for _, option := range []struct {
+		name  string
+		paths []Path
+	}{
+		{"include_keys", col.IncludeKeys},
+		{"exclude_keys", col.ExcludeKeys},
+	} {
+		for _, path := range option.paths {
+			if err := validateKeyPath(path); err != nil {
+				return fmt.Errorf(
+					"column '%s': invalid %s entry %v: %w",
+					col.Name, option.name, path, err,
+				)
+			}
+		}
+	}
+
+	for from, to := range col.Rename {
+		path := SplitKeyPath(from)
+		if err := validateKeyPath(path); err != nil {
+			return fmt.Errorf("column '%s': invalid rename of '%s': %w", col.Name, from, err)
+		}
+		if last, ok := path[len(path)-1].(string); !ok || last == PathWildcard {
+			return fmt.Errorf(
+				"column '%s': invalid rename of '%s': the path must end in a key name",
+				col.Name,
+				from,
+			)
+		}
+		if to == "" {
+			return fmt.Errorf("column '%s': rename of '%s' must not be empty", col.Name, from)
+		}
+	}
+	return nil
+}
+
+// validateKeyPath checks that a key path is not empty and consists of
+// non-empty keys and non-negative array indexes.
+func validateKeyPath(path Path) error {
+	if len(path) == 0 {
+		return errors.New("path must not be empty")
+	}
+	for _, seg := range path {
+		switch s := seg.(type) {
+		case string:
+			if s == "" {
+				return errors.New("keys must not be empty")
+			}
+		case int64:
+			if s < 0 {
+				return fmt.Errorf("array index %d must not be negative", s)
+			}
+		case int:
+			if s < 0 {
+				return fmt.Errorf("array index %d must not be negative", s)
+			}
+		default:
+			return fmt.Errorf("segments must be strings or integers, got %T", seg)
+		}
+	}
+	return nil
+}
+
+// hasPendingExpansion reports whether any of the columns still has to be
+// expanded by ExpandColumns.
+func hasPendingExpansion(columns []Column) bool {
+	for _, col := range columns {
+		if col.Expand != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandColumns replaces each column with expand = "keys" by one column per
+// language of its database, as given by languages (database name to the
+// languages listed in its metadata). For language "en", column "city_name"
+// with path ["city", "names"] becomes "city_name_en" with path
+// ["city", "names", "en"]; hyphens in the language are replaced by
+// underscores in the column name, so "zh-CN" gives "city_name_zh_CN". An
+// output_path gets the language appended the same way. With locales set, only
+// the languages among them are expanded. The expanded config is
+// validated again, which also binds any expressions to the final names.
+func ExpandColumns(config *Config, languages map[string][]string) error {
+	if !hasPendingExpansion(config.Columns) {
+		return nil
+	}
+
+	var columns []Column
+	for _, col := range config.Columns {
+		if col.Expand == "" {
+			columns = append(columns, col)
+			continue
+		}
+
+		langs := languages[col.Database]
+		if len(langs) == 0 {
+			return fmt.Errorf(
+				"cannot expand column '%s': database '%s' lists no languages in its metadata",
+				col.Name,
+				col.Database,
+			)
+		}
+		langs = RestrictToLocales(langs, config.Locales)
+		if len(langs) == 0 {
+			return fmt.Errorf(
+				"cannot expand column '%s': database '%s' lists none of the configured locales",
+				col.Name,
+				col.Database,
+			)
+		}
+		for _, lang := range langs {
+			expanded := col
+			expanded.Expand = ""
+			expanded.Name = mmdbtype.String(
+				string(col.Name) + "_" + strings.ReplaceAll(lang, "-", "_"),
+			)
+			expanded.Path = append(slices.Clone(col.Path), lang)
+			if col.OutputPath != nil {
+				outputPath := append(slices.Clone(*col.OutputPath), lang)
+				expanded.OutputPath = &outputPath
+			}
+			columns = append(columns, expanded)
+		}
+	}
+	config.Columns = columns
+
+	if err := validate(config); err != nil {
+		return fmt.Errorf("invalid configuration after expanding columns: %w", err)
+	}
+	return nil
+}
+
+// RestrictToLocales returns the languages that are among locales, keeping
+// their order. All languages are returned if locales is empty.
+func RestrictToLocales(languages, locales []string) []string {
+	if len(locales) == 0 {
+		return languages
+	}
+	return slices.DeleteFunc(slices.Clone(languages), func(lang string) bool {
+		return !slices.Contains(locales, lang)
+	})
+}
+
+// ColumnNames returns the names of the given data columns in order, for
+// binding expression identifiers to column indexes.
+func ColumnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = string(col.Name)
+	}
+	return names
+}
+
 // validateBucketConfig validates bucket configuration for CSV or Parquet output.
 func validateBucketConfig(config *Config) error {
 	var ipv4BucketSize, ipv6BucketSize int
@@ -69,6 +69,32 @@ path = ["country", "iso_code"]
 				assertPathEquals(t, cfg.Columns[0].Path, "country", "iso_code")
 			},
 		},
+		{
+			name: "network_bucket with output.ip_version",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+ip_version = 6
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, 6, cfg.Output.IPVersion)
+				require.Equal(t, 6, cfg.Output.NetworkIPVersion())
+			},
+		},
 		{
 			name: "per-IP version files",
 			toml: `
@@ -508,6 +534,284 @@ path = ["country", "iso_code"]
 				}
 			},
 		},
+		{
+			name: "row filters",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "anon"
+path = "/path/to/anon.mmdb"
+
+[[columns]]
+name = "is_anonymous_vpn"
+database = "anon"
+path = ["is_anonymous_vpn"]
+
+[[filters]]
+expression = "is_anonymous_vpn == true"
+
+[[filters]]
+expression = 'is_anonymous_vpn is not null'
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.Filters) != 2 {
+					t.Fatalf("expected 2 filters, got %d", len(cfg.Filters))
+				}
+				if cfg.Filters[0].Expression != "is_anonymous_vpn == true" {
+					t.Errorf("unexpected first filter: %q", cfg.Filters[0].Expression)
+				}
+			},
+		},
+		{
+			name: "computed columns",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country_iso"
+database = "geo"
+path = ["country", "iso_code"]
+
+[[columns]]
+name = "is_eu"
+expression = 'country_iso in ["DE", "FR"]'
+type = "bool"
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.Columns) != 2 {
+					t.Fatalf("expected 2 columns, got %d", len(cfg.Columns))
+				}
+				col := cfg.Columns[1]
+				if col.Expression != `country_iso in ["DE", "FR"]` {
+					t.Errorf("unexpected expression: %q", col.Expression)
+				}
+				if col.Database != "" || col.Path != nil {
+					t.Errorf("expected no database or path, got %q %v", col.Database, col.Path)
+				}
+			},
+		},
+		{
+			name: "column transforms",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "latitude"
+database = "geo"
+path = ["location", "latitude"]
+transforms = [{ op = "round", digits = 2 }]
+
+[[columns]]
+name = "is_eu"
+database = "geo"
+path = ["country", "is_in_european_union"]
+transforms = [{ op = "bool_map", true = "yes", false = "no" }]
+
+[[columns]]
+name = "country_iso"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ op = "lower" }, { op = "truncate", length = 2 }]
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				lat := cfg.Columns[0].Transforms
+				if len(lat) != 1 || lat[0].Op != "round" || lat[0].Digits == nil ||
+					*lat[0].Digits != 2 {
+					t.Errorf("unexpected latitude transforms: %+v", lat)
+				}
+				eu := cfg.Columns[1].Transforms
+				if len(eu) != 1 || eu[0].True == nil || *eu[0].True != "yes" ||
+					eu[0].False == nil || *eu[0].False != "no" {
+					t.Errorf("unexpected is_eu transforms: %+v", eu)
+				}
+				iso := cfg.Columns[2].Transforms
+				if len(iso) != 2 || iso[0].Op != "lower" || iso[1].Length != 2 {
+					t.Errorf("unexpected country_iso transforms: %+v", iso)
+				}
+			},
+		},
+		{
+			name: "expanded column referenced before expansion",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "city_name"
+database = "geo"
+path = ["city", "names"]
+expand = "keys"
+
+[[columns]]
+name = "city_label"
+expression = "coalesce(city_name_en, city_name_de)"
+
+[[filters]]
+expression = "city_name_en is not null"
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				// Expressions are bound once ExpandColumns knows the languages.
+				if cfg.Columns[0].Expand != ExpandKeys {
+					t.Errorf("expected expand=%q, got %q", ExpandKeys, cfg.Columns[0].Expand)
+				}
+			},
+		},
+		{
+			name: "partitioned Parquet dataset",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["ip_version", "country"]
+max_rows_per_file = 1000000
+
+[[network.columns]]
+name = "start_int"
+type = "start_int"
+
+[[network.columns]]
+name = "bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				p := cfg.Output.Parquet
+				if !p.IsDataset() || !p.SplitsIPVersions() {
+					t.Errorf("expected a dataset split by IP version: %+v", p)
+				}
+				if p.MaxRowsPerFile != 1000000 {
+					t.Errorf("expected max_rows_per_file=1000000, got %d", p.MaxRowsPerFile)
+				}
+				if p.IPv4PartitionPrefix != 8 || p.IPv6PartitionPrefix != 16 {
+					t.Errorf(
+						"expected partition prefixes 8 and 16, got %d and %d",
+						p.IPv4PartitionPrefix,
+						p.IPv6PartitionPrefix,
+					)
+				}
+				if p.MaxOpenFiles != 64 {
+					t.Errorf("expected max_open_files=64, got %d", p.MaxOpenFiles)
+				}
+			},
+		},
+		{
+			name: "MMDB type hints",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "accuracy_radius"
+database = "geo"
+path = ["location", "accuracy_radius"]
+type = "uint16"
+
+[[columns]]
+name = "latitude"
+database = "geo"
+path = ["location", "latitude"]
+type = "float32"
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, "uint16", cfg.Columns[0].Type)
+				require.Equal(t, "float32", cfg.Columns[1].Type)
+			},
+		},
+		{
+			name: "MMDB output_path with array indexes",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "subdivision"
+database = "geo"
+path = ["region"]
+output_path = ["subdivisions", 0, "iso_code"]
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, &Path{"subdivisions", int64(0), "iso_code"}, cfg.Columns[0].OutputPath)
+			},
+		},
+		{
+			name: "MMDB key pruning and renaming",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "record"
+database = "geo"
+path = []
+output_path = []
+include_keys = [["*", "names", "en"], ["subdivisions", "*", "names", "en"]]
+exclude_keys = [["traits"]]
+rename = { "location.time_zone" = "tz" }
+`,
+			validate: func(t *testing.T, cfg *Config) {
+				col := cfg.Columns[0]
+				require.Equal(t, []Path{
+					{"*", "names", "en"},
+					{"subdivisions", "*", "names", "en"},
+				}, col.IncludeKeys)
+				require.Equal(t, []Path{{"traits"}}, col.ExcludeKeys)
+				require.Equal(t, map[string]string{"location.time_zone": "tz"}, col.Rename)
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -851,15 +1155,13 @@ path = ["country", "iso_code"]
 			expectError: "invalid parquet compression 'invalid'",
 		},
 		{
-			name: "network_bucket without split files",
+			name: "invalid output.ip_version",
 			toml: `
 [output]
-format = "parquet"
-file = "output.parquet"
+format = "csv"
+file = "output.csv"
+ip_version = 5
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
 
 [[databases]]
 name = "geo"
@@ -870,22 +1172,18 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "network_bucket column requires split files",
+			expectError: "output.ip_version must be 4 or 6, got 5",
 		},
 		{
-			name: "network_bucket with MMDB format",
+			name: "output.ip_version with MMDB format",
 			toml: `
 [output]
 format = "mmdb"
 file = "output.mmdb"
+ip_version = 4
 
 [output.mmdb]
 database_type = "GeoIP2-City"
-description = { en = "Test" }
-
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
 
 [[databases]]
 name = "geo"
@@ -896,22 +1194,15 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "network_bucket column type is only supported for CSV and Parquet output",
+			expectError: "output.ip_version is not supported for MMDB output, use output.mmdb.ip_version",
 		},
 		{
-			name: "duplicate network column names",
+			name: "output.provenance with CSV",
 			toml: `
 [output]
 format = "csv"
 file = "output.csv"
-
-[[network.columns]]
-name = "network"
-type = "cidr"
-
-[[network.columns]]
-name = "network"
-type = "start_ip"
+provenance = true
 
 [[databases]]
 name = "geo"
@@ -922,44 +1213,39 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "duplicate network column name 'network'",
+			expectError: "output.provenance is not supported for CSV output, use output.csv.provenance_file",
 		},
 		{
-			name: "column name conflicts with network column",
+			name: "output.ip_version with split files",
 			toml: `
 [output]
 format = "csv"
-file = "output.csv"
+ipv4_file = "output_ipv4.csv"
+ipv6_file = "output_ipv6.csv"
+ip_version = 4
 
-[[network.columns]]
-name = "network"
-type = "cidr"
 
 [[databases]]
 name = "geo"
 path = "/path/to/geo.mmdb"
 
 [[columns]]
-name = "network"
+name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "duplicate column name 'network' (already used as network column)",
+			expectError: "output.ip_version cannot be used with output.ipv4_file and output.ipv6_file",
 		},
 		{
-			name: "ipv4_bucket_size too large",
+			name: "invalid MMDB ip_version",
 			toml: `
 [output]
-format = "parquet"
-ipv4_file = "output-v4.parquet"
-ipv6_file = "output-v6.parquet"
-
-[output.parquet]
-ipv4_bucket_size = 33
+format = "mmdb"
+file = "output.mmdb"
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+[output.mmdb]
+database_type = "GeoIP2-City"
+ip_version = 5
 
 [[databases]]
 name = "geo"
@@ -970,22 +1256,1178 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "ipv4_bucket_size must be between 1 and 32",
+			expectError: "output.mmdb.ip_version must be 4 or 6, got 5",
 		},
 		{
-			name: "ipv4_bucket_size negative",
+			name: "network_bucket without split files",
 			toml: `
 [output]
 format = "parquet"
-ipv4_file = "output-v4.parquet"
-ipv6_file = "output-v6.parquet"
+file = "output.parquet"
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "network_bucket column requires split files",
+		},
+		{
+			name: "Parquet type hint with MMDB format",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "geoname_id"
+database = "geo"
+path = ["city", "geoname_id"]
+type = "int64"
+`,
+			expectError: "invalid type 'int64' for column 'geoname_id' in MMDB output",
+		},
+		{
+			name: "negative MMDB build_epoch",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+build_epoch = -1
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.mmdb.build_epoch must not be negative",
+		},
+		{
+			name: "MMDB metadata_from unknown database",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+metadata_from = "city"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.mmdb.metadata_from references unknown database 'city'",
+		},
+		{
+			name: "invalid MMDB verify mode",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+verify = "all"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.mmdb.verify must be 'full' or 'sample', got 'all'",
+		},
+		{
+			name: "invalid ipv4_only_subtrees",
+			toml: `
+ipv4_only_subtrees = "ipv4"
+
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-Country"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: `ipv4_only_subtrees must be 'both' or 'ipv4_mapped', got 'ipv4'`,
+		},
+		{
+			name: "ipv4_only_subtrees ipv4_mapped with IPv4 output",
+			toml: `
+ipv4_only_subtrees = "ipv4_mapped"
+
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-Country"
+ip_version = 4
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: `ipv4_only_subtrees = "ipv4_mapped" cannot be used with IPv4-only output`,
+		},
+		{
+			name: "negative output_path index",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "subdivision"
+database = "geo"
+path = ["subdivisions", 0, "iso_code"]
+output_path = ["subdivisions", -1, "iso_code"]
+`,
+			expectError: "column 'subdivision': invalid output_path: array index -1 must not be negative",
+		},
+		{
+			name: "output_path starting with an index",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "subdivision"
+database = "geo"
+path = ["subdivisions", 0, "iso_code"]
+output_path = [0, "iso_code"]
+`,
+			expectError: "column 'subdivision': output_path must start with a key name, not an array index",
+		},
+		{
+			name: "empty locale",
+			toml: `
+locales = ["en", ""]
+
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "locales must not contain empty entries",
+		},
+		{
+			name: "duplicate locale",
+			toml: `
+locales = ["en", "en"]
+
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "duplicate locale 'en'",
+		},
+		{
+			name: "include_keys with CSV format",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country"]
+include_keys = [["iso_code"]]
+`,
+			expectError: "column 'country': include_keys, exclude_keys, and rename are only supported for mmdb output",
+		},
+		{
+			name: "empty exclude_keys entry",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country"]
+exclude_keys = [[]]
+`,
+			expectError: "column 'country': invalid exclude_keys entry []: path must not be empty",
+		},
+		{
+			name: "rename ending in wildcard",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country"]
+rename = { "names.*" = "name" }
+`,
+			expectError: "column 'country': invalid rename of 'names.*': the path must end in a key name",
+		},
+		{
+			name: "empty rename target",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country"]
+rename = { iso_code = "" }
+`,
+			expectError: "column 'country': rename of 'iso_code' must not be empty",
+		},
+		{
+			name: "MMDB verify_sample_rate out of range",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+verify = "sample"
+verify_sample_rate = 1.5
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.mmdb.verify_sample_rate must be greater than 0 and at most 1, got 1.5",
+		},
+		{
+			name: "MMDB verify_sample_rate without sampling",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+verify = "full"
+verify_sample_rate = 0.1
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.mmdb.verify_sample_rate requires output.mmdb.verify = \"sample\"",
+		},
+		{
+			name: "network_bucket with MMDB format",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "GeoIP2-City"
+description = { en = "Test" }
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "network_bucket column type is only supported for CSV and Parquet output",
+		},
+		{
+			name: "duplicate network column names",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[network.columns]]
+name = "network"
+type = "cidr"
+
+[[network.columns]]
+name = "network"
+type = "start_ip"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "duplicate network column name 'network'",
+		},
+		{
+			name: "column name conflicts with network column",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[network.columns]]
+name = "network"
+type = "cidr"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "network"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "duplicate column name 'network' (already used as network column)",
+		},
+		{
+			name: "ipv4_bucket_size too large",
+			toml: `
+[output]
+format = "parquet"
+ipv4_file = "output-v4.parquet"
+ipv6_file = "output-v6.parquet"
+
+[output.parquet]
+ipv4_bucket_size = 33
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv4_bucket_size must be between 1 and 32",
+		},
+		{
+			name: "ipv4_bucket_size negative",
+			toml: `
+[output]
+format = "parquet"
+ipv4_file = "output-v4.parquet"
+ipv6_file = "output-v6.parquet"
+
+[output.parquet]
+ipv4_bucket_size = -1
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv4_bucket_size must be between 1 and 32",
+		},
+		{
+			name: "ipv6_bucket_size too large",
+			toml: `
+[output]
+format = "parquet"
+ipv4_file = "output-v4.parquet"
+ipv6_file = "output-v6.parquet"
+
+[output.parquet]
+ipv6_bucket_size = 61
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv6_bucket_size must be between 1 and 60",
+		},
+		{
+			name: "invalid ipv6_bucket_type",
+			toml: `
+[output]
+format = "parquet"
+ipv4_file = "output-v4.parquet"
+ipv6_file = "output-v6.parquet"
+
+[output.parquet]
+ipv6_bucket_type = "invalid"
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv6_bucket_type must be 'string' or 'int'",
+		},
+		{
+			name: "csv ipv4_bucket_size too large",
+			toml: `
+[output]
+format = "csv"
+ipv4_file = "output-v4.csv"
+ipv6_file = "output-v6.csv"
+
+[output.csv]
+ipv4_bucket_size = 33
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv4_bucket_size must be between 1 and 32",
+		},
+		{
+			name: "csv ipv6_bucket_size too large",
+			toml: `
+[output]
+format = "csv"
+ipv4_file = "output-v4.csv"
+ipv6_file = "output-v6.csv"
+
+[output.csv]
+ipv6_bucket_size = 61
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv6_bucket_size must be between 1 and 60",
+		},
+		{
+			name: "csv invalid ipv6_bucket_type",
+			toml: `
+[output]
+format = "csv"
+ipv4_file = "output-v4.csv"
+ipv6_file = "output-v6.csv"
+
+[output.csv]
+ipv6_bucket_type = "invalid"
+
+[[network.columns]]
+name = "network_bucket"
+type = "network_bucket"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "ipv6_bucket_type must be 'string' or 'int'",
+		},
+		{
+			name: "filter with empty expression",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+
+[[filters]]
+expression = ""
+`,
+			expectError: "filter 1: expression is required",
+		},
+		{
+			name: "filter referencing unknown column",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+
+[[filters]]
+expression = 'city == "Paris"'
+`,
+			expectError: `unknown column "city"`,
+		},
+		{
+			name: "filter with syntax error",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+
+[[filters]]
+expression = 'country in ["US"'
+`,
+			expectError: `expected "," or "]"`,
+		},
+		{
+			name: "computed column with database",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+
+[[columns]]
+name = "is_us"
+database = "geo"
+expression = 'country == "US"'
+`,
+			expectError: "expression cannot be combined with database or path",
+		},
+		{
+			name: "computed column referencing later column",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "is_us"
+expression = 'country == "US"'
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: `computed columns may only reference columns defined before them`,
+		},
+		{
+			name: "unknown transform op",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ op = "reverse" }]
+`,
+			expectError: "invalid op 'reverse'",
+		},
+		{
+			name: "transform without op",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ digits = 2 }]
+`,
+			expectError: "transform 1: op is required",
+		},
+		{
+			name: "round without digits",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ op = "round" }]
+`,
+			expectError: "round requires digits",
+		},
+		{
+			name: "round digits out of range",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ op = "round", digits = 16 }]
+`,
+			expectError: "round digits must be between 0 and 15",
+		},
+		{
+			name: "bool_map missing false",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ op = "bool_map", true = "yes" }]
+`,
+			expectError: "bool_map requires both true and false",
+		},
+		{
+			name: "truncate without length",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+transforms = [{ op = "truncate" }]
+`,
+			expectError: "truncate length must be at least 1",
+		},
+		{
+			name: "wildcard in output_path",
+			toml: `
+[output]
+format = "mmdb"
+file = "output.mmdb"
+
+[output.mmdb]
+database_type = "Test"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "subdivisions"
+database = "geo"
+path = ["subdivisions", "*", "iso_code"]
+output_path = ["subdivisions", "*"]
+`,
+			expectError: "output_path cannot contain wildcard segment '*'",
+		},
+		{
+			name: "invalid expand",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "city_name"
+database = "geo"
+path = ["city", "names"]
+expand = "values"
+`,
+			expectError: "invalid expand 'values' for column 'city_name', must be: keys",
+		},
+		{
+			name: "expand with wildcard path",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "subdivision_name"
+database = "geo"
+path = ["subdivisions", "*", "names"]
+expand = "keys"
+`,
+			expectError: "expand cannot be combined with wildcard path segments",
+		},
+		{
+			name: "negative row_group_bytes",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[output.parquet]
+row_group_bytes = -1
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.parquet.row_group_bytes cannot be negative, got -1",
+		},
+		{
+			name: "IPv4 row group boundary too long",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[output.parquet]
+ipv4_row_group_boundary = 33
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.parquet.ipv4_row_group_boundary must be between 0 and 32, got 33",
+		},
+		{
+			name: "non-positive schema_sample_rows",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[output.parquet]
+schema_sample_rows = -1
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "city"
+database = "geo"
+path = ["city"]
+type = "struct"
+`,
+			expectError: "output.parquet.schema_sample_rows must be at least 1, got -1",
+		},
+		{
+			name: "unknown bloom filter column",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[output.parquet]
+bloom_filter_columns = ["country"]
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country_iso"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "bloom filter column 'country' is not a configured column",
+		},
+		{
+			name: "bloom filter on nested column",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[output.parquet]
+bloom_filter_columns = ["city"]
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "city"
+database = "geo"
+path = ["city"]
+type = "struct"
+`,
+			expectError: "bloom filter column 'city' has nested type 'struct'",
+		},
+		{
+			name: "bloom filters for CSV output",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[output.parquet]
+bloom_filter_columns = ["network"]
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country_iso"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.parquet.bloom_filter_columns requires Parquet output",
+		},
+		{
+			name: "decimal scale above precision",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "latitude"
+database = "geo"
+path = ["location", "latitude"]
+type = "decimal(4,6)"
+`,
+			expectError: "column 'latitude': decimal scale must be between 0 and the precision 4, got 6",
+		},
+		{
+			name: "invalid column encoding",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+encoding = "rle"
+`,
+			expectError: "invalid encoding 'rle' for column 'country'",
+		},
+		{
+			name: "encoding with csv output",
+			toml: `
+[output]
+format = "csv"
+file = "output.csv"
+
+[[network.columns]]
+name = "network"
+type = "cidr"
+encoding = "dictionary"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "column 'network': encoding is only supported for parquet output",
+		},
+		{
+			name: "encoding on nested column",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "city"
+database = "geo"
+path = ["city"]
+type = "struct"
+encoding = "dictionary"
+`,
+			expectError: "column 'city': encoding is not supported for nested type 'struct'",
+		},
+		{
+			name: "partition_by with csv output",
+			toml: `
+[output]
+format = "csv"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["country"]
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.parquet.partition_by, max_rows_per_file, and table_format require Parquet output",
+		},
+		{
+			name: "partition by unknown column",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["city"]
+
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "partition key 'city' is not a configured column",
+		},
+		{
+			name: "partition by network column",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
 
 [output.parquet]
-ipv4_bucket_size = -1
-
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+partition_by = ["start_int"]
 
 [[databases]]
 name = "geo"
@@ -996,22 +2438,39 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "ipv4_bucket_size must be between 1 and 32",
+			expectError: "partition key 'start_int' is a network column",
 		},
 		{
-			name: "ipv6_bucket_size too large",
+			name: "partition by nested column",
 			toml: `
 [output]
 format = "parquet"
-ipv4_file = "output-v4.parquet"
-ipv6_file = "output-v6.parquet"
+file = "dataset"
 
 [output.parquet]
-ipv6_bucket_size = 61
+partition_by = ["country"]
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country"]
+type = "struct"
+`,
+			expectError: "partition key 'country' has nested type 'struct'",
+		},
+		{
+			name: "duplicate partition key",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["country", "country"]
 
 [[databases]]
 name = "geo"
@@ -1022,22 +2481,39 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "ipv6_bucket_size must be between 1 and 60",
+			expectError: "duplicate partition key 'country'",
 		},
 		{
-			name: "invalid ipv6_bucket_type",
+			name: "ambiguous partition key",
 			toml: `
 [output]
 format = "parquet"
-ipv4_file = "output-v4.parquet"
-ipv6_file = "output-v6.parquet"
+file = "dataset"
 
 [output.parquet]
-ipv6_bucket_type = "invalid"
+partition_by = ["ip_version"]
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "ip_version"
+database = "geo"
+path = ["ip_version"]
+`,
+			expectError: "partition key 'ip_version' is ambiguous",
+		},
+		{
+			name: "partition key as bloom filter column",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["country"]
+bloom_filter_columns = ["country"]
 
 [[databases]]
 name = "geo"
@@ -1048,22 +2524,39 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "ipv6_bucket_type must be 'string' or 'int'",
+			expectError: "bloom filter column 'country' is a partition key",
 		},
 		{
-			name: "csv ipv4_bucket_size too large",
+			name: "negative max_rows_per_file",
 			toml: `
 [output]
-format = "csv"
-ipv4_file = "output-v4.csv"
-ipv6_file = "output-v6.csv"
+format = "parquet"
+file = "dataset"
 
-[output.csv]
-ipv4_bucket_size = 33
+[output.parquet]
+max_rows_per_file = -1
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.parquet.max_rows_per_file cannot be negative, got -1",
+		},
+		{
+			name: "negative max_open_files",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["country"]
+max_open_files = -1
 
 [[databases]]
 name = "geo"
@@ -1074,22 +2567,37 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "ipv4_bucket_size must be between 1 and 32",
+			expectError: "output.parquet.max_open_files must be at least 1, got -1",
 		},
 		{
-			name: "csv ipv6_bucket_size too large",
+			name: "invalid merge_strategy",
 			toml: `
 [output]
-format = "csv"
-ipv4_file = "output-v4.csv"
-ipv6_file = "output-v6.csv"
+format = "mmdb"
+file = "output.mmdb"
 
-[output.csv]
-ipv6_bucket_size = 61
+[output.mmdb]
+database_type = "Merged"
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "geo_all"
+database = "geo"
+path = []
+output_path = []
+merge_strategy = "union"
+`,
+			expectError: "invalid merge_strategy 'union' for column 'geo_all'",
+		},
+		{
+			name: "merge_strategy with parquet output",
+			toml: `
+[output]
+format = "parquet"
+file = "output.parquet"
 
 [[databases]]
 name = "geo"
@@ -1099,23 +2607,41 @@ path = "/path/to/geo.mmdb"
 name = "country"
 database = "geo"
 path = ["country", "iso_code"]
+merge_strategy = "overwrite"
 `,
-			expectError: "ipv6_bucket_size must be between 1 and 60",
+			expectError: "column 'country': merge_strategy is only supported for mmdb output",
 		},
 		{
-			name: "csv invalid ipv6_bucket_type",
+			name: "unsupported table_format",
 			toml: `
 [output]
-format = "csv"
-ipv4_file = "output-v4.csv"
-ipv6_file = "output-v6.csv"
+format = "parquet"
+file = "table"
 
-[output.csv]
-ipv6_bucket_type = "invalid"
+[output.parquet]
+table_format = "iceberg"
 
-[[network.columns]]
-name = "network_bucket"
-type = "network_bucket"
+[[databases]]
+name = "geo"
+path = "/path/to/geo.mmdb"
+
+[[columns]]
+name = "country"
+database = "geo"
+path = ["country", "iso_code"]
+`,
+			expectError: "output.parquet.table_format must be 'delta', got 'iceberg'",
+		},
+		{
+			name: "ipv6_partition_prefix out of range",
+			toml: `
+[output]
+format = "parquet"
+file = "dataset"
+
+[output.parquet]
+partition_by = ["network_prefix"]
+ipv6_partition_prefix = 129
 
 [[databases]]
 name = "geo"
@@ -1126,7 +2652,7 @@ name = "country"
 database = "geo"
 path = ["country", "iso_code"]
 `,
-			expectError: "ipv6_bucket_type must be 'string' or 'int'",
+			expectError: "output.parquet.ipv6_partition_prefix must be between 1 and 128, got 129",
 		},
 	}
 
@@ -1153,6 +2679,196 @@ path = ["country", "iso_code"]
 	}
 }
 
+func TestColumn_IsList(t *testing.T) {
+	tests := []struct {
+		name     string
+		column   Column
+		expected bool
+	}{
+		{
+			name:     "plain path",
+			column:   Column{Path: Path{"subdivisions", 0, "iso_code"}},
+			expected: false,
+		},
+		{
+			name:     "wildcard path",
+			column:   Column{Path: Path{"subdivisions", "*", "iso_code"}},
+			expected: true,
+		},
+		{
+			name: "wildcard path joined by transform",
+			column: Column{
+				Path:       Path{"subdivisions", "*", "iso_code"},
+				Transforms: []Transform{{Op: "upper"}, {Op: "join"}},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.column.IsList())
+		})
+	}
+}
+
+func TestParseDecimalType(t *testing.T) {
+	tests := []struct {
+		typeHint  string
+		precision int
+		scale     int
+		ok        bool
+		wantErr   bool
+	}{
+		{typeHint: "decimal(9,6)", precision: 9, scale: 6, ok: true},
+		{typeHint: "decimal(38, 0)", precision: 38, scale: 0, ok: true},
+		{typeHint: "int64"},
+		{typeHint: "decimal", ok: false},
+		{typeHint: "decimal(9)", ok: true, wantErr: true},
+		{typeHint: "decimal(9,x)", ok: true, wantErr: true},
+		{typeHint: "decimal(0,0)", ok: true, wantErr: true},
+		{typeHint: "decimal(39,2)", ok: true, wantErr: true},
+		{typeHint: "decimal(5,-1)", ok: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typeHint, func(t *testing.T) {
+			precision, scale, ok, err := ParseDecimalType(tt.typeHint)
+			require.Equal(t, tt.ok, ok)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.precision, precision)
+			require.Equal(t, tt.scale, scale)
+		})
+	}
+}
+
+func TestExpandColumns(t *testing.T) {
+	cfg := &Config{
+		Output: OutputConfig{Format: "mmdb", File: "out.mmdb"},
+		Databases: []Database{
+			{Name: "city", Path: "city.mmdb"},
+			{Name: "anon", Path: "anon.mmdb"},
+		},
+		Columns: []Column{
+			{Name: "country", Database: "city", Path: Path{"country", "iso_code"}},
+			{
+				Name:       "city_name",
+				Database:   "city",
+				Path:       Path{"city", "names"},
+				OutputPath: &Path{"city", "names"},
+				Transforms: []Transform{{Op: "trim"}},
+				Expand:     ExpandKeys,
+			},
+			{Name: "english", Expression: `coalesce(city_name_en, "")`},
+		},
+		Filters: []Filter{{Expression: `city_name_zh_CN is not null`}},
+	}
+	cfg.Output.MMDB.DatabaseType = "Test"
+
+	err := ExpandColumns(cfg, map[string][]string{
+		"city": {"en", "zh-CN"},
+		"anon": {"fr"},
+	})
+	require.NoError(t, err)
+
+	expected := []Column{
+		{Name: "country", Database: "city", Path: Path{"country", "iso_code"}},
+		{
+			Name:       "city_name_en",
+			Database:   "city",
+			Path:       Path{"city", "names", "en"},
+			OutputPath: &Path{"city", "names", "en"},
+			Transforms: []Transform{{Op: "trim"}},
+		},
+		{
+			Name:       "city_name_zh_CN",
+			Database:   "city",
+			Path:       Path{"city", "names", "zh-CN"},
+			OutputPath: &Path{"city", "names", "zh-CN"},
+			Transforms: []Transform{{Op: "trim"}},
+		},
+		{Name: "english", Expression: `coalesce(city_name_en, "")`},
+	}
+	require.Equal(t, expected, cfg.Columns)
+}
+
+func TestExpandColumns_Locales(t *testing.T) {
+	cfg := &Config{
+		Output:    OutputConfig{Format: "csv", File: "out.csv"},
+		Databases: []Database{{Name: "city", Path: "city.mmdb"}},
+		Columns: []Column{
+			{Name: "city_name", Database: "city", Path: Path{"city", "names"}, Expand: ExpandKeys},
+		},
+		Locales: []string{"fr", "en"},
+	}
+
+	err := ExpandColumns(cfg, map[string][]string{"city": {"de", "en", "fr"}})
+	require.NoError(t, err)
+	require.Equal(t, []Column{
+		{Name: "city_name_en", Database: "city", Path: Path{"city", "names", "en"}},
+		{Name: "city_name_fr", Database: "city", Path: Path{"city", "names", "fr"}},
+	}, cfg.Columns)
+
+	cfg.Columns = []Column{
+		{Name: "city_name", Database: "city", Path: Path{"city", "names"}, Expand: ExpandKeys},
+	}
+	err = ExpandColumns(cfg, map[string][]string{"city": {"de"}})
+	require.ErrorContains(t, err, "database 'city' lists none of the configured locales")
+}
+
+func TestExpandColumns_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		columns     []Column
+		languages   map[string][]string
+		expectError string
+	}{
+		{
+			name: "no languages in metadata",
+			columns: []Column{
+				{Name: "city_name", Database: "city", Path: Path{"city", "names"}, Expand: "keys"},
+			},
+			languages:   map[string][]string{},
+			expectError: "database 'city' lists no languages in its metadata",
+		},
+		{
+			name: "expanded name collides",
+			columns: []Column{
+				{Name: "city_name", Database: "city", Path: Path{"city", "names"}, Expand: "keys"},
+				{Name: "city_name_en", Database: "city", Path: Path{"city", "geoname_id"}},
+			},
+			languages:   map[string][]string{"city": {"en"}},
+			expectError: "duplicate column name 'city_name_en'",
+		},
+		{
+			name: "expression references unknown expanded column",
+			columns: []Column{
+				{Name: "city_name", Database: "city", Path: Path{"city", "names"}, Expand: "keys"},
+				{Name: "french", Expression: "city_name_fr"},
+			},
+			languages:   map[string][]string{"city": {"en"}},
+			expectError: `unknown column "city_name_fr"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Output:    OutputConfig{Format: "csv", File: "out.csv"},
+				Databases: []Database{{Name: "city", Path: "city.mmdb"}},
+				Columns:   tt.columns,
+			}
+			err := ExpandColumns(cfg, tt.languages)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
+
 func TestApplyDefaults(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1198,6 +2914,23 @@ func TestApplyDefaults(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Parquet row group size unlimited with row_group_bytes",
+			input: Config{
+				Output: OutputConfig{
+					Format:  "parquet",
+					Parquet: ParquetConfig{RowGroupBytes: 128 * 1024 * 1024},
+				},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.Output.Parquet.RowGroupSize != 0 {
+					t.Errorf(
+						"expected row_group_size=0 with row_group_bytes set, got %d",
+						cfg.Output.Parquet.RowGroupSize,
+					)
+				}
+			},
+		},
 		{
 			name: "CSV network columns default",
 			input: Config{
@@ -1248,6 +2981,84 @@ func TestApplyDefaults(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "MMDB languages default to description keys",
+			input: Config{
+				Output: OutputConfig{
+					Format: "mmdb",
+					MMDB: MMDBConfig{
+						Description: map[string]string{"en": "Test", "de": "Test"},
+					},
+				},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, []string{"de", "en"}, cfg.Output.MMDB.Languages)
+			},
+		},
+		{
+			name: "ipv4_only_subtrees default",
+			input: Config{
+				Output: OutputConfig{Format: "csv"},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, IPv4OnlySubtreesBoth, cfg.IPv4OnlySubtrees)
+			},
+		},
+		{
+			name: "MMDB languages default to locales",
+			input: Config{
+				Locales: []string{"ja", "en"},
+				Output: OutputConfig{
+					Format: "mmdb",
+					MMDB: MMDBConfig{
+						Description: map[string]string{"en": "Test", "de": "Test"},
+					},
+				},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, []string{"en", "ja"}, cfg.Output.MMDB.Languages)
+			},
+		},
+		{
+			name: "MMDB languages restricted to locales",
+			input: Config{
+				Locales: []string{"en"},
+				Output: OutputConfig{
+					Format: "mmdb",
+					MMDB:   MMDBConfig{Languages: []string{"de", "en", "fr"}},
+				},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				require.Equal(t, []string{"en"}, cfg.Output.MMDB.Languages)
+			},
+		},
+		{
+			name: "MMDB languages left to metadata_from",
+			input: Config{
+				Output: OutputConfig{
+					Format: "mmdb",
+					MMDB: MMDBConfig{
+						Description:  map[string]string{"en": "Test"},
+						MetadataFrom: "geo",
+					},
+				},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				require.Empty(t, cfg.Output.MMDB.Languages)
+			},
+		},
+		{
+			name: "MMDB verify_sample_rate default",
+			input: Config{
+				Output: OutputConfig{
+					Format: "mmdb",
+					MMDB:   MMDBConfig{Verify: VerifySample},
+				},
+			},
+			validate: func(t *testing.T, cfg *Config) {
+				require.InEpsilon(t, 0.01, cfg.Output.MMDB.VerifySampleRate, 1e-9)
+			},
+		},
 		{
 			name: "Parquet bucket size defaults",
 			input: Config{
@@ -0,0 +1,549 @@
+package expr
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Eval evaluates the program against row, whose values are ordered like the
+// columns passed to Compile. A nil value represents missing data, and a nil
+// result means the expression evaluated to null.
+func (p *Program) Eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	return p.root.eval(row)
+}
+
+// EvalBool evaluates the program in a boolean context. A null result is
+// treated as false; any other non-boolean result is an error.
+func (p *Program) EvalBool(row []mmdbtype.DataType) (bool, error) {
+	value, err := p.root.eval(row)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value)
+}
+
+// node is an element of a parsed expression tree.
+type node interface {
+	eval(row []mmdbtype.DataType) (mmdbtype.DataType, error)
+}
+
+type literalNode struct {
+	value mmdbtype.DataType
+}
+
+func (n *literalNode) eval([]mmdbtype.DataType) (mmdbtype.DataType, error) {
+	return n.value, nil
+}
+
+type columnNode struct {
+	name  string
+	index int
+}
+
+func (n *columnNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	if n.index >= len(row) {
+		return nil, fmt.Errorf(
+			"column %q index %d out of range for row of length %d",
+			n.name,
+			n.index,
+			len(row),
+		)
+	}
+	return row[n.index], nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	list := make(mmdbtype.Slice, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = value
+	}
+	return list, nil
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	left, err := evalBool(n.left, row, "or")
+	if err != nil || left {
+		return mmdbtype.Bool(left), err
+	}
+	right, err := evalBool(n.right, row, "or")
+	return mmdbtype.Bool(right), err
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	left, err := evalBool(n.left, row, "and")
+	if err != nil || !left {
+		return mmdbtype.Bool(false), err
+	}
+	right, err := evalBool(n.right, row, "and")
+	return mmdbtype.Bool(right), err
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	value, err := evalBool(n.operand, row, "not")
+	return mmdbtype.Bool(!value), err
+}
+
+type isNullNode struct {
+	operand node
+	negate  bool
+}
+
+func (n *isNullNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	value, err := n.operand.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return mmdbtype.Bool((value == nil) != n.negate), nil
+}
+
+type inNode struct {
+	value node
+	list  node
+}
+
+func (n *inNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	value, err := n.value.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	listValue, err := n.list.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if value == nil || listValue == nil {
+		return mmdbtype.Bool(false), nil
+	}
+	list, ok := listValue.(mmdbtype.Slice)
+	if !ok {
+		return nil, fmt.Errorf(`right operand of "in" must be a list, got %s`, typeName(listValue))
+	}
+	for _, item := range list {
+		if valuesEqual(value, item) {
+			return mmdbtype.Bool(true), nil
+		}
+	}
+	return mmdbtype.Bool(false), nil
+}
+
+type compareNode struct {
+	op          tokenKind
+	left, right node
+}
+
+func (n *compareNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	left, err := n.left.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case tokEq:
+		return mmdbtype.Bool(valuesEqual(left, right)), nil
+	case tokNe:
+		return mmdbtype.Bool(!valuesEqual(left, right)), nil
+	}
+
+	// Ordering comparisons against a missing value never match.
+	if left == nil || right == nil {
+		return mmdbtype.Bool(false), nil
+	}
+	c, err := compareValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case tokLt:
+		return mmdbtype.Bool(c < 0), nil
+	case tokLe:
+		return mmdbtype.Bool(c <= 0), nil
+	case tokGt:
+		return mmdbtype.Bool(c > 0), nil
+	case tokGe:
+		return mmdbtype.Bool(c >= 0), nil
+	default:
+		return nil, fmt.Errorf("unknown comparison operator %d", n.op)
+	}
+}
+
+type negateNode struct {
+	operand node
+}
+
+func (n *negateNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	value, err := n.operand.eval(row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	num, ok := toNumber(value)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %s", typeName(value))
+	}
+	if num.isFloat {
+		return mmdbtype.Float64(-num.f), nil
+	}
+	return integerValue(new(big.Int).Neg(num.i))
+}
+
+type arithmeticNode struct {
+	op          tokenKind
+	left, right node
+}
+
+func (n *arithmeticNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	left, err := n.left.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	// Arithmetic on a missing value yields a missing value.
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	if n.op == tokPlus {
+		if ls, ok := left.(mmdbtype.String); ok {
+			if rs, ok := right.(mmdbtype.String); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+
+	a, aOK := toNumber(left)
+	b, bOK := toNumber(right)
+	if !aOK || !bOK {
+		return nil, fmt.Errorf(
+			"cannot apply %q to %s and %s",
+			operatorText(n.op),
+			typeName(left),
+			typeName(right),
+		)
+	}
+	return arithmetic(n.op, a, b)
+}
+
+// arithmetic applies op to two numbers. Integer operands stay exact except
+// for "/", which always produces a float.
+func arithmetic(op tokenKind, a, b number) (mmdbtype.DataType, error) {
+	if op == tokSlash || a.isFloat || b.isFloat {
+		if op == tokPercent {
+			return nil, errors.New(`"%" requires integer operands`)
+		}
+		x, y := a.float64(), b.float64()
+		switch op {
+		case tokPlus:
+			return mmdbtype.Float64(x + y), nil
+		case tokMinus:
+			return mmdbtype.Float64(x - y), nil
+		case tokStar:
+			return mmdbtype.Float64(x * y), nil
+		case tokSlash:
+			if y == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return mmdbtype.Float64(x / y), nil
+		}
+		return nil, fmt.Errorf("unknown arithmetic operator %d", op)
+	}
+
+	result := new(big.Int)
+	switch op {
+	case tokPlus:
+		result.Add(a.i, b.i)
+	case tokMinus:
+		result.Sub(a.i, b.i)
+	case tokStar:
+		result.Mul(a.i, b.i)
+	case tokPercent:
+		if b.i.Sign() == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		result.Rem(a.i, b.i)
+	default:
+		return nil, fmt.Errorf("unknown arithmetic operator %d", op)
+	}
+	return integerValue(result)
+}
+
+func operatorText(op tokenKind) string {
+	switch op {
+	case tokPlus:
+		return "+"
+	case tokMinus:
+		return "-"
+	case tokStar:
+		return "*"
+	case tokSlash:
+		return "/"
+	case tokPercent:
+		return "%"
+	default:
+		return fmt.Sprintf("operator %d", op)
+	}
+}
+
+type mapNode struct {
+	keys   []mmdbtype.String
+	values []node
+}
+
+func (n *mapNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	m := make(mmdbtype.Map, len(n.keys))
+	for i, key := range n.keys {
+		value, err := n.values[i].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			m[key] = value
+		}
+	}
+	return m, nil
+}
+
+type indexNode struct {
+	target, key node
+}
+
+// eval looks up key in a map or list. Missing keys, out-of-range indexes, and
+// null operands all yield null.
+func (n *indexNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	target, err := n.target.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil || key == nil {
+		return nil, nil
+	}
+
+	switch t := target.(type) {
+	case mmdbtype.Map:
+		k, ok := key.(mmdbtype.String)
+		if !ok {
+			return nil, fmt.Errorf("map index must be a string, got %s", typeName(key))
+		}
+		return t[k], nil
+
+	case mmdbtype.Slice:
+		k, ok := toNumber(key)
+		if !ok || k.isFloat || !k.i.IsInt64() {
+			return nil, fmt.Errorf("list index must be an integer, got %s", typeName(key))
+		}
+		idx := k.i.Int64()
+		if idx < 0 {
+			idx += int64(len(t))
+		}
+		if idx < 0 || idx >= int64(len(t)) {
+			return nil, nil
+		}
+		return t[idx], nil
+
+	default:
+		return nil, fmt.Errorf("cannot index into %s", typeName(target))
+	}
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	value, err := n.fn.call(n.args, row)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return value, nil
+}
+
+// evalBool evaluates n in a boolean context for the named operator.
+func evalBool(n node, row []mmdbtype.DataType, op string) (bool, error) {
+	value, err := n.eval(row)
+	if err != nil {
+		return false, err
+	}
+	b, err := truthy(value)
+	if err != nil {
+		return false, fmt.Errorf("operand of %q: %w", op, err)
+	}
+	return b, nil
+}
+
+// truthy converts a value to a boolean. Null is false.
+func truthy(value mmdbtype.DataType) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case mmdbtype.Bool:
+		return bool(v), nil
+	default:
+		return false, fmt.Errorf("expected bool, got %s", typeName(value))
+	}
+}
+
+// valuesEqual reports whether a and b are equal. Numbers compare by value
+// regardless of their MMDB type, and null only equals null.
+func valuesEqual(a, b mmdbtype.DataType) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if an, ok := toNumber(a); ok {
+		if bn, ok := toNumber(b); ok {
+			return compareNumbers(an, bn) == 0
+		}
+		return false
+	}
+	return a.Equal(b)
+}
+
+// compareValues orders two non-null values. Only numbers and strings can be
+// ordered.
+func compareValues(a, b mmdbtype.DataType) (int, error) {
+	if an, ok := toNumber(a); ok {
+		if bn, ok := toNumber(b); ok {
+			return compareNumbers(an, bn), nil
+		}
+	}
+	if as, ok := a.(mmdbtype.String); ok {
+		if bs, ok := b.(mmdbtype.String); ok {
+			return strings.Compare(string(as), string(bs)), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeName(a), typeName(b))
+}
+
+// number is a numeric operand, held exactly as an integer when possible.
+type number struct {
+	i       *big.Int
+	f       float64
+	isFloat bool
+}
+
+func toNumber(value mmdbtype.DataType) (number, bool) {
+	switch v := value.(type) {
+	case mmdbtype.Int32:
+		return number{i: big.NewInt(int64(v))}, true
+	case mmdbtype.Uint16:
+		return number{i: new(big.Int).SetUint64(uint64(v))}, true
+	case mmdbtype.Uint32:
+		return number{i: new(big.Int).SetUint64(uint64(v))}, true
+	case mmdbtype.Uint64:
+		return number{i: new(big.Int).SetUint64(uint64(v))}, true
+	case *mmdbtype.Uint128:
+		return number{i: (*big.Int)(v)}, true
+	case mmdbtype.Float32:
+		return number{f: float64(v), isFloat: true}, true
+	case mmdbtype.Float64:
+		return number{f: float64(v), isFloat: true}, true
+	default:
+		return number{}, false
+	}
+}
+
+func (n number) float64() float64 {
+	if n.isFloat {
+		return n.f
+	}
+	f, _ := new(big.Float).SetInt(n.i).Float64()
+	return f
+}
+
+func compareNumbers(a, b number) int {
+	if !a.isFloat && !b.isFloat {
+		return a.i.Cmp(b.i)
+	}
+	return cmp.Compare(a.float64(), b.float64())
+}
+
+// integerValue returns i as the smallest MMDB integer type that can hold it:
+// Int32, then Uint64, then Uint128.
+func integerValue(i *big.Int) (mmdbtype.DataType, error) {
+	if i.IsInt64() {
+		if v := i.Int64(); v >= math.MinInt32 && v <= math.MaxInt32 {
+			return mmdbtype.Int32(v), nil
+		}
+	}
+	if i.Sign() >= 0 {
+		if i.IsUint64() {
+			return mmdbtype.Uint64(i.Uint64()), nil
+		}
+		if i.BitLen() <= 128 {
+			return (*mmdbtype.Uint128)(new(big.Int).Set(i)), nil
+		}
+	}
+	return nil, fmt.Errorf("integer %s cannot be represented as an MMDB value", i)
+}
+
+// typeName returns a short, user-facing name for a value's type.
+func typeName(value mmdbtype.DataType) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case mmdbtype.Bool:
+		return "bool"
+	case mmdbtype.String:
+		return "string"
+	case mmdbtype.Bytes:
+		return "bytes"
+	case mmdbtype.Int32:
+		return "int32"
+	case mmdbtype.Uint16:
+		return "uint16"
+	case mmdbtype.Uint32:
+		return "uint32"
+	case mmdbtype.Uint64:
+		return "uint64"
+	case *mmdbtype.Uint128:
+		return "uint128"
+	case mmdbtype.Float32:
+		return "float32"
+	case mmdbtype.Float64:
+		return "float64"
+	case mmdbtype.Map:
+		return "map"
+	case mmdbtype.Slice:
+		return "list"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
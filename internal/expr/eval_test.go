@@ -0,0 +1,232 @@
+package expr
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgram_EvalBool(t *testing.T) {
+	columns := []string{"country_iso", "is_vpn", "radius", "asn", "lat", "subdivisions"}
+	row := []mmdbtype.DataType{
+		mmdbtype.String("RU"),
+		mmdbtype.Bool(true),
+		mmdbtype.Uint16(500),
+		(*mmdbtype.Uint128)(big.NewInt(64512)),
+		mmdbtype.Float64(55.75),
+		nil,
+	}
+
+	tests := []struct {
+		source   string
+		expected bool
+	}{
+		{`is_vpn == true`, true},
+		{`is_vpn`, true},
+		{`not is_vpn`, false},
+		{`country_iso in ["RU", "CN"]`, true},
+		{`country_iso not in ["RU", "CN"]`, false},
+		{`country_iso in []`, false},
+		{`radius in [100, 500]`, true},
+		{`radius == 500.0`, true},
+		{`radius != 500`, false},
+		{`asn == 64512`, true},
+		{`asn > radius`, true},
+		{`lat >= 55 and lat < 56`, true},
+		{`lat > -90`, true},
+		{`country_iso < "US"`, true},
+		{`country_iso == 1`, false},
+		{`subdivisions is null`, true},
+		{`subdivisions is not null`, false},
+		{`subdivisions == null`, true},
+		{`subdivisions != "x"`, true},
+		{`subdivisions in ["x"]`, false},
+		{`subdivisions > 1`, false},
+		{`subdivisions`, false},
+		{`subdivisions or is_vpn`, true},
+		{`subdivisions and is_vpn`, false},
+		{`country_iso == "US" or radius > 100 and is_vpn`, true},
+		{`(country_iso == "US" or radius > 100) and not is_vpn`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := Compile(tt.source, columns)
+			require.NoError(t, err)
+
+			result, err := program.EvalBool(row)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestProgram_EvalErrors(t *testing.T) {
+	columns := []string{"country_iso", "radius", "names"}
+	row := []mmdbtype.DataType{
+		mmdbtype.String("US"),
+		mmdbtype.Uint16(5),
+		mmdbtype.Map{"en": mmdbtype.String("United States")},
+	}
+
+	tests := []struct {
+		source      string
+		expectError string
+	}{
+		{`country_iso`, "expected bool, got string"},
+		{`radius and true`, `operand of "and": expected bool, got uint16`},
+		{`not country_iso`, `operand of "not": expected bool, got string`},
+		{`country_iso < 5`, "cannot compare string with int32"},
+		{`names > "a"`, "cannot compare map with string"},
+		{`country_iso in "US"`, `right operand of "in" must be a list, got string`},
+		{`-country_iso == 1`, "cannot negate string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := Compile(tt.source, columns)
+			require.NoError(t, err)
+
+			_, err = program.EvalBool(row)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
+
+func TestProgram_EvalShortCircuits(t *testing.T) {
+	program, err := Compile(`is_vpn or country_iso < 5`, []string{"is_vpn", "country_iso"})
+	require.NoError(t, err)
+
+	// The right-hand side would fail with a type error if it were evaluated.
+	result, err := program.EvalBool(
+		[]mmdbtype.DataType{mmdbtype.Bool(true), mmdbtype.String("US")},
+	)
+	require.NoError(t, err)
+	assert.True(t, result)
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected mmdbtype.DataType
+	}{
+		{"0", mmdbtype.Int32(0)},
+		{"2147483647", mmdbtype.Int32(2147483647)},
+		{"2147483648", mmdbtype.Uint64(2147483648)},
+		{"18446744073709551616", (*mmdbtype.Uint128)(new(big.Int).Lsh(big.NewInt(1), 64))},
+		{"1.5", mmdbtype.Float64(1.5)},
+		{"2e3", mmdbtype.Float64(2000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			value, err := parseNumber(tt.text)
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(value), "expected %v, got %v", tt.expected, value)
+		})
+	}
+}
+
+func TestProgram_Eval(t *testing.T) {
+	columns := []string{"country_iso", "lat", "lon", "radius", "is_vpn", "is_proxy", "missing"}
+	row := []mmdbtype.DataType{
+		mmdbtype.String("DE"),
+		mmdbtype.Float64(52.5),
+		mmdbtype.Float64(13.25),
+		mmdbtype.Uint16(20),
+		mmdbtype.Bool(false),
+		mmdbtype.Bool(true),
+		nil,
+	}
+
+	tests := []struct {
+		source   string
+		expected mmdbtype.DataType
+	}{
+		{`country_iso in ["AT", "BE", "DE", "FR"]`, mmdbtype.Bool(true)},
+		{`concat(lat, ",", lon)`, mmdbtype.String("52.5,13.25")},
+		{`concat(country_iso, "-", missing, is_vpn)`, mmdbtype.String("DE-false")},
+		{`country_iso + "-" + country_iso`, mmdbtype.String("DE-DE")},
+		{`is_vpn or is_proxy`, mmdbtype.Bool(true)},
+		{`radius * 1000`, mmdbtype.Int32(20000)},
+		{`radius + 2147483647`, mmdbtype.Uint64(2147483667)},
+		{`radius - 30`, mmdbtype.Int32(-10)},
+		{`radius / 8`, mmdbtype.Float64(2.5)},
+		{`radius % 6`, mmdbtype.Int32(2)},
+		{`lat + radius`, mmdbtype.Float64(72.5)},
+		{`-(lat)`, mmdbtype.Float64(-52.5)},
+		{`2 + 3 * 4`, mmdbtype.Int32(14)},
+		{`(2 + 3) * 4`, mmdbtype.Int32(20)},
+		{`missing + 1`, nil},
+		{`if(radius > 10, "coarse", "fine")`, mmdbtype.String("coarse")},
+		{`if(missing, "yes", "no")`, mmdbtype.String("no")},
+		{`coalesce(missing, country_iso)`, mmdbtype.String("DE")},
+		{`coalesce(missing, null)`, nil},
+		{`{"DE": "Germany", "FR": "France"}[country_iso]`, mmdbtype.String("Germany")},
+		{`{"FR": "France"}[country_iso]`, nil},
+		{`coalesce({"FR": true}[country_iso], false)`, mmdbtype.Bool(false)},
+		{`{"a": 1}[missing]`, nil},
+		{`["x", "y", "z"][1]`, mmdbtype.String("y")},
+		{`["x", "y", "z"][-1]`, mmdbtype.String("z")},
+		{`["x", "y", "z"][3]`, nil},
+		{`{"nested": {"k": 1}}["nested"]["k"]`, mmdbtype.Int32(1)},
+		{`{"a": 1, "b": missing}`, mmdbtype.Map{"a": mmdbtype.Int32(1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := Compile(tt.source, columns)
+			require.NoError(t, err)
+
+			result, err := program.Eval(row)
+			require.NoError(t, err)
+			if tt.expected == nil {
+				assert.Nil(t, result)
+				return
+			}
+			require.NotNil(t, result)
+			assert.True(t, tt.expected.Equal(result), "expected %#v, got %#v", tt.expected, result)
+		})
+	}
+}
+
+func TestProgram_EvalArithmeticErrors(t *testing.T) {
+	columns := []string{"country_iso", "radius", "names"}
+	row := []mmdbtype.DataType{
+		mmdbtype.String("US"),
+		mmdbtype.Uint16(5),
+		mmdbtype.Map{"en": mmdbtype.String("United States")},
+	}
+
+	tests := []struct {
+		source      string
+		expectError string
+	}{
+		{`country_iso + 1`, `cannot apply "+" to string and int32`},
+		{`country_iso * 2`, `cannot apply "*" to string and int32`},
+		{`radius / 0`, "division by zero"},
+		{`radius % 0`, "modulo by zero"},
+		{`radius % 1.5`, `"%" requires integer operands`},
+		{`0 - radius - 2147483647 - 2`, "cannot be represented"},
+		{`concat(names)`, "concat(): argument 1: cannot convert map to string"},
+		{`if(country_iso, 1, 2)`, `if(): operand of "if": expected bool, got string`},
+		{`names[1]`, "map index must be a string, got int32"},
+		{`["a"]["b"]`, "list index must be an integer, got string"},
+		{`country_iso["a"]`, "cannot index into string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			program, err := Compile(tt.source, columns)
+			require.NoError(t, err)
+
+			_, err = program.Eval(row)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
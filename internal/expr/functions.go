@@ -0,0 +1,115 @@
+package expr
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// function is a built-in function. Arguments are passed unevaluated so that
+// functions such as if() can evaluate only the branch they need.
+type function struct {
+	minArgs int
+	maxArgs int // -1 means no upper bound
+	call    func(args []node, row []mmdbtype.DataType) (mmdbtype.DataType, error)
+}
+
+func (f function) arity() string {
+	switch {
+	case f.maxArgs < 0 && f.minArgs == 1:
+		return "at least 1 argument"
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("%d arguments", f.minArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", f.minArgs, f.maxArgs)
+	}
+}
+
+// functions holds the built-in functions by name:
+//
+//   - if(cond, then, else) returns then when cond is true and else otherwise
+//     (including when cond is null).
+//   - coalesce(a, b, ...) returns the first argument that is not null.
+//   - concat(a, b, ...) joins the string forms of its arguments, skipping
+//     nulls. Numbers use their shortest decimal form and booleans are written
+//     as true/false.
+var functions = map[string]function{
+	"if":       {minArgs: 3, maxArgs: 3, call: callIf},
+	"coalesce": {minArgs: 1, maxArgs: -1, call: callCoalesce},
+	"concat":   {minArgs: 1, maxArgs: -1, call: callConcat},
+}
+
+func callIf(args []node, row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	cond, err := evalBool(args[0], row, "if")
+	if err != nil {
+		return nil, err
+	}
+	if cond {
+		return args[1].eval(row)
+	}
+	return args[2].eval(row)
+}
+
+func callCoalesce(args []node, row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	for _, arg := range args {
+		value, err := arg.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			return value, nil
+		}
+	}
+	return nil, nil
+}
+
+func callConcat(args []node, row []mmdbtype.DataType) (mmdbtype.DataType, error) {
+	var b strings.Builder
+	for i, arg := range args {
+		value, err := arg.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		s, err := FormatScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		b.WriteString(s)
+	}
+	return mmdbtype.String(b.String()), nil
+}
+
+// FormatScalar formats a scalar value as a string, as concat() does. Null
+// becomes the empty string, numbers use their shortest decimal form, and
+// booleans are written as true/false. Maps, lists, and bytes are rejected.
+func FormatScalar(value mmdbtype.DataType) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case mmdbtype.String:
+		return string(v), nil
+	case mmdbtype.Bool:
+		return strconv.FormatBool(bool(v)), nil
+	case mmdbtype.Int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case mmdbtype.Uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case mmdbtype.Uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case mmdbtype.Uint64:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case *mmdbtype.Uint128:
+		return (*big.Int)(v).String(), nil
+	case mmdbtype.Float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case mmdbtype.Float64:
+		return strconv.FormatFloat(float64(v), 'g', -1, 64), nil
+	default:
+		return "", fmt.Errorf("cannot convert %s to string", typeName(value))
+	}
+}
@@ -0,0 +1,252 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokEq
+	tokNe
+	tokLt
+	tokLe
+	tokGt
+	tokGe
+	tokMinus
+	tokPlus
+	tokStar
+	tokSlash
+	tokPercent
+	tokLBrace
+	tokRBrace
+	tokColon
+	tokAnd
+	tokOr
+	tokNot
+	tokIn
+	tokIs
+	tokTrue
+	tokFalse
+	tokNull
+)
+
+var keywords = map[string]tokenKind{
+	"and":   tokAnd,
+	"or":    tokOr,
+	"not":   tokNot,
+	"in":    tokIn,
+	"is":    tokIs,
+	"true":  tokTrue,
+	"false": tokFalse,
+	"null":  tokNull,
+}
+
+// token is a single lexical element of an expression.
+type token struct {
+	kind tokenKind
+	text string // Identifier name, decoded string literal, or number literal
+	pos  int    // 1-based byte offset in the source, for error messages
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits source into tokens. The returned slice always ends with tokEOF.
+func lex(source string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(source) {
+		c := source[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+
+		case isIdentStart(c):
+			for i < len(source) && isIdentPart(source[i]) {
+				i++
+			}
+			word := source[start:i]
+			kind := tokIdent
+			if kw, ok := keywords[word]; ok {
+				kind = kw
+			}
+			tokens = append(tokens, token{kind: kind, text: word, pos: start + 1})
+			continue
+
+		case isDigit(c):
+			i = scanNumber(source, i)
+			tokens = append(tokens, token{kind: tokNumber, text: source[start:i], pos: start + 1})
+			continue
+
+		case c == '"' || c == '\'':
+			text, next, err := scanString(source, i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			tokens = append(tokens, token{kind: tokString, text: text, pos: start + 1})
+			continue
+		}
+
+		kind, width := scanOperator(source[i:])
+		if width == 0 {
+			return nil, fmt.Errorf("at position %d: unexpected character %q", start+1, c)
+		}
+		i += width
+		tokens = append(tokens, token{kind: kind, text: source[start:i], pos: start + 1})
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(source) + 1}), nil
+}
+
+// scanOperator returns the operator token at the start of s and its width.
+// A width of zero means s does not start with a known operator.
+func scanOperator(s string) (tokenKind, int) {
+	if len(s) >= 2 {
+		switch s[:2] {
+		case "==":
+			return tokEq, 2
+		case "!=":
+			return tokNe, 2
+		case "<=":
+			return tokLe, 2
+		case ">=":
+			return tokGe, 2
+		}
+	}
+	switch s[0] {
+	case '(':
+		return tokLParen, 1
+	case ')':
+		return tokRParen, 1
+	case '[':
+		return tokLBracket, 1
+	case ']':
+		return tokRBracket, 1
+	case ',':
+		return tokComma, 1
+	case '<':
+		return tokLt, 1
+	case '>':
+		return tokGt, 1
+	case '-':
+		return tokMinus, 1
+	case '+':
+		return tokPlus, 1
+	case '*':
+		return tokStar, 1
+	case '/':
+		return tokSlash, 1
+	case '%':
+		return tokPercent, 1
+	case '{':
+		return tokLBrace, 1
+	case '}':
+		return tokRBrace, 1
+	case ':':
+		return tokColon, 1
+	}
+	return tokEOF, 0
+}
+
+// scanNumber returns the index just past the number literal starting at i.
+// Numbers are decimal with an optional fraction and exponent.
+func scanNumber(source string, i int) int {
+	for i < len(source) && isDigit(source[i]) {
+		i++
+	}
+	if i+1 < len(source) && source[i] == '.' && isDigit(source[i+1]) {
+		i++
+		for i < len(source) && isDigit(source[i]) {
+			i++
+		}
+	}
+	if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+		j := i + 1
+		if j < len(source) && (source[j] == '+' || source[j] == '-') {
+			j++
+		}
+		if j < len(source) && isDigit(source[j]) {
+			i = j
+			for i < len(source) && isDigit(source[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// scanString decodes the quoted string starting at i and returns its value
+// and the index just past the closing quote.
+func scanString(source string, i int) (string, int, error) {
+	quote := source[i]
+	start := i
+	i++
+
+	var b strings.Builder
+	for i < len(source) {
+		c := source[i]
+		switch c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(source) {
+				return "", 0, fmt.Errorf("at position %d: unterminated string", start+1)
+			}
+			switch esc := source[i+1]; esc {
+			case '\\', '"', '\'':
+				b.WriteByte(esc)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, fmt.Errorf(
+					"at position %d: unknown escape sequence \\%c",
+					i+1,
+					esc,
+				)
+			}
+			i += 2
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return "", 0, fmt.Errorf("at position %d: unterminated string", start+1)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,437 @@
+// Package expr implements the small expression language used to filter rows
+// and to compute derived columns from column values.
+//
+// Expressions reference data columns by name and support literals (strings,
+// numbers, true, false, null, [...] lists and {"key": value} maps),
+// comparisons (==, !=, <, <=, >, >=), list membership (in, not in), null
+// checks (is null, is not null), boolean logic (and, or, not), arithmetic
+// (+, -, *, /, %), indexing into maps and lists (x[key]), and the built-in
+// functions listed in functions.go.
+package expr
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Program is a compiled expression whose identifiers have been bound to
+// column indexes.
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses source and binds each identifier to the index of the
+// matching name in columns. Referencing a name not in columns is an error.
+func Compile(source string, columns []string) (*Program, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]int, len(columns))
+	for i, name := range columns {
+		if _, exists := indexes[name]; !exists {
+			indexes[name] = i
+		}
+	}
+
+	p := &parser{tokens: tokens, columns: indexes}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("at position %d: unexpected %s", tok.pos, tok)
+	}
+
+	return &Program{source: source, root: root}, nil
+}
+
+// String returns the source text of the program.
+func (p *Program) String() string {
+	return p.source
+}
+
+// parser is a recursive descent parser over a token slice.
+//
+// Grammar, from lowest to highest precedence:
+//
+//	or             = and { "or" and }
+//	and            = not { "and" not }
+//	not            = "not" not | comparison
+//	comparison     = additive [ compare_op additive
+//	                 | [ "not" ] "in" additive | "is" [ "not" ] "null" ]
+//	additive       = multiplicative { ( "+" | "-" ) multiplicative }
+//	multiplicative = unary { ( "*" | "/" | "%" ) unary }
+//	unary          = "-" unary | postfix
+//	postfix        = primary { "[" or "]" }
+//	primary        = literal | identifier | function "(" [ or { "," or } ] ")"
+//	                 | "[" [ or { "," or } ] "]"
+//	                 | "{" [ string ":" or { "," string ":" or } ] "}"
+//	                 | "(" or ")"
+type parser struct {
+	tokens  []token
+	pos     int
+	columns map[string]int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, fmt.Errorf("at position %d: expected %s, got %s", tok.pos, what, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := p.peek(); tok.kind {
+	case tokEq, tokNe, tokLt, tokLe, tokGt, tokGe:
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: tok.kind, left: left, right: right}, nil
+
+	case tokIn:
+		p.next()
+		list, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{value: left, list: list}, nil
+
+	case tokNot:
+		p.next()
+		if _, err := p.expect(tokIn, `"in" after "not"`); err != nil {
+			return nil, err
+		}
+		list, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: &inNode{value: left, list: list}}, nil
+
+	case tokIs:
+		p.next()
+		negate := false
+		if p.peek().kind == tokNot {
+			p.next()
+			negate = true
+		}
+		if _, err := p.expect(tokNull, `"null" after "is"`); err != nil {
+			return nil, err
+		}
+		return &isNullNode{operand: left, negate: negate}, nil
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().kind
+		if op != tokPlus && op != tokMinus {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().kind
+		if op != tokStar && op != tokSlash && op != tokPercent {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind == tokMinus {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateNode{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	target, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokLBracket {
+		p.next()
+		key, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRBracket, `"]"`); err != nil {
+			return nil, err
+		}
+		target = &indexNode{target: target, key: key}
+	}
+	return target, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return &literalNode{value: mmdbtype.String(tok.text)}, nil
+
+	case tokNumber:
+		value, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("at position %d: %w", tok.pos, err)
+		}
+		return &literalNode{value: value}, nil
+
+	case tokTrue:
+		return &literalNode{value: mmdbtype.Bool(true)}, nil
+
+	case tokFalse:
+		return &literalNode{value: mmdbtype.Bool(false)}, nil
+
+	case tokNull:
+		return &literalNode{value: nil}, nil
+
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			return p.parseCall(tok)
+		}
+		index, ok := p.columns[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("at position %d: unknown column %q", tok.pos, tok.text)
+		}
+		return &columnNode{name: tok.text, index: index}, nil
+
+	case tokLBracket:
+		return p.parseList()
+
+	case tokLBrace:
+		return p.parseMap()
+
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	default:
+		return nil, fmt.Errorf("at position %d: unexpected %s", tok.pos, tok)
+	}
+}
+
+// parseList parses the remainder of a list literal after the opening bracket.
+func (p *parser) parseList() (node, error) {
+	list := &listNode{}
+	if p.peek().kind == tokRBracket {
+		p.next()
+		return list, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, item)
+
+		tok := p.next()
+		switch tok.kind {
+		case tokComma:
+			continue
+		case tokRBracket:
+			return list, nil
+		default:
+			return nil, fmt.Errorf(`at position %d: expected "," or "]", got %s`, tok.pos, tok)
+		}
+	}
+}
+
+// parseMap parses the remainder of a map literal after the opening brace.
+// Keys must be string literals.
+func (p *parser) parseMap() (node, error) {
+	m := &mapNode{}
+	if p.peek().kind == tokRBrace {
+		p.next()
+		return m, nil
+	}
+	seen := map[string]bool{}
+	for {
+		key, err := p.expect(tokString, "string map key")
+		if err != nil {
+			return nil, err
+		}
+		if seen[key.text] {
+			return nil, fmt.Errorf("at position %d: duplicate map key %q", key.pos, key.text)
+		}
+		seen[key.text] = true
+
+		if _, err := p.expect(tokColon, `":" after map key`); err != nil {
+			return nil, err
+		}
+		value, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		m.keys = append(m.keys, mmdbtype.String(key.text))
+		m.values = append(m.values, value)
+
+		tok := p.next()
+		switch tok.kind {
+		case tokComma:
+			continue
+		case tokRBrace:
+			return m, nil
+		default:
+			return nil, fmt.Errorf(`at position %d: expected "," or "}", got %s`, tok.pos, tok)
+		}
+	}
+}
+
+// parseCall parses a function call whose name token has been consumed.
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("at position %d: unknown function %q", name.pos, name.text)
+	}
+	p.next() // "("
+
+	var args []node
+	if p.peek().kind == tokRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			tok := p.next()
+			if tok.kind == tokRParen {
+				break
+			}
+			if tok.kind != tokComma {
+				return nil, fmt.Errorf(`at position %d: expected "," or ")", got %s`, tok.pos, tok)
+			}
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf(
+			"at position %d: %s() takes %s, got %d",
+			name.pos,
+			name.text,
+			fn.arity(),
+			len(args),
+		)
+	}
+	return &callNode{name: name.text, fn: fn, args: args}, nil
+}
+
+// parseNumber converts a number literal to a Float64, or to the smallest
+// integer type that holds it.
+func parseNumber(text string) (mmdbtype.DataType, error) {
+	if i, ok := new(big.Int).SetString(text, 10); ok {
+		return integerValue(i)
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	return mmdbtype.Float64(f), nil
+}
@@ -0,0 +1,77 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile_Valid(t *testing.T) {
+	columns := []string{"country_iso", "is_anonymous_vpn", "accuracy_radius"}
+
+	tests := []string{
+		`is_anonymous_vpn == true`,
+		`country_iso in ["RU", "CN"]`,
+		`country_iso not in ['RU', 'CN']`,
+		`country_iso is null`,
+		`country_iso is not null`,
+		`accuracy_radius >= 100 and accuracy_radius < 1000`,
+		`not (country_iso == "US" or country_iso == "CA")`,
+		`accuracy_radius > -1.5e2`,
+		`country_iso == "it\'s \"quoted\""`,
+		`[]  == []`,
+	}
+
+	for _, source := range tests {
+		t.Run(source, func(t *testing.T) {
+			program, err := Compile(source, columns)
+			require.NoError(t, err)
+			assert.Equal(t, source, program.String())
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	columns := []string{"country_iso", "accuracy_radius"}
+
+	tests := []struct {
+		source      string
+		expectError string
+	}{
+		{source: ``, expectError: "unexpected end of expression"},
+		{source: `city == "Paris"`, expectError: `unknown column "city"`},
+		{source: `country_iso == "US`, expectError: "unterminated string"},
+		{source: `country_iso == 'a\q'`, expectError: `unknown escape sequence \q`},
+		{source: `country_iso = "US"`, expectError: `unexpected character '='`},
+		{source: `(country_iso == "US"`, expectError: `expected ")"`},
+		{source: `country_iso in ["US" "CA"]`, expectError: `expected "," or "]"`},
+		{source: `country_iso is "US"`, expectError: `expected "null" after "is"`},
+		{source: `country_iso not "US"`, expectError: `expected "in" after "not"`},
+		{
+			source:      `accuracy_radius < 1 < 2`,
+			expectError: `at position 21: unexpected "<"`,
+		},
+		{
+			source:      `accuracy_radius < 999999999999999999999999999999999999999999`,
+			expectError: "cannot be represented",
+		},
+		{source: `lower(country_iso)`, expectError: `unknown function "lower"`},
+		{source: `if(true, 1)`, expectError: "if() takes 3 arguments, got 2"},
+		{source: `concat()`, expectError: "concat() takes at least 1 argument, got 0"},
+		{source: `concat(1 2)`, expectError: `expected "," or ")"`},
+		{source: `{"a": 1, "a": 2}`, expectError: `duplicate map key "a"`},
+		{source: `{a: 1}`, expectError: "expected string map key"},
+		{source: `{"a" 1}`, expectError: `expected ":" after map key`},
+		{source: `{"a": 1 "b": 2}`, expectError: `expected "," or "}"`},
+		{source: `["a"][0`, expectError: `expected "]"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := Compile(tt.source, columns)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package merger
+
+import (
+	"fmt"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/expr"
+)
+
+// computedColumn is a column whose value is derived from an expression over
+// the columns defined before it.
+type computedColumn struct {
+	program    *expr.Program
+	name       mmdbtype.String   // Column name for error messages
+	colIndex   int               // Index in config.Columns for slice ordering
+	transforms transformPipeline // Value transforms applied to the result
+}
+
+// compileComputedColumns compiles the expressions of computed columns. Each
+// expression may only reference columns that precede it in the config.
+func compileComputedColumns(cfg *config.Config) ([]computedColumn, error) {
+	var computed []computedColumn
+	columnNames := config.ColumnNames(cfg.Columns)
+	for i, column := range cfg.Columns {
+		if column.Expression == "" {
+			continue
+		}
+		program, err := expr.Compile(column.Expression, columnNames[:i])
+		if err != nil {
+			return nil, fmt.Errorf(
+				"compiling expression for column '%s': %w",
+				column.Name,
+				err,
+			)
+		}
+		transforms, err := compileTransforms(column.Transforms)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"compiling transforms for column '%s': %w",
+				column.Name,
+				err,
+			)
+		}
+		computed = append(computed, computedColumn{
+			program:    program,
+			name:       column.Name,
+			colIndex:   i,
+			transforms: transforms,
+		})
+	}
+	return computed, nil
+}
+
+// evaluateComputedColumns fills in the computed column values of data in
+// place. Extracted columns must already be populated.
+func (m *Merger) evaluateComputedColumns(data []mmdbtype.DataType) error {
+	for _, column := range m.computed {
+		value, err := column.program.Eval(data)
+		if err != nil {
+			return fmt.Errorf("evaluating column '%s': %w", column.name, err)
+		}
+		value, err = column.transforms.apply(value)
+		if err != nil {
+			return fmt.Errorf("transforming column '%s': %w", column.name, err)
+		}
+		data[column.colIndex] = value
+	}
+	return nil
+}
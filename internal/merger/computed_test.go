@@ -0,0 +1,92 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func TestEvaluateComputedColumns(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+			{Name: "latitude", Database: "city", Path: config.Path{"location", "latitude"}},
+			{Name: "longitude", Database: "city", Path: config.Path{"location", "longitude"}},
+			{Name: "is_eu", Expression: `country_iso in ["DE", "FR", "IT"]`},
+			{Name: "lat_lon", Expression: `concat(latitude, ",", longitude)`},
+			{Name: "region", Expression: `if(is_eu, "EU", "other")`},
+		},
+	}
+
+	computed, err := compileComputedColumns(cfg)
+	require.NoError(t, err)
+	require.Len(t, computed, 3)
+	m := &Merger{computed: computed}
+
+	data := []mmdbtype.DataType{
+		mmdbtype.String("DE"),
+		mmdbtype.Float64(52.5),
+		mmdbtype.Float64(13.4),
+		nil,
+		nil,
+		nil,
+	}
+	require.NoError(t, m.evaluateComputedColumns(data))
+
+	assert.Equal(t, mmdbtype.Bool(true), data[3])
+	assert.Equal(t, mmdbtype.String("52.5,13.4"), data[4])
+	assert.Equal(
+		t,
+		mmdbtype.String("EU"),
+		data[5],
+		"computed columns can use earlier computed columns",
+	)
+}
+
+func TestCompileComputedColumns_ForwardReference(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "is_eu", Expression: `country_iso == "DE"`},
+			{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+		},
+	}
+
+	_, err := compileComputedColumns(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `compiling expression for column 'is_eu'`)
+	assert.Contains(t, err.Error(), `unknown column "country_iso"`)
+}
+
+func TestEvaluateComputedColumns_Error(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+			{Name: "bad", Expression: `country_iso * 2`},
+		},
+	}
+
+	computed, err := compileComputedColumns(cfg)
+	require.NoError(t, err)
+	m := &Merger{computed: computed}
+
+	err = m.evaluateComputedColumns([]mmdbtype.DataType{mmdbtype.String("DE"), nil})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "evaluating column 'bad'")
+}
+
+func TestGetUniqueDatabaseNames_SkipsComputedColumns(t *testing.T) {
+	m := &Merger{
+		config: &config.Config{
+			Columns: []config.Column{
+				{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+				{Name: "is_us", Expression: `country_iso == "US"`},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"city"}, m.getUniqueDatabaseNames())
+}
@@ -0,0 +1,44 @@
+package merger
+
+import (
+	"fmt"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/expr"
+)
+
+// compileFilters compiles the configured row filters, binding column names to
+// their index in the extracted data slice.
+func compileFilters(cfg *config.Config) ([]*expr.Program, error) {
+	if len(cfg.Filters) == 0 {
+		return nil, nil
+	}
+
+	columnNames := config.ColumnNames(cfg.Columns)
+	filters := make([]*expr.Program, len(cfg.Filters))
+	for i, filter := range cfg.Filters {
+		program, err := expr.Compile(filter.Expression, columnNames)
+		if err != nil {
+			return nil, fmt.Errorf("compiling filter %q: %w", filter.Expression, err)
+		}
+		filters[i] = program
+	}
+	return filters, nil
+}
+
+// matchesFilters reports whether data satisfies every configured filter.
+// Filters are evaluated in order and evaluation stops at the first mismatch.
+func (m *Merger) matchesFilters(data []mmdbtype.DataType) (bool, error) {
+	for _, filter := range m.filters {
+		matched, err := filter.EvalBool(data)
+		if err != nil {
+			return false, fmt.Errorf("evaluating filter %q: %w", filter, err)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
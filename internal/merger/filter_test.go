@@ -0,0 +1,100 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func TestMatchesFilters(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+			{Name: "is_anonymous_vpn", Database: "anon", Path: config.Path{"is_anonymous_vpn"}},
+		},
+		Filters: []config.Filter{
+			{Expression: `country_iso in ["RU", "CN"]`},
+			{Expression: `is_anonymous_vpn == true`},
+		},
+	}
+
+	filters, err := compileFilters(cfg)
+	require.NoError(t, err)
+	m := &Merger{filters: filters}
+
+	tests := []struct {
+		name     string
+		data     []mmdbtype.DataType
+		expected bool
+	}{
+		{
+			name:     "all filters match",
+			data:     []mmdbtype.DataType{mmdbtype.String("RU"), mmdbtype.Bool(true)},
+			expected: true,
+		},
+		{
+			name:     "first filter fails",
+			data:     []mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.Bool(true)},
+			expected: false,
+		},
+		{
+			name:     "second filter fails",
+			data:     []mmdbtype.DataType{mmdbtype.String("CN"), mmdbtype.Bool(false)},
+			expected: false,
+		},
+		{
+			name:     "missing values do not match",
+			data:     []mmdbtype.DataType{nil, nil},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := m.matchesFilters(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, matched)
+		})
+	}
+}
+
+func TestMatchesFilters_NoFilters(t *testing.T) {
+	m := &Merger{}
+	matched, err := m.matchesFilters([]mmdbtype.DataType{nil})
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
+
+func TestMatchesFilters_EvaluationError(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+		},
+		Filters: []config.Filter{{Expression: `country_iso and true`}},
+	}
+
+	filters, err := compileFilters(cfg)
+	require.NoError(t, err)
+	m := &Merger{filters: filters}
+
+	_, err = m.matchesFilters([]mmdbtype.DataType{mmdbtype.String("US")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `evaluating filter "country_iso and true"`)
+}
+
+func TestCompileFilters_UnknownColumn(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "country_iso", Database: "city", Path: config.Path{"country", "iso_code"}},
+		},
+		Filters: []config.Filter{{Expression: `city_name == "Paris"`}},
+	}
+
+	_, err := compileFilters(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown column "city_name"`)
+}
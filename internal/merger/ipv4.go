@@ -0,0 +1,106 @@
+package merger
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+
+	"github.com/maxmind/mmdbconvert/internal/mmdb"
+	"github.com/maxmind/mmdbconvert/internal/network"
+)
+
+// IPv6 databases store IPv4 data in the ::/96 subtree, which maxminddb
+// reports as IPv4 networks, and usually alias the IPv4-mapped subtree to it.
+var (
+	ipv4Subtree       = netip.MustParsePrefix("::/96")
+	ipv4MappedSubtree = netip.MustParsePrefix("::ffff:0:0/96")
+)
+
+// ipv6First returns the readers and names reordered so that IPv6 databases
+// come first, keeping the relative order of each group, along with which of
+// them are IPv4-only. A merge of IPv4-only and IPv6 databases iterates the
+// first database, which must cover the IPv6 space.
+func ipv6First(readers []*mmdb.Reader, names []string) ([]*mmdb.Reader, []string, []bool) {
+	orderedReaders := make([]*mmdb.Reader, 0, len(readers))
+	orderedNames := make([]string, 0, len(names))
+	ipv4Only := make([]bool, 0, len(readers))
+	for _, wantIPv4 := range []bool{false, true} {
+		for i, reader := range readers {
+			if (reader.Metadata().IPVersion == 4) != wantIPv4 {
+				continue
+			}
+			orderedReaders = append(orderedReaders, reader)
+			orderedNames = append(orderedNames, names[i])
+			ipv4Only = append(ipv4Only, wantIPv4)
+		}
+	}
+	return orderedReaders, orderedNames, ipv4Only
+}
+
+// processIPv4OnlyNetwork processes an IPv6 network through an IPv4-only
+// database merged with IPv6 databases. The database is mapped into the
+// IPv4-mapped ::ffff:0:0/96 subtree and, unless ipv4_only_subtrees is
+// "ipv4_mapped", the ::/96 subtree, and has no data elsewhere. Networks
+// containing a mapped subtree are split until they reach it. IPv4 networks,
+// which are the ::/96 subtree of IPv6 databases, only get here if it is not
+// mapped.
+func (m *Merger) processIPv4OnlyNetwork(effectivePrefix netip.Prefix, dbIndex int) error {
+	switch {
+	case m.mapIPv4Subtree && effectivePrefix == ipv4Subtree:
+		return m.processNetwork(netip.PrefixFrom(netip.IPv4Unspecified(), 0), dbIndex)
+
+	case effectivePrefix.Bits() >= ipv4MappedSubtree.Bits() &&
+		ipv4MappedSubtree.Contains(effectivePrefix.Addr()):
+		return m.processIPv4MappedNetwork(effectivePrefix, dbIndex)
+
+	case effectivePrefix.Overlaps(ipv4MappedSubtree) ||
+		(m.mapIPv4Subtree && effectivePrefix.Overlaps(ipv4Subtree)):
+		halves, err := network.SplitPrefix(effectivePrefix, effectivePrefix.Bits()+1)
+		if err != nil {
+			return err
+		}
+		for _, half := range halves {
+			if err := m.processNetwork(half, dbIndex); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		m.noData[dbIndex] = true
+		defer func() { m.noData[dbIndex] = false }()
+		return m.processNetwork(effectivePrefix, dbIndex+1)
+	}
+}
+
+// processIPv4MappedNetwork processes a network in the ::ffff:0:0/96 subtree
+// through an IPv4-only database by looking up the corresponding IPv4 network
+// and mapping the networks found back into the subtree.
+func (m *Merger) processIPv4MappedNetwork(effectivePrefix netip.Prefix, dbIndex int) error {
+	ipv4Prefix := netip.PrefixFrom(
+		effectivePrefix.Addr().Unmap(),
+		effectivePrefix.Bits()-ipv4MappedSubtree.Bits(),
+	)
+
+	currentReader := m.readersList[dbIndex]
+	for result := range currentReader.NetworksWithin(ipv4Prefix, maxminddb.IncludeNetworksWithoutData()) {
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("iterating database within %s: %w", effectivePrefix, err)
+		}
+
+		next := result.Prefix()
+		nextNetwork := netip.PrefixFrom(
+			netip.AddrFrom16(next.Addr().As16()),
+			next.Bits()+ipv4MappedSubtree.Bits(),
+		)
+		smallest := network.SmallestNetwork(effectivePrefix, nextNetwork)
+
+		m.resultsBuffer[dbIndex] = result
+		if err := m.processNetwork(smallest, dbIndex+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package merger
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// namesKey is the key of the localized names maps pruned by the locales
+// option, as in GeoIP2 records.
+const namesKey = mmdbtype.String("names")
+
+// localeFilter keeps only the selected locales in the names maps of extracted
+// values.
+type localeFilter map[mmdbtype.String]bool
+
+// newLocaleFilter returns the filter for locales, or nil if no locales are
+// selected.
+func newLocaleFilter(locales []string) localeFilter {
+	if len(locales) == 0 {
+		return nil
+	}
+	f := make(localeFilter, len(locales))
+	for _, locale := range locales {
+		f[mmdbtype.String(locale)] = true
+	}
+	return f
+}
+
+// apply returns value with the non-selected locales removed from its names
+// maps. isNames reports whether value itself was found under a names key, as
+// for the path ["country", "names"], in which case it is a names map or, for
+// a wildcard path, a list of them. value is never modified, since decoded
+// values may be shared through the unmarshaler cache; the maps and slices
+// that change are copied.
+func (f localeFilter) apply(value mmdbtype.DataType, isNames bool) mmdbtype.DataType {
+	if f == nil {
+		return value
+	}
+	pruned, _ := f.prune(value, isNames)
+	return pruned
+}
+
+// prune implements apply and reports whether value changed.
+func (f localeFilter) prune(value mmdbtype.DataType, isNames bool) (mmdbtype.DataType, bool) {
+	switch v := value.(type) {
+	case mmdbtype.Map:
+		if isNames {
+			return f.pruneNames(v)
+		}
+		var pruned mmdbtype.Map
+		for key, elem := range v {
+			newElem, changed := f.prune(elem, key == namesKey)
+			if !changed {
+				continue
+			}
+			if pruned == nil {
+				pruned = maps.Clone(v)
+			}
+			pruned[key] = newElem
+		}
+		if pruned == nil {
+			return v, false
+		}
+		return pruned, true
+	case mmdbtype.Slice:
+		var pruned mmdbtype.Slice
+		for i, elem := range v {
+			newElem, changed := f.prune(elem, isNames)
+			if changed && pruned == nil {
+				pruned = slices.Clone(v)
+			}
+			if pruned != nil {
+				pruned[i] = newElem
+			}
+		}
+		if pruned == nil {
+			return v, false
+		}
+		return pruned, true
+	default:
+		return value, false
+	}
+}
+
+// pruneNames removes the non-selected locales from a names map.
+func (f localeFilter) pruneNames(names mmdbtype.Map) (mmdbtype.DataType, bool) {
+	kept := 0
+	for locale := range names {
+		if f[locale] {
+			kept++
+		}
+	}
+	if kept == len(names) {
+		return names, false
+	}
+
+	pruned := make(mmdbtype.Map, kept)
+	for locale, name := range names {
+		if f[locale] {
+			pruned[locale] = name
+		}
+	}
+	return pruned, true
+}
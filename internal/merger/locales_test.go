@@ -0,0 +1,131 @@
+package merger
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/mmdb"
+)
+
+func TestLocaleFilter_Apply(t *testing.T) {
+	names := func() mmdbtype.Map {
+		return mmdbtype.Map{
+			"en": mmdbtype.String("Germany"),
+			"de": mmdbtype.String("Deutschland"),
+			"ja": mmdbtype.String("ドイツ"),
+		}
+	}
+	english := mmdbtype.Map{"en": mmdbtype.String("Germany")}
+
+	tests := []struct {
+		name     string
+		input    func() mmdbtype.DataType
+		isNames  bool
+		expected mmdbtype.DataType
+	}{
+		{
+			name: "nested names maps",
+			input: func() mmdbtype.DataType {
+				return mmdbtype.Map{
+					"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE"), "names": names()},
+					"subdivisions": mmdbtype.Slice{
+						mmdbtype.Map{"names": names()},
+					},
+				}
+			},
+			expected: mmdbtype.Map{
+				"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE"), "names": english},
+				"subdivisions": mmdbtype.Slice{
+					mmdbtype.Map{"names": english},
+				},
+			},
+		},
+		{
+			name:     "names map",
+			input:    func() mmdbtype.DataType { return names() },
+			isNames:  true,
+			expected: english,
+		},
+		{
+			name: "names maps from a wildcard path",
+			input: func() mmdbtype.DataType {
+				return mmdbtype.Slice{names(), names()}
+			},
+			isNames:  true,
+			expected: mmdbtype.Slice{english, english},
+		},
+		{
+			name:     "other map",
+			input:    func() mmdbtype.DataType { return names() },
+			expected: names(),
+		},
+		{
+			name:     "scalar",
+			input:    func() mmdbtype.DataType { return mmdbtype.String("Deutschland") },
+			isNames:  true,
+			expected: mmdbtype.String("Deutschland"),
+		},
+	}
+
+	f := newLocaleFilter([]string{"en", "fr"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input()
+			assert.Equal(t, tt.expected, f.apply(input, tt.isNames))
+			assert.Equal(t, tt.input(), input, "source value must not be modified")
+		})
+	}
+
+	assert.Nil(t, newLocaleFilter(nil))
+	assert.Equal(t, names(), newLocaleFilter(nil).apply(names(), true))
+}
+
+func TestMerger_Locales(t *testing.T) {
+	dir := t.TempDir()
+	cityPath := writeTestMMDB(t, filepath.Join(dir, "city.mmdb"), map[string]mmdbtype.Map{
+		"2.0.0.0/16": {
+			"country": mmdbtype.Map{
+				"iso_code": mmdbtype.String("FR"),
+				"names": mmdbtype.Map{
+					"en": mmdbtype.String("France"),
+					"de": mmdbtype.String("Frankreich"),
+				},
+			},
+		},
+	})
+
+	cfg := &config.Config{
+		Locales:   []string{"en"},
+		Databases: []config.Database{{Name: "city", Path: cityPath}},
+		Columns: []config.Column{
+			{Name: "record", Database: "city", Path: config.Path{}},
+			{Name: "names", Database: "city", Path: config.Path{"country", "names"}},
+			{Name: "german", Database: "city", Path: config.Path{"country", "names", "de"}},
+		},
+	}
+
+	readers, err := mmdb.OpenDatabases(map[string]string{"city": cityPath})
+	require.NoError(t, err)
+	defer readers.Close()
+
+	rowWriter := &mockWriter{}
+	m, err := NewMerger(readers, cfg, rowWriter)
+	require.NoError(t, err)
+	require.NoError(t, m.Merge())
+
+	require.Len(t, rowWriter.rows, 1)
+	english := mmdbtype.Map{"en": mmdbtype.String("France")}
+	assert.Equal(t, []mmdbtype.DataType{
+		mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("FR"), "names": english},
+		},
+		english,
+		// Explicit paths to a locale are not pruned.
+		mmdbtype.String("Frankreich"),
+	}, rowWriter.rows[0].data)
+}
@@ -9,7 +9,9 @@ package merger
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/oschwald/maxminddb-golang/v2"
 
 	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/expr"
 	"github.com/maxmind/mmdbconvert/internal/mmdb"
 	"github.com/maxmind/mmdbconvert/internal/network"
 )
@@ -57,12 +60,14 @@ func (p *slicePool) Put(s []mmdbtype.DataType) {
 // columnExtractor caches the reader and path segments for a column to avoid
 // per-row lookups and allocations.
 type columnExtractor struct {
-	reader   *mmdb.Reader    // Pre-resolved reader for this column
-	path     []any           // Cached path segments (avoids per-row slice allocation)
-	name     mmdbtype.String // Column name for error messages
-	database string          // Database name for error messages
-	dbIndex  int             // Index in readersList for O(1) Result lookup
-	colIndex int             // Index in config.Columns for slice ordering
+	reader     *mmdb.Reader      // Pre-resolved reader for this column
+	path       []any             // Cached path segments (avoids per-row slice allocation)
+	name       mmdbtype.String   // Column name for error messages
+	database   string            // Database name for error messages
+	dbIndex    int               // Index in readersList for O(1) Result lookup
+	colIndex   int               // Index in config.Columns for slice ordering
+	transforms transformPipeline // Value transforms applied after walkPath
+	isNames    bool              // Whether the path ends at a names map, for locale pruning
 }
 
 // Merger handles merging multiple MMDB databases into a single output stream.
@@ -73,10 +78,21 @@ type Merger struct {
 	readersList   []*mmdb.Reader    // Ordered list of readers for iteration
 	dbNamesList   []string          // Corresponding database names
 	extractors    []columnExtractor // Pre-built extractors for each column
+	computed      []computedColumn  // Columns derived from expressions, in config order
+	filters       []*expr.Program   // Compiled row filters, all of which must match
 	unmarshalers  []*mmdbtype.Unmarshaler
 	slicePool     *slicePool          // Pool for reusable data slices
 	workingSlice  []mmdbtype.DataType // Reusable working slice (cleared each iteration)
 	resultsBuffer []maxminddb.Result  // Pre-allocated buffer for recursion (eliminates slices.Concat allocations)
+	ipv4Only      []bool              // IPv4-only databases merged with IPv6 ones (nil if there are none)
+	noData        []bool              // Databases without a Result for the network being processed
+	ipVersion     int                 // IP version the output is restricted to (0 for both)
+	locales       localeFilter        // Locales kept in names maps (nil keeps all)
+	// Whether IPv4-only databases are mapped into the ::/96 subtree in
+	// addition to the IPv4-mapped one
+	mapIPv4Subtree bool
+
+	includeEmptyRows bool
 }
 
 // NewMerger creates a new merger instance.
@@ -92,11 +108,15 @@ func NewMerger(readers *mmdb.Readers, cfg *config.Config, writer RowWriter) (*Me
 
 	// Create Merger instance with pool
 	m := &Merger{
-		readers:      readers,
-		config:       cfg,
-		acc:          NewAccumulator(writer, includeEmptyRows, slicePool),
-		slicePool:    slicePool,
-		workingSlice: make([]mmdbtype.DataType, len(cfg.Columns)),
+		readers:          readers,
+		config:           cfg,
+		acc:              NewAccumulator(writer, includeEmptyRows, slicePool),
+		slicePool:        slicePool,
+		workingSlice:     make([]mmdbtype.DataType, len(cfg.Columns)),
+		includeEmptyRows: includeEmptyRows,
+		ipVersion:        cfg.Output.NetworkIPVersion(),
+		locales:          newLocaleFilter(cfg.Locales),
+		mapIPv4Subtree:   cfg.IPv4OnlySubtrees != config.IPv4OnlySubtreesMapped,
 	}
 
 	// Build ordered list of unique database names
@@ -116,19 +136,35 @@ func NewMerger(readers *mmdb.Readers, cfg *config.Config, writer RowWriter) (*Me
 		}
 		readersList = append(readersList, reader)
 	}
+
+	// Validate IP versions before building extractors. IPv4-only databases
+	// merged with IPv6 ones are mapped into the IPv6 space.
+	mixed, err := validateIPVersions(readersList, dbNamesList)
+	if err != nil {
+		return nil, err
+	}
+	if mixed {
+		readersList, dbNamesList, m.ipv4Only = ipv6First(readersList, dbNamesList)
+		m.dbNamesList = dbNamesList
+		m.noData = make([]bool, len(readersList))
+	}
 	m.readersList = readersList
 
+	if m.ipVersion == 6 && readersList[0].Metadata().IPVersion == 4 {
+		return nil, errors.New("output is restricted to IPv6 networks but all databases are IPv4-only")
+	}
+
 	// Pre-allocate results buffer for recursion (eliminates slices.Concat allocations)
 	m.resultsBuffer = make([]maxminddb.Result, len(readersList))
 
-	// Validate IP versions before building extractors
-	if err := validateIPVersions(readersList, dbNamesList); err != nil {
-		return nil, err
-	}
-
-	// Pre-build column extractors with dbIndex values
-	extractors := make([]columnExtractor, len(cfg.Columns))
+	// Pre-build column extractors with dbIndex values. Computed columns have
+	// no database and are compiled separately below.
+	extractors := make([]columnExtractor, 0, len(cfg.Columns))
 	for i, column := range cfg.Columns {
+		if column.Expression != "" {
+			continue
+		}
+
 		reader, ok := readers.Get(column.Database)
 		if !ok {
 			return nil, fmt.Errorf(
@@ -158,17 +194,40 @@ func NewMerger(readers *mmdb.Readers, cfg *config.Config, writer RowWriter) (*Me
 			}
 		}
 
-		extractors[i] = columnExtractor{
-			reader:   reader,
-			path:     pathSegments,
-			name:     column.Name,
-			database: column.Database,
-			dbIndex:  dbIdx,
-			colIndex: i,
+		transforms, err := compileTransforms(column.Transforms)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"compiling transforms for column '%s': %w",
+				column.Name,
+				err,
+			)
 		}
+
+		extractors = append(extractors, columnExtractor{
+			reader:     reader,
+			path:       pathSegments,
+			name:       column.Name,
+			database:   column.Database,
+			dbIndex:    dbIdx,
+			colIndex:   i,
+			transforms: transforms,
+			isNames:    len(pathSegments) > 0 && pathSegments[len(pathSegments)-1] == string(namesKey),
+		})
 	}
 	m.extractors = extractors
 
+	computed, err := compileComputedColumns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.computed = computed
+
+	filters, err := compileFilters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.filters = filters
+
 	// Create per-database unmarshaler to avoid cross-database cache contamination.
 	// When cfg.DisableCache is false (default), use NewUnmarshaler() which provides caching.
 	// When cfg.DisableCache is true, use zero-value unmarshalers which have no cache.
@@ -191,13 +250,24 @@ func (m *Merger) Merge() error {
 	// readersList and dbNamesList are already built in NewMerger()
 	firstReader := m.readersList[0]
 
-	// Iterate all networks in the first database
-	for result := range firstReader.Networks(maxminddb.IncludeNetworksWithoutData()) {
+	// Iterate all networks in the first database, or only its IPv4 subtree
+	// if the output is restricted to IPv4
+	networks := firstReader.Networks(maxminddb.IncludeNetworksWithoutData())
+	if m.ipVersion == 4 {
+		networks = firstReader.NetworksWithin(
+			netip.PrefixFrom(netip.IPv4Unspecified(), 0),
+			maxminddb.IncludeNetworksWithoutData(),
+		)
+	}
+	for result := range networks {
 		if err := result.Err(); err != nil {
 			return fmt.Errorf("iterating first database: %w", err)
 		}
 
 		prefix := result.Prefix()
+		if m.ipVersion == 6 && prefix.Addr().Is4() {
+			continue // IPv4 subtree of an IPv6 database
+		}
 
 		// If there's only one database, extract and process directly
 		if len(m.readersList) == 1 {
@@ -242,6 +312,11 @@ func (m *Merger) processNetwork(
 		return m.extractAndProcess(m.resultsBuffer[:dbIndex], effectivePrefix)
 	}
 
+	if m.ipv4Only != nil && m.ipv4Only[dbIndex] &&
+		(!effectivePrefix.Addr().Is4() || !m.mapIPv4Subtree) {
+		return m.processIPv4OnlyNetwork(effectivePrefix, dbIndex)
+	}
+
 	currentReader := m.readersList[dbIndex]
 
 	// Iterate networks within effectivePrefix in this database
@@ -272,8 +347,25 @@ func (m *Merger) processNetwork(
 	return nil
 }
 
-// extractAndProcess extracts data for all columns using precomputed Results,
-// then feeds the result to the accumulator.
+// extractAndProcess builds the row for effectivePrefix from precomputed
+// Results, then feeds it to the accumulator.
+func (m *Merger) extractAndProcess(
+	results []maxminddb.Result,
+	effectivePrefix netip.Prefix,
+) error {
+	ok, err := m.buildRow(results, effectivePrefix)
+	if err != nil || !ok {
+		return err
+	}
+
+	// Use the effectivePrefix parameter - NOT derived from results!
+	// The accumulator will copy this slice to a pooled slice if data changes
+	return m.acc.Process(effectivePrefix, m.workingSlice)
+}
+
+// buildRow extracts data for all columns using precomputed Results into
+// workingSlice and evaluates computed columns and filters. It returns false
+// if the network produces no row.
 //
 // Key optimization: Decode each database's full record once, then extract all
 // columns from the cached record. This reduces decoder allocations from
@@ -283,18 +375,22 @@ func (m *Merger) processNetwork(
 // Invariants:
 // - results[i] corresponds to readersList[i].
 // - effectivePrefix is the actual network being processed (may be smaller than result.Prefix()).
-func (m *Merger) extractAndProcess(
+func (m *Merger) buildRow(
 	results []maxminddb.Result,
 	effectivePrefix netip.Prefix,
-) error {
+) (bool, error) {
 	// Step 1: Decode full records once per database
 	// This replaces N decoder invocations (one per column) with M invocations (one per database)
 	// For typical configs: N=50+, M=1-3, so this is a ~16-50x reduction in decoder calls
 	decodedRecords := make([]mmdbtype.Map, len(results))
 	for i, result := range results {
+		if m.noData != nil && m.noData[i] {
+			continue // IPv4-only database outside of the IPv4 space
+		}
+
 		unmarshaler := m.unmarshalers[i]
 		if unmarshaler == nil {
-			return fmt.Errorf(
+			return false, fmt.Errorf(
 				"unmarshaler for database %d (%s) is nil (this is a bug)",
 				i,
 				m.dbNamesList[i],
@@ -303,7 +399,7 @@ func (m *Merger) extractAndProcess(
 
 		// Decode the full record (empty path means decode entire record)
 		if err := result.Decode(unmarshaler); err != nil {
-			return fmt.Errorf("decoding database %d (%s): %w", i, m.dbNamesList[i], err)
+			return false, fmt.Errorf("decoding database %d (%s): %w", i, m.dbNamesList[i], err)
 		}
 
 		// Get the decoded value and type-assert to Map
@@ -322,7 +418,7 @@ func (m *Merger) extractAndProcess(
 	for _, extractor := range m.extractors {
 		// Check if reader was resolved during initialization
 		if extractor.reader == nil {
-			return fmt.Errorf(
+			return false, fmt.Errorf(
 				"database '%s' not found for column '%s'",
 				extractor.database,
 				extractor.name,
@@ -340,14 +436,9 @@ func (m *Merger) extractAndProcess(
 			continue // No data in this database for this network
 		}
 
-		// Walk the path in the cached record to extract the value
-		value, err := walkPath(record, extractor.path)
+		value, err := m.extract(extractor, record)
 		if err != nil {
-			return fmt.Errorf(
-				"decoding path for column '%s': %w",
-				extractor.name,
-				err,
-			)
+			return false, err
 		}
 
 		// Store value at column index (nil values are OK - they indicate missing data)
@@ -356,24 +447,70 @@ func (m *Merger) extractAndProcess(
 		}
 	}
 
-	// Use the effectivePrefix parameter - NOT derived from results!
-	// The accumulator will copy this slice to a pooled slice if data changes
-	return m.acc.Process(effectivePrefix, m.workingSlice)
+	// Networks without any extracted data are dropped here rather than by the
+	// accumulator so that computed columns don't turn them into non-empty rows.
+	if !m.includeEmptyRows && isEmptyData(m.workingSlice) {
+		return false, nil
+	}
+
+	// Step 3: Evaluate computed columns in config order, so each one can use
+	// the values of the columns before it.
+	if err := m.evaluateComputedColumns(m.workingSlice); err != nil {
+		return false, fmt.Errorf("computing columns for %s: %w", effectivePrefix, err)
+	}
+
+	// Step 4: Drop networks that don't match the configured filters. This
+	// happens before accumulation so matching neighbors still merge.
+	matched, err := m.matchesFilters(m.workingSlice)
+	if err != nil {
+		return false, fmt.Errorf("filtering %s: %w", effectivePrefix, err)
+	}
+	return matched, nil
+}
+
+// extract returns the value of the extractor's column in a decoded record.
+func (m *Merger) extract(extractor columnExtractor, record mmdbtype.Map) (mmdbtype.DataType, error) {
+	// Walk the path in the cached record to extract the value
+	value, err := walkPath(record, extractor.path)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"decoding path for column '%s': %w",
+			extractor.name,
+			err,
+		)
+	}
+
+	value = m.locales.apply(value, extractor.isNames)
+
+	value, err = extractor.transforms.apply(value)
+	if err != nil {
+		return nil, fmt.Errorf("transforming column '%s': %w", extractor.name, err)
+	}
+	return value, nil
 }
 
 // walkPath navigates through a nested mmdbtype.Map/Slice structure using the given path.
-// Returns nil if the path doesn't exist.
+// Returns nil if the path doesn't exist. A config.PathWildcard segment collects
+// the matches across all elements into a Slice (see expandWildcard).
 func walkPath(root mmdbtype.Map, path []any) (mmdbtype.DataType, error) {
 	if len(path) == 0 {
 		// Empty path means return the entire record
 		return root, nil
 	}
 
-	var current mmdbtype.DataType = root
+	return walkFrom(root, path, 0)
+}
 
-	for i, segment := range path {
-		switch key := segment.(type) {
+// walkFrom navigates from current through path[start:]. Segments before start
+// have already been applied and are only used in error messages.
+func walkFrom(current mmdbtype.DataType, path []any, start int) (mmdbtype.DataType, error) {
+	for i := start; i < len(path); i++ {
+		switch key := path[i].(type) {
 		case string:
+			if key == config.PathWildcard {
+				return expandWildcard(current, path, i)
+			}
+
 			// Navigate through a map
 			m, ok := current.(mmdbtype.Map)
 			if !ok {
@@ -415,7 +552,7 @@ func walkPath(root mmdbtype.Map, path []any) (mmdbtype.DataType, error) {
 			return nil, fmt.Errorf(
 				"navigating path %s: unsupported segment type %T",
 				describeWalkPath(path[:i]),
-				segment,
+				path[i],
 			)
 		}
 	}
@@ -423,6 +560,52 @@ func walkPath(root mmdbtype.Map, path []any) (mmdbtype.DataType, error) {
 	return current, nil
 }
 
+// expandWildcard applies path[i+1:] to every element of the slice, or every
+// value of the map, found at the wildcard segment path[i], and collects the
+// results into a Slice. Map values are visited in key order so the output is
+// deterministic. Elements where the rest of the path is missing or doesn't
+// match their structure are skipped, and the lists produced by nested
+// wildcards are flattened. Returns nil if nothing matched.
+func expandWildcard(current mmdbtype.DataType, path []any, i int) (mmdbtype.DataType, error) {
+	var elements []mmdbtype.DataType
+	switch v := current.(type) {
+	case mmdbtype.Slice:
+		elements = v
+	case mmdbtype.Map:
+		keys := slices.Sorted(maps.Keys(v))
+		elements = make([]mmdbtype.DataType, len(keys))
+		for j, k := range keys {
+			elements[j] = v[k]
+		}
+	default:
+		return nil, fmt.Errorf(
+			"navigating path %s segment %q: expected map or slice but found %T",
+			describeWalkPath(path[:i]),
+			config.PathWildcard,
+			current,
+		)
+	}
+
+	nested := config.Path(path[i+1:]).HasWildcard()
+	var result mmdbtype.Slice
+	for _, element := range elements {
+		value, err := walkFrom(element, path, i+1)
+		if err != nil || value == nil {
+			continue
+		}
+		if s, ok := value.(mmdbtype.Slice); ok && nested {
+			result = append(result, s...)
+			continue
+		}
+		result = append(result, value)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
 func describeWalkPath(path []any) string {
 	if len(path) == 0 {
 		return "[]"
@@ -453,6 +636,9 @@ func (m *Merger) getUniqueDatabaseNames() []string {
 	var names []string
 
 	for _, column := range m.config.Columns {
+		if column.Database == "" {
+			continue // Computed column
+		}
 		if !seen[column.Database] {
 			seen[column.Database] = true
 			names = append(names, column.Database)
@@ -462,10 +648,11 @@ func (m *Merger) getUniqueDatabaseNames() []string {
 	return names
 }
 
-func validateIPVersions(readers []*mmdb.Reader, names []string) error {
+// validateIPVersions checks that every database is an IPv4 or IPv6 database
+// and reports whether IPv4-only databases are merged with IPv6 ones.
+func validateIPVersions(readers []*mmdb.Reader, names []string) (bool, error) {
 	var (
-		ipv4Only     []string
-		ipv6Capable  []string
+		ipv4Only     int
 		unsupportedV []string
 	)
 
@@ -473,9 +660,8 @@ func validateIPVersions(readers []*mmdb.Reader, names []string) error {
 		version := reader.Metadata().IPVersion
 		switch version {
 		case 4:
-			ipv4Only = append(ipv4Only, names[idx])
+			ipv4Only++
 		case 6:
-			ipv6Capable = append(ipv6Capable, names[idx])
 		default:
 			unsupportedV = append(
 				unsupportedV,
@@ -485,19 +671,11 @@ func validateIPVersions(readers []*mmdb.Reader, names []string) error {
 	}
 
 	if len(unsupportedV) > 0 {
-		return fmt.Errorf(
+		return false, fmt.Errorf(
 			"unsupported ip_version values reported: %s",
 			strings.Join(unsupportedV, ", "),
 		)
 	}
 
-	if len(ipv4Only) > 0 && len(ipv6Capable) > 0 {
-		return fmt.Errorf(
-			"configured databases mix IPv4-only (%s) and IPv6-capable (%s) files; run separate conversions per IP version or supply homogeneous databases",
-			strings.Join(ipv4Only, ", "),
-			strings.Join(ipv6Capable, ", "),
-		)
-	}
-
-	return nil
+	return ipv4Only > 0 && ipv4Only < len(readers), nil
 }
@@ -1,14 +1,20 @@
 package merger
 
 import (
+	"fmt"
 	"net/netip"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
 	"testing"
+	"time"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/stretchr/testify/require"
 
 	"github.com/maxmind/mmdbconvert/internal/config"
 	"github.com/maxmind/mmdbconvert/internal/mmdb"
+	"github.com/maxmind/mmdbconvert/internal/writer"
 )
 
 // discardWriter is a RowWriter that discards all data (for benchmarking).
@@ -61,3 +67,124 @@ func BenchmarkMergerFullMerge(b *testing.B) {
 		readers.Close()
 	}
 }
+
+// BenchmarkMergerMMDBOutput benchmarks merging two databases into an MMDB,
+// which holds the entire tree in memory until it is written, with and without
+// output.mmdb.low_memory. The records are built from individual columns, so
+// neighboring records repeat maps such as country and traits. It reports the
+// peak heap size during the merge and write as peak-heap-bytes.
+func BenchmarkMergerMMDBOutput(b *testing.B) {
+	databases := map[string]string{
+		"city": "../../testdata/MaxMind-DB/test-data/GeoIP2-City-Test.mmdb",
+		"isp":  "../../testdata/MaxMind-DB/test-data/GeoIP2-ISP-Test.mmdb",
+	}
+
+	// column copies path from database to outputPath, or to the same path if
+	// outputPath is nil.
+	column := func(database, name string, path, outputPath config.Path) config.Column {
+		if outputPath == nil {
+			outputPath = path
+		}
+		return config.Column{
+			Name:       mmdbtype.String(name),
+			Database:   database,
+			Path:       path,
+			OutputPath: &outputPath,
+		}
+	}
+
+	for _, lowMemory := range []bool{false, true} {
+		b.Run(fmt.Sprintf("low_memory=%t", lowMemory), func(b *testing.B) {
+			recordSize := 28
+			includeReserved := false
+			cfg := &config.Config{
+				Output: config.OutputConfig{
+					MMDB: config.MMDBConfig{
+						DatabaseType:            "Merged",
+						RecordSize:              &recordSize,
+						IncludeReservedNetworks: &includeReserved,
+						LowMemory:               lowMemory,
+					},
+				},
+				Columns: []config.Column{
+					column("city", "city_names", config.Path{"city", "names"}, nil),
+					column("city", "country_code", config.Path{"country", "iso_code"}, nil),
+					column("city", "country_names", config.Path{"country", "names"}, nil),
+					column("city", "latitude", config.Path{"location", "latitude"}, nil),
+					column("city", "longitude", config.Path{"location", "longitude"}, nil),
+					column("isp", "isp", config.Path{"isp"}, config.Path{"traits", "isp"}),
+					column(
+						"isp",
+						"asn",
+						config.Path{"autonomous_system_number"},
+						config.Path{"traits", "autonomous_system_number"},
+					),
+				},
+			}
+			outputPath := filepath.Join(b.TempDir(), "merged.mmdb")
+
+			b.ReportAllocs()
+
+			var peak uint64
+			for b.Loop() {
+				b.StopTimer()
+				readers, err := mmdb.OpenDatabases(databases)
+				require.NoError(b, err)
+
+				w, err := writer.NewMMDBWriter(outputPath, cfg, 6, nil)
+				require.NoError(b, err)
+				merger, err := NewMerger(readers, cfg, w)
+				require.NoError(b, err)
+
+				runtime.GC()
+				sampler := startHeapSampler()
+				b.StartTimer()
+				err = merger.Merge()
+				if err == nil {
+					err = w.Flush()
+				}
+				b.StopTimer()
+				peak = max(peak, sampler.stop())
+
+				require.NoError(b, err)
+				readers.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(peak), "peak-heap-bytes")
+		})
+	}
+}
+
+// heapSampler records the largest heap size seen while it runs.
+type heapSampler struct {
+	done chan struct{}
+	peak chan uint64
+}
+
+func startHeapSampler() *heapSampler {
+	s := &heapSampler{done: make(chan struct{}), peak: make(chan uint64)}
+	go func() {
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		var peak uint64
+		for {
+			metrics.Read(sample)
+			peak = max(peak, sample[0].Value.Uint64())
+			select {
+			case <-s.done:
+				s.peak <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// stop stops the sampler and returns the peak heap size in bytes.
+func (s *heapSampler) stop() uint64 {
+	close(s.done)
+	return <-s.peak
+}
@@ -3,8 +3,10 @@ package merger
 import (
 	"errors"
 	"net/netip"
+	"path/filepath"
 	"testing"
 
+	"github.com/maxmind/mmdbwriter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -256,26 +258,247 @@ func TestMerger_MissingDatabase(t *testing.T) {
 	assert.Contains(t, err.Error(), "nonexistent")
 }
 
-func TestMerger_MixedIPVersionsFails(t *testing.T) {
-	databases := map[string]string{
-		"ipv4": testDataDir + "/MaxMind-DB-test-ipv4-24.mmdb",
-		"ipv6": testDataDir + "/MaxMind-DB-test-ipv6-32.mmdb",
+func TestMerger_MixedIPVersions(t *testing.T) {
+	dir := t.TempDir()
+	ipv4Path := writeTestMMDBWithOptions(t, filepath.Join(dir, "ipv4.mmdb"),
+		mmdbwriter.Options{IPVersion: 4},
+		map[string]mmdbtype.Map{
+			"1.0.0.0/25": {"org": mmdbtype.String("A")},
+			"3.0.0.0/8":  {"org": mmdbtype.String("B")},
+		},
+	)
+
+	tests := []struct {
+		name     string
+		opts     mmdbwriter.Options
+		subtrees string
+		networks map[string]mmdbtype.Map
+		want     []mockRow
+	}{
+		{
+			name:     "IPv4 subtree",
+			subtrees: config.IPv4OnlySubtreesBoth,
+			networks: map[string]mmdbtype.Map{
+				"1.0.0.0/24": {"country": mmdbtype.String("AU")},
+				"2a02::/16":  {"country": mmdbtype.String("DE")},
+			},
+			want: []mockRow{
+				{
+					prefix: netip.MustParsePrefix("1.0.0.0/25"),
+					data:   []mmdbtype.DataType{mmdbtype.String("A"), mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("1.0.0.128/25"),
+					data:   []mmdbtype.DataType{nil, mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("3.0.0.0/8"),
+					data:   []mmdbtype.DataType{mmdbtype.String("B"), nil},
+				},
+				{
+					prefix: netip.MustParsePrefix("2a02::/16"),
+					data:   []mmdbtype.DataType{nil, mmdbtype.String("DE")},
+				},
+			},
+		},
+		{
+			name: "IPv4-mapped subtree",
+			opts: mmdbwriter.Options{DisableIPv4Aliasing: true},
+			networks: map[string]mmdbtype.Map{
+				"::ffff:1.0.0.0/120": {"country": mmdbtype.String("AU")},
+			},
+			want: []mockRow{
+				{
+					prefix: netip.MustParsePrefix("1.0.0.0/25"),
+					data:   []mmdbtype.DataType{mmdbtype.String("A"), nil},
+				},
+				{
+					prefix: netip.MustParsePrefix("3.0.0.0/8"),
+					data:   []mmdbtype.DataType{mmdbtype.String("B"), nil},
+				},
+				{
+					prefix: netip.MustParsePrefix("::ffff:1.0.0.0/121"),
+					data:   []mmdbtype.DataType{mmdbtype.String("A"), mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("::ffff:1.0.0.128/121"),
+					data:   []mmdbtype.DataType{nil, mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("::ffff:3.0.0.0/104"),
+					data:   []mmdbtype.DataType{mmdbtype.String("B"), nil},
+				},
+			},
+		},
+		{
+			name:     "IPv4 subtree not mapped",
+			subtrees: config.IPv4OnlySubtreesMapped,
+			networks: map[string]mmdbtype.Map{
+				"1.0.0.0/24": {"country": mmdbtype.String("AU")},
+				"2a02::/16":  {"country": mmdbtype.String("DE")},
+			},
+			want: []mockRow{
+				{
+					prefix: netip.MustParsePrefix("1.0.0.0/24"),
+					data:   []mmdbtype.DataType{nil, mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("2a02::/16"),
+					data:   []mmdbtype.DataType{nil, mmdbtype.String("DE")},
+				},
+			},
+		},
+		{
+			name:     "IPv4-mapped subtree only",
+			opts:     mmdbwriter.Options{DisableIPv4Aliasing: true},
+			subtrees: config.IPv4OnlySubtreesMapped,
+			networks: map[string]mmdbtype.Map{
+				"::ffff:1.0.0.0/120": {"country": mmdbtype.String("AU")},
+			},
+			want: []mockRow{
+				{
+					prefix: netip.MustParsePrefix("::ffff:1.0.0.0/121"),
+					data:   []mmdbtype.DataType{mmdbtype.String("A"), mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("::ffff:1.0.0.128/121"),
+					data:   []mmdbtype.DataType{nil, mmdbtype.String("AU")},
+				},
+				{
+					prefix: netip.MustParsePrefix("::ffff:3.0.0.0/104"),
+					data:   []mmdbtype.DataType{mmdbtype.String("B"), nil},
+				},
+			},
+		},
 	}
 
-	readers, err := mmdb.OpenDatabases(databases)
-	require.NoError(t, err)
-	defer readers.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipv6Path := writeTestMMDBWithOptions(
+				t, filepath.Join(t.TempDir(), "ipv6.mmdb"), tt.opts, tt.networks,
+			)
+			readers, err := mmdb.OpenDatabases(map[string]string{
+				"ipv4": ipv4Path,
+				"ipv6": ipv6Path,
+			})
+			require.NoError(t, err)
+			defer readers.Close()
+
+			// The IPv4-only database comes first in config order but the
+			// merge must still cover the IPv6 space.
+			cfg := &config.Config{
+				IPv4OnlySubtrees: tt.subtrees,
+				Columns: []config.Column{
+					{Name: "org", Database: "ipv4", Path: config.Path{"org"}},
+					{Name: "country", Database: "ipv6", Path: config.Path{"country"}},
+				},
+			}
 
-	cfg := &config.Config{
-		Columns: []config.Column{
-			{Name: "v4", Database: "ipv4", Path: config.Path{"value"}},
-			{Name: "v6", Database: "ipv6", Path: config.Path{"value"}},
+			writer := &mockWriter{}
+			merger, err := NewMerger(readers, cfg, writer)
+			require.NoError(t, err)
+			require.NoError(t, merger.Merge())
+
+			assert.Equal(t, tt.want, writer.rows)
+		})
+	}
+}
+
+func TestMerger_IPVersionRestriction(t *testing.T) {
+	dir := t.TempDir()
+	ipv6Path := writeTestMMDB(t, filepath.Join(dir, "ipv6.mmdb"), map[string]mmdbtype.Map{
+		"1.0.0.0/24": {"country": mmdbtype.String("AU")},
+		"2a02::/16":  {"country": mmdbtype.String("DE")},
+	})
+	ipv4Path := writeTestMMDBWithOptions(t, filepath.Join(dir, "ipv4.mmdb"),
+		mmdbwriter.Options{IPVersion: 4},
+		map[string]mmdbtype.Map{"1.0.0.0/24": {"country": mmdbtype.String("AU")}},
+	)
+	ipv4Row := mockRow{
+		prefix: netip.MustParsePrefix("1.0.0.0/24"),
+		data:   []mmdbtype.DataType{mmdbtype.String("AU")},
+	}
+	ipv6Row := mockRow{
+		prefix: netip.MustParsePrefix("2a02::/16"),
+		data:   []mmdbtype.DataType{mmdbtype.String("DE")},
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		output  config.OutputConfig
+		want    []mockRow
+		wantErr string
+	}{
+		{
+			name:   "both IP versions",
+			path:   ipv6Path,
+			output: config.OutputConfig{Format: "csv"},
+			want:   []mockRow{ipv4Row, ipv6Row},
+		},
+		{
+			name:   "IPv4 only",
+			path:   ipv6Path,
+			output: config.OutputConfig{Format: "csv", IPVersion: 4},
+			want:   []mockRow{ipv4Row},
+		},
+		{
+			name:   "IPv6 only",
+			path:   ipv6Path,
+			output: config.OutputConfig{Format: "parquet", IPVersion: 6},
+			want:   []mockRow{ipv6Row},
+		},
+		{
+			name: "IPv4 MMDB",
+			path: ipv6Path,
+			output: config.OutputConfig{
+				Format: "mmdb",
+				MMDB:   config.MMDBConfig{IPVersion: 4},
+			},
+			want: []mockRow{ipv4Row},
+		},
+		{
+			name: "IPv6 MMDB",
+			path: ipv6Path,
+			output: config.OutputConfig{
+				Format: "mmdb",
+				MMDB:   config.MMDBConfig{IPVersion: 6},
+			},
+			want: []mockRow{ipv4Row, ipv6Row},
+		},
+		{
+			name:    "IPv6 only from IPv4 database",
+			path:    ipv4Path,
+			output:  config.OutputConfig{Format: "csv", IPVersion: 6},
+			wantErr: "all databases are IPv4-only",
 		},
 	}
 
-	_, err = NewMerger(readers, cfg, &mockWriter{})
-	require.Error(t, err)
-	assert.Contains(t, err.Error(), "mix IPv4-only")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readers, err := mmdb.OpenDatabases(map[string]string{"geo": tt.path})
+			require.NoError(t, err)
+			defer readers.Close()
+
+			cfg := &config.Config{
+				Output: tt.output,
+				Columns: []config.Column{
+					{Name: "country", Database: "geo", Path: config.Path{"country"}},
+				},
+			}
+
+			writer := &mockWriter{}
+			merger, err := NewMerger(readers, cfg, writer)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, merger.Merge())
+
+			assert.Equal(t, tt.want, writer.rows)
+		})
+	}
 }
 
 func TestMerger_NoColumns(t *testing.T) {
@@ -694,6 +917,84 @@ func TestWalkPathReturnsErrorOnTypeMismatch(t *testing.T) {
 	assert.Contains(t, err.Error(), "leaf")
 }
 
+func TestWalkPathWildcard(t *testing.T) {
+	root := mmdbtype.Map{
+		"subdivisions": mmdbtype.Slice{
+			mmdbtype.Map{
+				"iso_code": mmdbtype.String("ENG"),
+				"names":    mmdbtype.Map{"en": mmdbtype.String("England")},
+			},
+			mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Greater London")}},
+			mmdbtype.Map{
+				"iso_code": mmdbtype.String("LND"),
+				"names":    mmdbtype.Map{"de": mmdbtype.String("London")},
+			},
+			mmdbtype.String("not a map"),
+		},
+		"names": mmdbtype.Map{
+			"fr": mmdbtype.String("Royaume-Uni"),
+			"de": mmdbtype.String("Vereinigtes Königreich"),
+			"en": mmdbtype.String("United Kingdom"),
+		},
+		"leaf": mmdbtype.String("value"),
+	}
+
+	tests := []struct {
+		name     string
+		path     []any
+		expected mmdbtype.DataType
+	}{
+		{
+			name: "slice elements",
+			path: []any{"subdivisions", "*", "iso_code"},
+			expected: mmdbtype.Slice{
+				mmdbtype.String("ENG"),
+				mmdbtype.String("LND"),
+			},
+		},
+		{
+			name: "map values in key order",
+			path: []any{"names", "*"},
+			expected: mmdbtype.Slice{
+				mmdbtype.String("Vereinigtes Königreich"),
+				mmdbtype.String("United Kingdom"),
+				mmdbtype.String("Royaume-Uni"),
+			},
+		},
+		{
+			name: "nested wildcards are flattened",
+			path: []any{"subdivisions", "*", "names", "*"},
+			expected: mmdbtype.Slice{
+				mmdbtype.String("England"),
+				mmdbtype.String("Greater London"),
+				mmdbtype.String("London"),
+			},
+		},
+		{
+			name:     "no matches",
+			path:     []any{"subdivisions", "*", "geoname_id"},
+			expected: nil,
+		},
+		{
+			name:     "missing parent",
+			path:     []any{"city", "*"},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := walkPath(root, tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+
+	_, err := walkPath(root, []any{"leaf", "*"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected map or slice")
+}
+
 func TestDecodeOnceMatchesDecodePath(t *testing.T) {
 	reader, err := mmdb.Open(testDataDir + "/GeoIP2-Enterprise-Test.mmdb")
 	require.NoError(t, err)
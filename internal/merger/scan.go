@@ -0,0 +1,81 @@
+package merger
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// ScanColumnValues calls observe with every value the given database columns
+// can take, extracted from each distinct record of their databases as Merge
+// would extract it. This lets writers infer the types of nested columns from
+// the whole input before any row is merged. Values may be observed for
+// networks that Merge later drops, such as ones excluded by filters, and
+// must not be modified. Computed columns have no records to scan and are
+// ignored.
+func (m *Merger) ScanColumnValues(
+	columns []int,
+	observe func(col int, value mmdbtype.DataType) error,
+) error {
+	byDatabase := make([][]columnExtractor, len(m.readersList))
+	for _, extractor := range m.extractors {
+		if slices.Contains(columns, extractor.colIndex) {
+			byDatabase[extractor.dbIndex] = append(byDatabase[extractor.dbIndex], extractor)
+		}
+	}
+
+	for i, extractors := range byDatabase {
+		if len(extractors) == 0 {
+			continue
+		}
+		if err := m.scanDatabase(i, extractors, observe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanDatabase implements ScanColumnValues for the columns of one database.
+// Records shared by several networks are decoded once.
+func (m *Merger) scanDatabase(
+	dbIndex int,
+	extractors []columnExtractor,
+	observe func(col int, value mmdbtype.DataType) error,
+) error {
+	// A cacheless unmarshaler, since each record is decoded only once.
+	unmarshaler := &mmdbtype.Unmarshaler{}
+	seen := make(map[uintptr]struct{})
+	for result := range m.readersList[dbIndex].Networks() {
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("scanning database %s: %w", m.dbNamesList[dbIndex], err)
+		}
+		if _, ok := seen[result.Offset()]; ok {
+			continue
+		}
+		seen[result.Offset()] = struct{}{}
+
+		if err := result.Decode(unmarshaler); err != nil {
+			return fmt.Errorf("decoding database %s: %w", m.dbNamesList[dbIndex], err)
+		}
+		record, ok := unmarshaler.Result().(mmdbtype.Map)
+		unmarshaler.Clear()
+		if !ok {
+			continue
+		}
+
+		for _, extractor := range extractors {
+			value, err := m.extract(extractor, record)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+			if err := observe(extractor.colIndex, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package merger
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/mmdb"
+	"github.com/maxmind/mmdbconvert/internal/writer"
+)
+
+func TestMerger_ScanColumnValues(t *testing.T) {
+	dir := t.TempDir()
+	shared := mmdbtype.Map{
+		"code":  mmdbtype.String("fr"),
+		"names": mmdbtype.Map{"en": mmdbtype.String("France"), "de": mmdbtype.String("Frankreich")},
+	}
+	geoPath := writeTestMMDB(t, filepath.Join(dir, "geo.mmdb"), map[string]mmdbtype.Map{
+		"1.0.0.0/24": shared,
+		"3.0.0.0/24": shared,
+		"2.0.0.0/24": {"code": mmdbtype.String("de")},
+	})
+
+	cfg := &config.Config{
+		Locales:   []string{"en"},
+		Databases: []config.Database{{Name: "geo", Path: geoPath}},
+		Columns: []config.Column{
+			{Name: "code", Database: "geo", Path: config.Path{"code"}, Transforms: []config.Transform{
+				{Op: "upper"},
+			}},
+			{Name: "names", Database: "geo", Path: config.Path{"names"}},
+			{Name: "unscanned", Database: "geo", Path: config.Path{"code"}},
+		},
+	}
+
+	readers, err := mmdb.OpenDatabases(map[string]string{"geo": geoPath})
+	require.NoError(t, err)
+	defer readers.Close()
+
+	m, err := NewMerger(readers, cfg, &mockWriter{})
+	require.NoError(t, err)
+
+	observed := map[int][]mmdbtype.DataType{}
+	err = m.ScanColumnValues([]int{0, 1}, func(col int, value mmdbtype.DataType) error {
+		observed[col] = append(observed[col], value)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The record shared by two networks is scanned once, and values are
+	// extracted with locales and transforms applied.
+	assert.ElementsMatch(t, []mmdbtype.DataType{
+		mmdbtype.String("FR"),
+		mmdbtype.String("DE"),
+	}, observed[0])
+	assert.Equal(t, []mmdbtype.DataType{
+		mmdbtype.Map{"en": mmdbtype.String("France")},
+	}, observed[1])
+	assert.NotContains(t, observed, 2)
+}
+
+func TestMerger_NestedParquetTypesFromAllRecords(t *testing.T) {
+	dir := t.TempDir()
+	geoPath := writeTestMMDB(t, filepath.Join(dir, "geo.mmdb"), map[string]mmdbtype.Map{
+		"1.0.0.0/24": {"code": mmdbtype.String("FR")},
+		"2.0.0.0/24": {"code": mmdbtype.String("DE"), "is_eu": mmdbtype.Bool(true)},
+	})
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{Compression: "none", SchemaSampleRows: 1},
+		},
+		Databases: []config.Database{{Name: "geo", Path: geoPath}},
+		Columns: []config.Column{
+			{Name: "record", Database: "geo", Path: config.Path{}, Type: "struct"},
+		},
+	}
+
+	readers, err := mmdb.OpenDatabases(map[string]string{"geo": geoPath})
+	require.NoError(t, err)
+	defer readers.Close()
+
+	buf := &bytes.Buffer{}
+	parquetWriter, err := writer.NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+	m, err := NewMerger(readers, cfg, parquetWriter)
+	require.NoError(t, err)
+
+	nestedTypes, err := writer.InferNestedTypes(cfg, m.ScanColumnValues)
+	require.NoError(t, err)
+	require.NoError(t, parquetWriter.SetNestedTypes(nestedTypes))
+	require.NoError(t, m.Merge())
+	require.NoError(t, parquetWriter.Flush())
+
+	// is_eu only appears after the first sampled row, but is in the schema.
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	record, ok := file.Schema().Lookup("record", "is_eu")
+	require.True(t, ok)
+	assert.Equal(t, parquet.Boolean, record.Node.Type().Kind())
+	assert.Equal(t, int64(2), file.NumRows())
+}
@@ -0,0 +1,194 @@
+package merger
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/expr"
+)
+
+// transformFunc converts a single non-nil column value.
+type transformFunc func(mmdbtype.DataType) (mmdbtype.DataType, error)
+
+// transformStep is a compiled transform with its op name for error messages.
+type transformStep struct {
+	op string
+	fn transformFunc
+}
+
+// transformPipeline applies a column's transforms in order.
+type transformPipeline []transformStep
+
+// compileTransforms builds the transform pipeline for a column.
+func compileTransforms(transforms []config.Transform) (transformPipeline, error) {
+	pipeline := make(transformPipeline, 0, len(transforms))
+	for _, t := range transforms {
+		fn, err := compileTransform(t)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, transformStep{op: t.Op, fn: fn})
+	}
+	return pipeline, nil
+}
+
+// compileTransform builds the function for a single transform. The transform
+// options were checked by config.validateTransforms when the config was
+// loaded.
+func compileTransform(t config.Transform) (transformFunc, error) {
+	switch t.Op {
+	case "lower":
+		return elementwise(stringTransform(strings.ToLower)), nil
+
+	case "upper":
+		return elementwise(stringTransform(strings.ToUpper)), nil
+
+	case "trim":
+		return elementwise(stringTransform(strings.TrimSpace)), nil
+
+	case "truncate":
+		return elementwise(stringTransform(func(s string) string {
+			return truncateRunes(s, t.Length)
+		})), nil
+
+	case "round":
+		return elementwise(roundTransform(*t.Digits)), nil
+
+	case "bool_map":
+		trueValue, falseValue := mmdbtype.String(*t.True), mmdbtype.String(*t.False)
+		return elementwise(func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+			b, ok := value.(mmdbtype.Bool)
+			if !ok {
+				return nil, fmt.Errorf("expected bool, got %T", value)
+			}
+			if b {
+				return trueValue, nil
+			}
+			return falseValue, nil
+		}), nil
+
+	case "join":
+		separator := ","
+		if t.Separator != nil {
+			separator = *t.Separator
+		}
+		return joinTransform(separator), nil
+
+	default:
+		return nil, fmt.Errorf("unknown transform op '%s'", t.Op)
+	}
+}
+
+// apply runs value through the pipeline. Missing (nil) values are passed
+// through untouched, as is the result of any step that produces nil.
+func (p transformPipeline) apply(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+	for _, step := range p {
+		if value == nil {
+			return nil, nil
+		}
+		var err error
+		value, err = step.fn(value)
+		if err != nil {
+			return nil, fmt.Errorf("transform '%s': %w", step.op, err)
+		}
+	}
+	return value, nil
+}
+
+// elementwise applies fn to a scalar value, or to each element of a Slice.
+// The input is never modified; a new Slice is returned instead.
+func elementwise(fn transformFunc) transformFunc {
+	return func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		s, ok := value.(mmdbtype.Slice)
+		if !ok {
+			return fn(value)
+		}
+		out := make(mmdbtype.Slice, len(s))
+		for i, elem := range s {
+			if elem == nil {
+				continue
+			}
+			converted, err := fn(elem)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			out[i] = converted
+		}
+		return out, nil
+	}
+}
+
+func stringTransform(fn func(string) string) transformFunc {
+	return func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		s, ok := value.(mmdbtype.String)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %T", value)
+		}
+		return mmdbtype.String(fn(string(s))), nil
+	}
+}
+
+// truncateRunes shortens s to at most n characters without splitting a
+// multi-byte character.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
+
+// roundTransform rounds floating-point values to the given number of decimal
+// places, keeping their type. Integers are already exact and pass through.
+func roundTransform(digits int) transformFunc {
+	scale := math.Pow10(digits)
+	round := func(f float64) float64 {
+		return math.Round(f*scale) / scale
+	}
+	return func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		switch v := value.(type) {
+		case mmdbtype.Float64:
+			return mmdbtype.Float64(round(float64(v))), nil
+		case mmdbtype.Float32:
+			return mmdbtype.Float32(round(float64(v))), nil
+		case mmdbtype.Int32, mmdbtype.Uint16, mmdbtype.Uint32, mmdbtype.Uint64, *mmdbtype.Uint128:
+			return value, nil
+		default:
+			return nil, fmt.Errorf("expected number, got %T", value)
+		}
+	}
+}
+
+// joinTransform joins the elements of a Slice into a single string, skipping
+// missing elements. Elements must be scalars.
+func joinTransform(separator string) transformFunc {
+	return func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		s, ok := value.(mmdbtype.Slice)
+		if !ok {
+			return nil, fmt.Errorf("expected list, got %T", value)
+		}
+		parts := make([]string, 0, len(s))
+		for i, elem := range s {
+			if elem == nil {
+				continue
+			}
+			part, err := expr.FormatScalar(elem)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			parts = append(parts, part)
+		}
+		return mmdbtype.String(strings.Join(parts, separator)), nil
+	}
+}
@@ -0,0 +1,201 @@
+package merger
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestTransformPipeline_Apply(t *testing.T) {
+	tests := []struct {
+		name       string
+		transforms []config.Transform
+		input      mmdbtype.DataType
+		expected   mmdbtype.DataType
+	}{
+		{
+			name:       "lower",
+			transforms: []config.Transform{{Op: "lower"}},
+			input:      mmdbtype.String("US"),
+			expected:   mmdbtype.String("us"),
+		},
+		{
+			name:       "upper",
+			transforms: []config.Transform{{Op: "upper"}},
+			input:      mmdbtype.String("eu"),
+			expected:   mmdbtype.String("EU"),
+		},
+		{
+			name:       "trim",
+			transforms: []config.Transform{{Op: "trim"}},
+			input:      mmdbtype.String("  Berlin \t"),
+			expected:   mmdbtype.String("Berlin"),
+		},
+		{
+			name:       "truncate respects multi-byte characters",
+			transforms: []config.Transform{{Op: "truncate", Length: 3}},
+			input:      mmdbtype.String("Zürich"),
+			expected:   mmdbtype.String("Zür"),
+		},
+		{
+			name:       "truncate shorter string unchanged",
+			transforms: []config.Transform{{Op: "truncate", Length: 10}},
+			input:      mmdbtype.String("Paris"),
+			expected:   mmdbtype.String("Paris"),
+		},
+		{
+			name:       "round float64",
+			transforms: []config.Transform{{Op: "round", Digits: intPtr(2)}},
+			input:      mmdbtype.Float64(51.50735),
+			expected:   mmdbtype.Float64(51.51),
+		},
+		{
+			name:       "round to integer",
+			transforms: []config.Transform{{Op: "round", Digits: intPtr(0)}},
+			input:      mmdbtype.Float64(-0.6),
+			expected:   mmdbtype.Float64(-1),
+		},
+		{
+			name:       "round leaves integers unchanged",
+			transforms: []config.Transform{{Op: "round", Digits: intPtr(1)}},
+			input:      mmdbtype.Uint16(100),
+			expected:   mmdbtype.Uint16(100),
+		},
+		{
+			name: "bool_map",
+			transforms: []config.Transform{
+				{Op: "bool_map", True: strPtr("yes"), False: strPtr("no")},
+			},
+			input:    mmdbtype.Bool(false),
+			expected: mmdbtype.String("no"),
+		},
+		{
+			name:       "join with default separator",
+			transforms: []config.Transform{{Op: "join"}},
+			input:      mmdbtype.Slice{mmdbtype.String("a"), nil, mmdbtype.Uint32(2)},
+			expected:   mmdbtype.String("a,2"),
+		},
+		{
+			name: "elementwise lower then join",
+			transforms: []config.Transform{
+				{Op: "lower"},
+				{Op: "join", Separator: strPtr("|")},
+			},
+			input:    mmdbtype.Slice{mmdbtype.String("ENG"), mmdbtype.String("SCT")},
+			expected: mmdbtype.String("eng|sct"),
+		},
+		{
+			name:       "join uint128 element",
+			transforms: []config.Transform{{Op: "join"}},
+			input:      mmdbtype.Slice{(*mmdbtype.Uint128)(big.NewInt(7))},
+			expected:   mmdbtype.String("7"),
+		},
+		{
+			name:       "nil passes through",
+			transforms: []config.Transform{{Op: "lower"}, {Op: "join"}},
+			input:      nil,
+			expected:   nil,
+		},
+		{
+			name:       "no transforms",
+			transforms: nil,
+			input:      mmdbtype.String("US"),
+			expected:   mmdbtype.String("US"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := compileTransforms(tt.transforms)
+			require.NoError(t, err)
+
+			result, err := pipeline.apply(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestTransformPipeline_ApplyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		transforms  []config.Transform
+		input       mmdbtype.DataType
+		expectError string
+	}{
+		{
+			name:        "lower on number",
+			transforms:  []config.Transform{{Op: "lower"}},
+			input:       mmdbtype.Uint32(1),
+			expectError: "transform 'lower': expected string, got mmdbtype.Uint32",
+		},
+		{
+			name:        "round on string",
+			transforms:  []config.Transform{{Op: "round", Digits: intPtr(2)}},
+			input:       mmdbtype.String("1.5"),
+			expectError: "transform 'round': expected number, got mmdbtype.String",
+		},
+		{
+			name: "bool_map on string",
+			transforms: []config.Transform{
+				{Op: "bool_map", True: strPtr("yes"), False: strPtr("no")},
+			},
+			input:       mmdbtype.String("true"),
+			expectError: "transform 'bool_map': expected bool, got mmdbtype.String",
+		},
+		{
+			name:        "join on scalar",
+			transforms:  []config.Transform{{Op: "join"}},
+			input:       mmdbtype.String("a"),
+			expectError: "transform 'join': expected list, got mmdbtype.String",
+		},
+		{
+			name:        "join nested map",
+			transforms:  []config.Transform{{Op: "join"}},
+			input:       mmdbtype.Slice{mmdbtype.Map{}},
+			expectError: "transform 'join': element 0: cannot convert map to string",
+		},
+		{
+			name:        "elementwise error names element",
+			transforms:  []config.Transform{{Op: "upper"}},
+			input:       mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.Bool(true)},
+			expectError: "transform 'upper': element 1: expected string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := compileTransforms(tt.transforms)
+			require.NoError(t, err)
+
+			_, err = pipeline.apply(tt.input)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
+
+func TestTransformPipeline_DoesNotMutateInput(t *testing.T) {
+	pipeline, err := compileTransforms([]config.Transform{{Op: "lower"}})
+	require.NoError(t, err)
+
+	input := mmdbtype.Slice{mmdbtype.String("US")}
+	result, err := pipeline.apply(input)
+	require.NoError(t, err)
+
+	assert.Equal(t, mmdbtype.Slice{mmdbtype.String("us")}, result)
+	assert.Equal(t, mmdbtype.Slice{mmdbtype.String("US")}, input)
+}
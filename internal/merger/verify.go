@@ -0,0 +1,161 @@
+package merger
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+
+	"github.com/maxmind/mmdbconvert/internal/mmdb"
+)
+
+// maxReportedMismatches limits the mismatches listed in a verification error.
+const maxReportedMismatches = 10
+
+// RecordBuilder builds the MMDB record written for a row, as
+// writer.MMDBWriter does.
+type RecordBuilder interface {
+	Record(data []mmdbtype.DataType) (mmdbtype.Map, error)
+}
+
+// VerifyMMDB reopens the MMDB file written from the merge at path and checks
+// that its networks hold what the merge produces for them. For each checked
+// network, the merge is run again within the network and must produce ranges
+// covering the whole network that builder turns into the record found in
+// the file. Since the expected records come from the builder that wrote the
+// file, bugs in the builder itself are not detected. sampleRate is the fraction of the networks
+// checked, spread evenly over the file; 1 checks all of them.
+//
+// Only networks with data in the file are checked, so networks the merge
+// produces data for but that are missing from the file, such as reserved
+// networks, are not reported. VerifyMMDB must not be called during Merge.
+func (m *Merger) VerifyMMDB(path string, builder RecordBuilder, sampleRate float64) error {
+	output, err := mmdb.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening written database: %w", err)
+	}
+	defer output.Close()
+
+	v := &verifier{builder: builder}
+	acc := m.acc
+	m.acc = NewAccumulator(v, m.includeEmptyRows, m.slicePool)
+	defer func() { m.acc = acc }()
+
+	unmarshaler := mmdbtype.NewUnmarshaler()
+	var index int
+	for result := range output.Networks() {
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("iterating written database: %w", err)
+		}
+		index++
+		if int(float64(index)*sampleRate) == int(float64(index-1)*sampleRate) {
+			continue
+		}
+
+		prefix := result.Prefix()
+		if err := result.Decode(unmarshaler); err != nil {
+			return fmt.Errorf("decoding %s in written database: %w", prefix, err)
+		}
+		record := unmarshaler.Result()
+		unmarshaler.Clear()
+
+		v.ranges = v.ranges[:0]
+		if err := m.processNetwork(prefix, 0); err != nil {
+			return err
+		}
+		if err := m.acc.Flush(); err != nil {
+			return fmt.Errorf("flushing accumulator: %w", err)
+		}
+		v.check(prefix, record)
+	}
+
+	if len(v.mismatches) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "written database differs from the merge in %d networks:", len(v.mismatches))
+	for _, mismatch := range v.mismatches[:min(len(v.mismatches), maxReportedMismatches)] {
+		b.WriteString("\n  ")
+		b.WriteString(mismatch)
+	}
+	if len(v.mismatches) > maxReportedMismatches {
+		fmt.Fprintf(&b, "\n  ... and %d more", len(v.mismatches)-maxReportedMismatches)
+	}
+	return errors.New(b.String())
+}
+
+// verifiedRange is a range the merge produced with the record built for it.
+type verifiedRange struct {
+	start, end netip.Addr
+	record     mmdbtype.Map
+}
+
+// verifier is the row writer of the merge while verifying. It collects the
+// ranges produced for the network being checked.
+type verifier struct {
+	builder    RecordBuilder
+	ranges     []verifiedRange
+	mismatches []string
+}
+
+// WriteRow implements RowWriter.
+func (v *verifier) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType) error {
+	return v.WriteRange(prefix.Addr(), netipx.PrefixLastIP(prefix), data)
+}
+
+// WriteRange implements RangeRowWriter.
+func (v *verifier) WriteRange(start, end netip.Addr, data []mmdbtype.DataType) error {
+	record, err := v.builder.Record(data)
+	if err != nil {
+		return err
+	}
+	v.ranges = append(v.ranges, verifiedRange{start: start, end: end, record: record})
+	return nil
+}
+
+// check compares the ranges collected for prefix with the record found for
+// it in the written database and records any mismatch. The merge may produce
+// several ranges for a network of the file, since rows that differ only in
+// data left out of the record, e.g. by exclude_keys, build equal records that
+// the MMDB writer merges. They must then cover the whole network and all
+// build the record found in the file.
+func (v *verifier) check(prefix netip.Prefix, got mmdbtype.DataType) {
+	if len(v.ranges) == 0 {
+		v.mismatches = append(v.mismatches, fmt.Sprintf(
+			"%s: expected no data, got %v", prefix, got,
+		))
+		return
+	}
+
+	covered := v.ranges[0].start == prefix.Addr() &&
+		v.ranges[len(v.ranges)-1].end == netipx.PrefixLastIP(prefix)
+	same := true
+	for i, r := range v.ranges[1:] {
+		if r.start != v.ranges[i].end.Next() {
+			covered = false
+		}
+		if !r.record.Equal(v.ranges[0].record) {
+			same = false
+		}
+	}
+
+	switch {
+	case !covered || !same:
+		ranges := make([]string, len(v.ranges))
+		for i, r := range v.ranges {
+			ranges[i] = r.start.String() + "-" + r.end.String()
+		}
+		v.mismatches = append(v.mismatches, fmt.Sprintf(
+			"%s: expected separate ranges %s, got %v for the whole network",
+			prefix, strings.Join(ranges, ", "), got,
+		))
+	case got == nil || !v.ranges[0].record.Equal(got):
+		v.mismatches = append(v.mismatches, fmt.Sprintf(
+			"%s: expected %v, got %v", prefix, v.ranges[0].record, got,
+		))
+	}
+}
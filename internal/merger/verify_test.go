@@ -0,0 +1,168 @@
+package merger
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go4.org/netipx"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/mmdb"
+	"github.com/maxmind/mmdbconvert/internal/writer"
+)
+
+func TestMerger_VerifyMMDB(t *testing.T) {
+	dir := t.TempDir()
+	geoPath := writeTestMMDB(t, filepath.Join(dir, "geo.mmdb"), map[string]mmdbtype.Map{
+		"1.0.0.0/24": {"country": mmdbtype.String("AU")},
+		"1.0.1.0/24": {"country": mmdbtype.String("AU")},
+		"2.0.0.0/16": {"country": mmdbtype.String("FR")},
+		"2a02::/16":  {"country": mmdbtype.String("DE")},
+	})
+	asnPath := writeTestMMDB(t, filepath.Join(dir, "asn.mmdb"), map[string]mmdbtype.Map{
+		"2.0.128.0/17": {"asn": mmdbtype.Uint32(3215)},
+	})
+
+	recordSize := 28
+	includeReserved := false
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Format: "mmdb",
+			MMDB: config.MMDBConfig{
+				DatabaseType:            "Test",
+				RecordSize:              &recordSize,
+				IncludeReservedNetworks: &includeReserved,
+			},
+		},
+		Databases: []config.Database{
+			{Name: "geo", Path: geoPath},
+			{Name: "asn", Path: asnPath},
+		},
+		Columns: []config.Column{
+			{Name: "country", Database: "geo", Path: config.Path{"country"}},
+			{Name: "asn", Database: "asn", Path: config.Path{"asn"}, Type: "uint32"},
+		},
+	}
+
+	readers, err := mmdb.OpenDatabases(map[string]string{"geo": geoPath, "asn": asnPath})
+	require.NoError(t, err)
+	defer readers.Close()
+
+	outputPath := filepath.Join(dir, "output.mmdb")
+	mmdbWriter, err := writer.NewMMDBWriter(outputPath, cfg, 6, nil)
+	require.NoError(t, err)
+	m, err := NewMerger(readers, cfg, mmdbWriter)
+	require.NoError(t, err)
+	require.NoError(t, m.Merge())
+	require.NoError(t, mmdbWriter.Flush())
+
+	t.Run("matching output", func(t *testing.T) {
+		require.NoError(t, m.VerifyMMDB(outputPath, mmdbWriter, 1))
+	})
+
+	t.Run("mismatching output", func(t *testing.T) {
+		tamperedPath := writeTestMMDB(t, filepath.Join(dir, "tampered.mmdb"), map[string]mmdbtype.Map{
+			"1.0.0.0/23": {"country": mmdbtype.String("NZ")},
+			"2.0.0.0/16": {"country": mmdbtype.String("FR"), "asn": mmdbtype.Uint32(3215)},
+			"3.0.0.0/8":  {"country": mmdbtype.String("US")},
+			"2a02::/16":  {"country": mmdbtype.String("DE")},
+		})
+
+		err := m.VerifyMMDB(tamperedPath, mmdbWriter, 1)
+		require.Error(t, err)
+		assert.Equal(t,
+			"written database differs from the merge in 3 networks:\n"+
+				"  1.0.0.0/23: expected map[country:AU], got map[country:NZ]\n"+
+				"  2.0.0.0/16: expected separate ranges 2.0.0.0-2.0.127.255, "+
+				"2.0.128.0-2.0.255.255, got map[asn:3215 country:FR] for the whole network\n"+
+				"  3.0.0.0/8: expected no data, got map[country:US]",
+			err.Error(),
+		)
+	})
+
+	t.Run("ranges merged by the writer", func(t *testing.T) {
+		// The rows differ only in a key left out of the record, so the
+		// writer merges them into 1.0.0.0/23.
+		junkPath := writeTestMMDB(t, filepath.Join(dir, "junk.mmdb"), map[string]mmdbtype.Map{
+			"1.0.0.0/24": {"country": mmdbtype.String("AU"), "junk": mmdbtype.String("a")},
+			"1.0.1.0/24": {"country": mmdbtype.String("AU"), "junk": mmdbtype.String("b")},
+		})
+		junkCfg := &config.Config{
+			Output:    cfg.Output,
+			Databases: []config.Database{{Name: "geo", Path: junkPath}},
+			Columns: []config.Column{{
+				Name:        "record",
+				Database:    "geo",
+				Path:        config.Path{},
+				OutputPath:  &config.Path{},
+				ExcludeKeys: []config.Path{{"junk"}},
+			}},
+		}
+		junkReaders, err := mmdb.OpenDatabases(map[string]string{"geo": junkPath})
+		require.NoError(t, err)
+		defer junkReaders.Close()
+
+		junkOutput := filepath.Join(dir, "junk-output.mmdb")
+		junkWriter, err := writer.NewMMDBWriter(junkOutput, junkCfg, 6, nil)
+		require.NoError(t, err)
+		junkMerger, err := NewMerger(junkReaders, junkCfg, junkWriter)
+		require.NoError(t, err)
+		require.NoError(t, junkMerger.Merge())
+		require.NoError(t, junkWriter.Flush())
+
+		require.NoError(t, junkMerger.VerifyMMDB(junkOutput, junkWriter, 1))
+	})
+
+	t.Run("sampled networks", func(t *testing.T) {
+		networks := map[string]mmdbtype.Map{}
+		for i := range 8 {
+			prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{11, byte(i), 0, 0}), 16)
+			// Alternate the data so that the networks are not merged.
+			networks[prefix.String()] = mmdbtype.Map{"country": mmdbtype.String([]string{"US", "CA"}[i%2])}
+		}
+		tamperedPath := writeTestMMDB(t, filepath.Join(dir, "sampled.mmdb"), networks)
+
+		err := m.VerifyMMDB(tamperedPath, mmdbWriter, 0.25)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "differs from the merge in 2 networks")
+	})
+}
+
+// writeTestMMDB writes an IPv6 MMDB file with the given networks to path.
+func writeTestMMDB(t *testing.T, path string, networks map[string]mmdbtype.Map) string {
+	t.Helper()
+	return writeTestMMDBWithOptions(t, path, mmdbwriter.Options{}, networks)
+}
+
+// writeTestMMDBWithOptions writes an MMDB file with the given tree options
+// and networks to path.
+func writeTestMMDBWithOptions(
+	t *testing.T,
+	path string,
+	opts mmdbwriter.Options,
+	networks map[string]mmdbtype.Map,
+) string {
+	t.Helper()
+
+	opts.DatabaseType = "Test"
+	opts.RecordSize = 28
+	tree, err := mmdbwriter.New(opts)
+	require.NoError(t, err)
+	for network, record := range networks {
+		prefix := netip.MustParsePrefix(network)
+		require.NoError(t, tree.Insert(netipx.PrefixIPNet(prefix), record))
+	}
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = tree.WriteTo(f)
+	require.NoError(t, err)
+	return path
+}
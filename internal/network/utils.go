@@ -48,6 +48,21 @@ func IPv6BucketToInt64(addr netip.Addr) (int64, error) {
 	return int64(val >> 4), nil
 }
 
+// IPToInt64Halves splits an address into its upper and lower 64 bits as int64
+// values that sort in the same order as the address. IPv4 addresses are
+// converted to their IPv4-mapped IPv6 form (::ffff:a.b.c.d).
+//
+// Each half has its sign bit flipped, which is the same as subtracting 2^63
+// from the unsigned value, so that engines without unsigned 64-bit integers
+// can compare halves with plain integer predicates.
+func IPToInt64Halves(addr netip.Addr) (hi, lo int64) {
+	const signBit = 1 << 63
+	bytes := addr.As16()
+	hi = int64(binary.BigEndian.Uint64(bytes[:8]) ^ signBit)
+	lo = int64(binary.BigEndian.Uint64(bytes[8:]) ^ signBit)
+	return hi, lo
+}
+
 // IsAdjacent checks if two IP addresses are consecutive (no gap between them).
 func IsAdjacent(endIP, startIP netip.Addr) bool {
 	if endIP.Is4() != startIP.Is4() {
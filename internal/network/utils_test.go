@@ -2,6 +2,7 @@ package network
 
 import (
 	"fmt"
+	"math"
 	"net/netip"
 	"testing"
 
@@ -215,6 +216,42 @@ func TestIPv6BucketToInt64_IPv4Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "non-IPv6")
 }
 
+func TestIPToInt64Halves(t *testing.T) {
+	tests := []struct {
+		ip string
+		hi int64
+		lo int64
+	}{
+		{"::", math.MinInt64, math.MinInt64},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", math.MaxInt64, math.MaxInt64},
+		{"8000::", 0, math.MinInt64},
+		{"7fff:ffff:ffff:ffff::1", -1, math.MinInt64 + 1},
+		{"2001:db8::", 0x20010db800000000 - math.MaxInt64 - 1, math.MinInt64},
+		{"1.2.3.4", math.MinInt64, 0x0000ffff01020304 - math.MaxInt64 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			hi, lo := IPToInt64Halves(netip.MustParseAddr(tt.ip))
+			assert.Equal(t, tt.hi, hi)
+			assert.Equal(t, tt.lo, lo)
+		})
+	}
+}
+
+func TestIPToInt64Halves_Order(t *testing.T) {
+	addrs := []string{"::", "::1", "::ffff:0:1", "7fff::", "8000::", "8000::1", "ffff::"}
+	for i := 1; i < len(addrs); i++ {
+		prevHi, prevLo := IPToInt64Halves(netip.MustParseAddr(addrs[i-1]))
+		hi, lo := IPToInt64Halves(netip.MustParseAddr(addrs[i]))
+		assert.True(
+			t,
+			prevHi < hi || (prevHi == hi && prevLo < lo),
+			"%s should sort before %s", addrs[i-1], addrs[i],
+		)
+	}
+}
+
 func TestIsAdjacent(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -10,6 +10,7 @@ import (
 	"math/big"
 	"net/netip"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
@@ -30,6 +31,8 @@ type CSVWriter struct {
 	bigIntPool    *sync.Pool // Pool of big.Int for IPv6 integer conversion
 	rowBatch      [][]string // Batch buffer for rows
 	batchSize     int        // Number of rows to batch before writing
+	listColumns   []bool     // Whether each data column produces a list (see config.Column.IsList)
+	listSeparator string     // Separator for joining list column values
 }
 
 // NewCSVWriter creates a new CSV writer.
@@ -49,13 +52,25 @@ func NewCSVWriter(w io.Writer, cfg *config.Config) *CSVWriter {
 	rangeCapable := true
 	for _, col := range cfg.Network.Columns {
 		switch col.Type {
-		case NetworkColumnStartIP, NetworkColumnEndIP, NetworkColumnStartInt, NetworkColumnEndInt:
+		case NetworkColumnStartIP, NetworkColumnEndIP, NetworkColumnStartInt, NetworkColumnEndInt,
+			NetworkColumnStartIntHi, NetworkColumnStartIntLo,
+			NetworkColumnEndIntHi, NetworkColumnEndIntLo:
 			// supported
 		default:
 			rangeCapable = false
 		}
 	}
 
+	listSeparator := ","
+	if cfg.Output.CSV.ListSeparator != "" {
+		listSeparator = cfg.Output.CSV.ListSeparator
+	}
+
+	listColumns := make([]bool, len(cfg.Columns))
+	for i, col := range cfg.Columns {
+		listColumns[i] = col.IsList()
+	}
+
 	const defaultBatchSize = 1000
 	return &CSVWriter{
 		writer:        csvWriter,
@@ -69,8 +84,10 @@ func NewCSVWriter(w io.Writer, cfg *config.Config) *CSVWriter {
 				return new(big.Int)
 			},
 		},
-		rowBatch:  make([][]string, 0, defaultBatchSize),
-		batchSize: defaultBatchSize,
+		rowBatch:      make([][]string, 0, defaultBatchSize),
+		batchSize:     defaultBatchSize,
+		listColumns:   listColumns,
+		listSeparator: listSeparator,
 	}
 }
 
@@ -162,8 +179,7 @@ func (w *CSVWriter) writeSingleRow(
 	}
 
 	for i, col := range w.config.Columns {
-		value := data[i]
-		strValue, err := convertToString(value)
+		strValue, err := w.formatDataValue(i, data[i])
 		if err != nil {
 			return fmt.Errorf("converting column '%s' to string: %w", col.Name, err)
 		}
@@ -242,8 +258,7 @@ func (w *CSVWriter) WriteRange(start, end netip.Addr, data []mmdbtype.DataType)
 	}
 
 	for i, col := range w.config.Columns {
-		value := data[i] //nolint:gosec // G602: bounds checked above
-		strValue, err := convertToString(value)
+		strValue, err := w.formatDataValue(i, data[i]) //nolint:gosec // G602: bounds checked above
 		if err != nil {
 			return fmt.Errorf("converting column '%s' to string: %w", col.Name, err)
 		}
@@ -325,6 +340,12 @@ func (w *CSVWriter) generateNetworkColumnValue(
 		}
 		return w.formatIPv6AsInt(endIP), nil
 
+	case NetworkColumnStartIntHi, NetworkColumnStartIntLo:
+		return strconv.FormatInt(int64Half(addr, colType), 10), nil
+
+	case NetworkColumnEndIntHi, NetworkColumnEndIntLo:
+		return strconv.FormatInt(int64Half(netipx.PrefixLastIP(prefix), colType), 10), nil
+
 	case NetworkColumnBucket:
 		if !bucket.IsValid() {
 			return "", errors.New("invalid bucket but network_bucket column requested")
@@ -368,6 +389,10 @@ func (w *CSVWriter) generateRangeNetworkValue(
 			return strconv.FormatUint(uint64(network.IPv4ToUint32(end)), 10), nil
 		}
 		return w.formatIPv6AsInt(end), nil
+	case NetworkColumnStartIntHi, NetworkColumnStartIntLo:
+		return strconv.FormatInt(int64Half(start, colType), 10), nil
+	case NetworkColumnEndIntHi, NetworkColumnEndIntLo:
+		return strconv.FormatInt(int64Half(end, colType), 10), nil
 	default:
 		return "", fmt.Errorf("unsupported network column type '%s' for range output", colType)
 	}
@@ -390,6 +415,37 @@ func (w *CSVWriter) formatIPv6AsInt(addr netip.Addr) string {
 	return i.String()
 }
 
+// formatDataValue converts the value of data column i to its CSV string
+// representation. List columns with scalar elements are joined with the
+// configured list separator; other values are converted by convertToString.
+func (w *CSVWriter) formatDataValue(i int, value mmdbtype.DataType) (string, error) {
+	list, ok := value.(mmdbtype.Slice)
+	if !ok || !w.listColumns[i] || !isScalarSlice(list) {
+		return convertToString(value)
+	}
+
+	parts := make([]string, len(list))
+	for j, element := range list {
+		s, err := convertToString(element)
+		if err != nil {
+			return "", err
+		}
+		parts[j] = s
+	}
+	return strings.Join(parts, w.listSeparator), nil
+}
+
+// isScalarSlice reports whether s contains no maps or slices.
+func isScalarSlice(s mmdbtype.Slice) bool {
+	for _, element := range s {
+		switch element.(type) {
+		case mmdbtype.Map, mmdbtype.Slice:
+			return false
+		}
+	}
+	return true
+}
+
 // convertToString converts a value to its CSV string representation.
 // Handles mmdbtype.DataType values from the extractor.
 func convertToString(value any) (string, error) {
@@ -150,6 +150,22 @@ func TestCSVWriter_NetworkColumns(t *testing.T) {
 				"42540766411282592856903984951653826563",
 			},
 		},
+		{
+			name: "IPv6 integer halves",
+			columns: []config.NetworkColumn{
+				{Name: "start_int_hi", Type: "start_int_hi"},
+				{Name: "start_int_lo", Type: "start_int_lo"},
+				{Name: "end_int_hi", Type: "end_int_hi"},
+				{Name: "end_int_lo", Type: "end_int_lo"},
+			},
+			prefix: "2001:db8::/126",
+			expected: []string{
+				"-6917232468739227648",
+				"-9223372036854775808",
+				"-6917232468739227648",
+				"-9223372036854775805",
+			},
+		},
 		{
 			name: "all network column types",
 			columns: []config.NetworkColumn{
@@ -475,6 +491,45 @@ func TestCSVWriter_CSVEscaping(t *testing.T) {
 	assert.Contains(t, output, "\"hello, world\"")
 }
 
+func TestCSVWriter_ListColumns(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			CSV: config.CSVConfig{
+				Delimiter:     ",",
+				ListSeparator: "|",
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "subdivisions", Path: config.Path{"subdivisions", "*", "iso_code"}},
+			{Name: "records", Path: config.Path{"subdivisions", "*"}},
+			{Name: "raw", Path: config.Path{"values"}},
+		},
+	}
+
+	writer := NewCSVWriter(buf, cfg)
+
+	data := []mmdbtype.DataType{
+		mmdbtype.Slice{mmdbtype.String("ENG"), mmdbtype.String("LND")},
+		mmdbtype.Slice{mmdbtype.Map{"iso_code": mmdbtype.String("ENG")}},
+		mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+	}
+	require.NoError(t, writer.WriteRow(netip.MustParsePrefix("10.0.0.0/24"), data))
+	require.NoError(t, writer.Flush())
+
+	// Only wildcard columns with scalar elements are joined; other slices
+	// keep their JSON encoding.
+	expected := "network,subdivisions,records,raw\n" +
+		`10.0.0.0/24,ENG|LND,"[{""iso_code"":""ENG""}]","[""a"",""b""]"` + "\n"
+	assert.Equal(t, expected, buf.String())
+}
+
 func TestConvertToString(t *testing.T) {
 	tests := []struct {
 		name     string
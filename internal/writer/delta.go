@@ -0,0 +1,648 @@
+package writer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+const (
+	// DeltaLogDir is the directory of a Delta Lake table holding its
+	// transaction log.
+	DeltaLogDir = "_delta_log"
+
+	// Protocol versions of the tables written. Reader version 1 and writer
+	// version 2 need no table features.
+	deltaReaderVersion = 1
+	deltaWriterVersion = 2
+)
+
+// deltaCommitName matches the names of the JSON commit files in the log.
+var deltaCommitName = regexp.MustCompile(`^(\d{20})\.json$`)
+
+// DeltaTableWriter writes the output as a new version of a Delta Lake table.
+// The rows are written as a partitioned dataset (see PartitionedWriter) with
+// uniquely named files, and Flush commits a version that replaces the files
+// of the previous version, which stay on disk so that readers can time-travel
+// to earlier versions. The commit records the provenance, including the
+// build_epoch of each source database.
+type DeltaTableWriter struct {
+	dir        string
+	config     *config.Config
+	dataset    *PartitionedWriter
+	table      *deltaTable // Table state before this commit
+	provenance *Provenance
+}
+
+// deltaTable is the state of a table after replaying its log.
+type deltaTable struct {
+	version  int64 // Latest version, or -1 for a new table
+	metadata *deltaMetadata
+	files    []string // Paths of the files of the latest version
+}
+
+// deltaAction is a line of a commit file. Exactly one field is set.
+type deltaAction struct {
+	CommitInfo *deltaCommitInfo `json:"commitInfo,omitempty"`
+	Protocol   *deltaProtocol   `json:"protocol,omitempty"`
+	MetaData   *deltaMetadata   `json:"metaData,omitempty"`
+	Add        *deltaAdd        `json:"add,omitempty"`
+	Remove     *deltaRemove     `json:"remove,omitempty"`
+}
+
+type deltaCommitInfo struct {
+	Timestamp           int64             `json:"timestamp"`
+	Operation           string            `json:"operation"`
+	OperationParameters map[string]string `json:"operationParameters"`
+	IsBlindAppend       bool              `json:"isBlindAppend"`
+	EngineInfo          string            `json:"engineInfo"`
+	UserMetadata        string            `json:"userMetadata,omitempty"`
+	BuildEpochs         map[string]uint   `json:"mmdbconvert.buildEpochs,omitempty"`
+}
+
+type deltaProtocol struct {
+	MinReaderVersion int `json:"minReaderVersion"`
+	MinWriterVersion int `json:"minWriterVersion"`
+}
+
+type deltaMetadata struct {
+	ID               string            `json:"id"`
+	Format           deltaFormat       `json:"format"`
+	SchemaString     string            `json:"schemaString"`
+	PartitionColumns []string          `json:"partitionColumns"`
+	Configuration    map[string]string `json:"configuration"`
+	CreatedTime      int64             `json:"createdTime"`
+}
+
+type deltaFormat struct {
+	Provider string            `json:"provider"`
+	Options  map[string]string `json:"options"`
+}
+
+type deltaAdd struct {
+	Path             string             `json:"path"`
+	PartitionValues  map[string]*string `json:"partitionValues"`
+	Size             int64              `json:"size"`
+	ModificationTime int64              `json:"modificationTime"`
+	DataChange       bool               `json:"dataChange"`
+	Stats            string             `json:"stats,omitempty"`
+}
+
+type deltaRemove struct {
+	Path              string `json:"path"`
+	DeletionTimestamp int64  `json:"deletionTimestamp"`
+	DataChange        bool   `json:"dataChange"`
+}
+
+// NewDeltaTableWriter creates a writer committing a new version of the Delta
+// Lake table in dir. The directory is created if needed; if it exists, it
+// must be empty or hold a table whose log starts at version 0.
+func NewDeltaTableWriter(
+	dir string,
+	cfg *config.Config,
+	provenance *Provenance,
+) (*DeltaTableWriter, error) {
+	table, err := readDeltaTable(dir)
+	if err != nil {
+		return nil, err
+	}
+	if table.version < 0 {
+		if err := createDatasetDir(dir); err != nil {
+			return nil, fmt.Errorf("creating Delta table: %w", err)
+		}
+	}
+
+	// The files of every version live side by side, so their names must not
+	// collide with those of earlier versions.
+	dataset, err := newPartitionedWriter(dir, cfg, provenance, "-"+uuid.NewString())
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeltaTableWriter{
+		dir:        dir,
+		config:     cfg,
+		dataset:    dataset,
+		table:      table,
+		provenance: provenance,
+	}, nil
+}
+
+// readDeltaTable replays the log of the table in dir. A missing directory or
+// log is a new table.
+func readDeltaTable(dir string) (*deltaTable, error) {
+	table := &deltaTable{version: -1}
+
+	logDir := filepath.Join(dir, DeltaLogDir)
+	entries, err := os.ReadDir(logDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading Delta log %s: %w", logDir, err)
+	}
+
+	var commits []string
+	for _, entry := range entries {
+		if deltaCommitName.MatchString(entry.Name()) {
+			commits = append(commits, entry.Name())
+		}
+	}
+	slices.Sort(commits)
+
+	active := map[string]bool{}
+	for i, name := range commits {
+		version, err := strconv.ParseInt(deltaCommitName.FindStringSubmatch(name)[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Delta log file name %s: %w", name, err)
+		}
+		if version != int64(i) {
+			// Earlier commits were removed after a checkpoint, which we
+			// cannot read.
+			return nil, fmt.Errorf(
+				"delta log %s does not start at version 0 or has gaps; checkpoints are not supported",
+				logDir,
+			)
+		}
+		if err := table.replay(filepath.Join(logDir, name), active); err != nil {
+			return nil, err
+		}
+		table.version = version
+	}
+
+	for path := range active {
+		table.files = append(table.files, path)
+	}
+	slices.Sort(table.files)
+	return table, nil
+}
+
+// replay applies the actions of a commit file to the table, tracking the
+// files of the table in active.
+func (t *deltaTable) replay(path string, active map[string]bool) error {
+	// #nosec G304 -- the table path comes from trusted configuration
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var action deltaAction
+		err := decoder.Decode(&action)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		switch {
+		case action.Protocol != nil:
+			p := action.Protocol
+			if p.MinReaderVersion > deltaReaderVersion || p.MinWriterVersion > deltaWriterVersion {
+				return fmt.Errorf(
+					"delta table requires reader version %d and writer version %d, but only %d and %d are supported",
+					p.MinReaderVersion,
+					p.MinWriterVersion,
+					deltaReaderVersion,
+					deltaWriterVersion,
+				)
+			}
+		case action.MetaData != nil:
+			t.metadata = action.MetaData
+		case action.Add != nil:
+			active[action.Add.Path] = true
+		case action.Remove != nil:
+			delete(active, action.Remove.Path)
+		}
+	}
+}
+
+// SetWarnings sets the writer that receives warnings about values that are
+// not written as is (see ParquetWriter.SetWarnings).
+func (w *DeltaTableWriter) SetWarnings(warnings io.Writer) {
+	w.dataset.SetWarnings(warnings)
+}
+
+// SetNestedTypes sets the types of the nested columns inferred by
+// InferNestedTypes, so that all data files, and thus the table, share one
+// schema. It must be called before any row is written.
+func (w *DeltaTableWriter) SetNestedTypes(types *NestedTypes) error {
+	return w.dataset.SetNestedTypes(types)
+}
+
+// WriteRow writes the row to the data file of its partition.
+func (w *DeltaTableWriter) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType) error {
+	return w.dataset.WriteRow(prefix, data)
+}
+
+// Flush finishes the data files and commits them as the next version of the
+// table.
+func (w *DeltaTableWriter) Flush() error {
+	if err := w.dataset.finish(); err != nil {
+		return err
+	}
+
+	schema, err := w.schemaString()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	version := w.table.version + 1
+	partitionBy := w.config.Output.Parquet.PartitionBy
+	if partitionBy == nil {
+		partitionBy = []string{}
+	}
+
+	partitionByJSON, err := json.Marshal(partitionBy)
+	if err != nil {
+		return fmt.Errorf("encoding partition columns: %w", err)
+	}
+	commitInfo := &deltaCommitInfo{
+		Timestamp: now,
+		Operation: "WRITE",
+		OperationParameters: map[string]string{
+			"mode":        "Overwrite",
+			"partitionBy": string(partitionByJSON),
+		},
+		EngineInfo: "mmdbconvert",
+	}
+	if w.provenance != nil {
+		if w.provenance.Version != "" {
+			commitInfo.EngineInfo += "/" + w.provenance.Version
+		}
+		encoded, err := w.provenance.JSON()
+		if err != nil {
+			return err
+		}
+		commitInfo.UserMetadata = encoded
+		commitInfo.BuildEpochs = make(map[string]uint, len(w.provenance.Databases))
+		for _, db := range w.provenance.Databases {
+			commitInfo.BuildEpochs[db.Name] = db.BuildEpoch
+		}
+	}
+	actions := []deltaAction{{CommitInfo: commitInfo}}
+
+	if version == 0 {
+		actions = append(actions, deltaAction{Protocol: &deltaProtocol{
+			MinReaderVersion: deltaReaderVersion,
+			MinWriterVersion: deltaWriterVersion,
+		}})
+	}
+
+	metadata := &deltaMetadata{
+		ID:               uuid.NewString(),
+		Format:           deltaFormat{Provider: "parquet", Options: map[string]string{}},
+		SchemaString:     schema,
+		PartitionColumns: partitionBy,
+		Configuration:    map[string]string{},
+		CreatedTime:      now,
+	}
+	if previous := w.table.metadata; previous != nil {
+		metadata.ID = previous.ID
+		metadata.CreatedTime = previous.CreatedTime
+		if previous.Configuration != nil {
+			metadata.Configuration = previous.Configuration
+		}
+	}
+	if previous := w.table.metadata; previous == nil ||
+		previous.SchemaString != metadata.SchemaString ||
+		!slices.Equal(previous.PartitionColumns, metadata.PartitionColumns) {
+		actions = append(actions, deltaAction{MetaData: metadata})
+	}
+
+	for _, path := range w.table.files {
+		actions = append(actions, deltaAction{Remove: &deltaRemove{
+			Path:              path,
+			DeletionTimestamp: now,
+			DataChange:        true,
+		}})
+	}
+
+	for _, f := range w.dataset.files {
+		add, err := w.addAction(f)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, deltaAction{Add: add})
+	}
+
+	return w.commit(version, actions)
+}
+
+// addAction returns the action adding the data file f to the table.
+func (w *DeltaTableWriter) addAction(f datasetFile) (*deltaAdd, error) {
+	info, err := os.Stat(filepath.Join(w.dir, filepath.FromSlash(f.Path)))
+	if err != nil {
+		return nil, fmt.Errorf("reading data file: %w", err)
+	}
+
+	stats, err := json.Marshal(map[string]int64{"numRecords": f.Rows})
+	if err != nil {
+		return nil, fmt.Errorf("encoding file statistics: %w", err)
+	}
+
+	values := make(map[string]*string, len(w.dataset.keys))
+	for i, key := range w.dataset.keys {
+		if f.values[i] != "" {
+			values[key.name] = &f.values[i]
+		} else {
+			values[key.name] = nil
+		}
+	}
+
+	return &deltaAdd{
+		// Paths are relative URIs, so the Hive escapes in directory names
+		// are escaped again.
+		Path:             (&url.URL{Path: f.Path}).EscapedPath(),
+		PartitionValues:  values,
+		Size:             info.Size(),
+		ModificationTime: info.ModTime().UnixMilli(),
+		DataChange:       true,
+		Stats:            string(stats),
+	}, nil
+}
+
+// commit atomically writes the commit file of the version. It fails if the
+// version has been committed in the meantime.
+func (w *DeltaTableWriter) commit(version int64, actions []deltaAction) error {
+	logDir := filepath.Join(w.dir, DeltaLogDir)
+	// #nosec G301 -- the table is meant to be read by other tools
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return fmt.Errorf("creating Delta log %s: %w", logDir, err)
+	}
+
+	tmp, err := os.CreateTemp(logDir, ".commit-*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating Delta commit: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := json.NewEncoder(tmp)
+	for _, action := range actions {
+		if err := encoder.Encode(action); err != nil {
+			tmp.Close()
+			return fmt.Errorf("writing Delta commit: %w", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing Delta commit: %w", err)
+	}
+	// #nosec G302 -- the log is meant to be read by other tools
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("writing Delta commit: %w", err)
+	}
+
+	// Linking fails if the commit file already exists, unlike renaming.
+	name := filepath.Join(logDir, fmt.Sprintf("%020d.json", version))
+	if err := os.Link(tmp.Name(), name); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("delta table version %d was committed by another writer", version)
+		}
+		return fmt.Errorf("committing Delta table version %d: %w", version, err)
+	}
+	return nil
+}
+
+// Close closes the data files left open when the table is not flushed.
+func (w *DeltaTableWriter) Close() error {
+	return w.dataset.Close()
+}
+
+// sparkStruct, sparkArray, sparkMap, and sparkField are the JSON encoding of
+// Spark SQL types used by Delta table schemas. Primitive types are strings.
+type sparkStruct struct {
+	Type   string       `json:"type"`
+	Fields []sparkField `json:"fields"`
+}
+
+type sparkField struct {
+	Name     string         `json:"name"`
+	Type     any            `json:"type"`
+	Nullable bool           `json:"nullable"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type sparkArray struct {
+	Type         string `json:"type"`
+	ElementType  any    `json:"elementType"`
+	ContainsNull bool   `json:"containsNull"`
+}
+
+type sparkMap struct {
+	Type              string `json:"type"`
+	KeyType           any    `json:"keyType"`
+	ValueType         any    `json:"valueType"`
+	ValueContainsNull bool   `json:"valueContainsNull"`
+}
+
+// schemaString returns the Delta schema of the table: the columns of the
+// data files followed by the partition columns. All data files must have the
+// same schema.
+func (w *DeltaTableWriter) schemaString() (string, error) {
+	var fields []sparkField
+	var first string
+	for _, f := range w.dataset.files {
+		fileFields, err := sparkFields(f.schema.Fields())
+		if err != nil {
+			return "", fmt.Errorf("converting schema of %s: %w", f.Path, err)
+		}
+		if fields == nil {
+			fields, first = fileFields, f.Path
+			continue
+		}
+		if !sparkFieldsEqual(fields, fileFields) {
+			return "", fmt.Errorf(
+				"data files %s and %s have different schemas, but a Delta table has a single schema; "+
+					"integer network columns differ between IPv4 and IPv6",
+				first,
+				f.Path,
+			)
+		}
+	}
+
+	if fields == nil {
+		// No rows were written, so take the schema from the configuration.
+		nestedTypes := make([]*nestedType, len(w.dataset.config.Columns))
+		for i, col := range w.dataset.config.Columns {
+			switch {
+			case w.dataset.nestedTypes != nil && w.dataset.nestedTypes.types[i] != nil:
+				nestedTypes[i] = w.dataset.nestedTypes.types[i]
+			case isNestedTypeHint(col.Type):
+				nestedTypes[i] = newNestedType(col)
+				nestedTypes[i].finalize()
+			}
+		}
+		schema, err := buildSchema(w.dataset.config, ipVersionAny, nestedTypes)
+		if err != nil {
+			return "", fmt.Errorf("building Parquet schema: %w", err)
+		}
+		if fields, err = sparkFields(schema.Fields()); err != nil {
+			return "", err
+		}
+	}
+
+	for _, key := range w.dataset.keys {
+		var t any = "string"
+		switch {
+		case key.column >= 0:
+			node, err := buildDataNode(w.config.Columns[key.column])
+			if err != nil {
+				return "", err
+			}
+			if t, err = sparkType(node); err != nil {
+				return "", fmt.Errorf("partition column '%s': %w", key.name, err)
+			}
+		case key.name == config.PartitionIPVersion:
+			t = "integer"
+		}
+		fields = append(fields, sparkField{
+			Name:     key.name,
+			Type:     t,
+			Nullable: true,
+			Metadata: map[string]any{},
+		})
+	}
+
+	b, err := json.Marshal(sparkStruct{Type: "struct", Fields: fields})
+	if err != nil {
+		return "", fmt.Errorf("encoding Delta schema: %w", err)
+	}
+	return string(b), nil
+}
+
+// sparkFieldsEqual reports whether two lists of fields have the same names
+// and types.
+func sparkFieldsEqual(a, b []sparkField) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// sparkFields converts Parquet fields to nullable Spark struct fields.
+func sparkFields(fields []parquet.Field) ([]sparkField, error) {
+	out := make([]sparkField, len(fields))
+	for i, field := range fields {
+		t, err := sparkType(field)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %w", field.Name(), err)
+		}
+		out[i] = sparkField{
+			Name:     field.Name(),
+			Type:     t,
+			Nullable: true,
+			Metadata: map[string]any{},
+		}
+	}
+	return out, nil
+}
+
+// sparkType returns the Spark SQL type Spark reads a Parquet node as.
+// Unsigned 32-bit integers are read as long and unsigned 64-bit integers as
+// decimal(20,0).
+func sparkType(node parquet.Node) (any, error) {
+	if lt := node.Type().LogicalType(); lt != nil {
+		switch {
+		case lt.UTF8 != nil:
+			return "string", nil
+		case lt.Decimal != nil:
+			return fmt.Sprintf("decimal(%d,%d)", lt.Decimal.Precision, lt.Decimal.Scale), nil
+		case lt.Integer != nil:
+			switch {
+			case lt.Integer.IsSigned && lt.Integer.BitWidth <= 32:
+				return "integer", nil
+			case lt.Integer.IsSigned, lt.Integer.BitWidth <= 32:
+				return "long", nil
+			default:
+				return "decimal(20,0)", nil
+			}
+		case lt.List != nil:
+			element := childNode(childNode(node, "list"), "element")
+			if element == nil {
+				return nil, errors.New("LIST group without list.element field")
+			}
+			elementType, err := sparkType(element)
+			if err != nil {
+				return nil, err
+			}
+			return sparkArray{Type: "array", ElementType: elementType, ContainsNull: true}, nil
+		case lt.Map != nil:
+			keyValue := childNode(node, "key_value")
+			key, value := childNode(keyValue, "key"), childNode(keyValue, "value")
+			if key == nil || value == nil {
+				return nil, errors.New("MAP group without key_value.key and key_value.value fields")
+			}
+			keyType, err := sparkType(key)
+			if err != nil {
+				return nil, err
+			}
+			valueType, err := sparkType(value)
+			if err != nil {
+				return nil, err
+			}
+			return sparkMap{
+				Type:              "map",
+				KeyType:           keyType,
+				ValueType:         valueType,
+				ValueContainsNull: true,
+			}, nil
+		}
+	}
+
+	if !node.Leaf() {
+		fields, err := sparkFields(node.Fields())
+		if err != nil {
+			return nil, err
+		}
+		return sparkStruct{Type: "struct", Fields: fields}, nil
+	}
+
+	switch node.Type().Kind() {
+	case parquet.Boolean:
+		return "boolean", nil
+	case parquet.Int32:
+		return "integer", nil
+	case parquet.Int64:
+		return "long", nil
+	case parquet.Float:
+		return "float", nil
+	case parquet.Double:
+		return "double", nil
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		return "binary", nil
+	default:
+		return nil, fmt.Errorf("unsupported Parquet type %s", node.Type())
+	}
+}
+
+// childNode returns the field of the group node with the given name, or nil.
+func childNode(node parquet.Node, name string) parquet.Node {
+	if node == nil {
+		return nil
+	}
+	for _, field := range node.Fields() {
+		if field.Name() == name {
+			return field
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+// readDeltaCommit returns the actions of a commit file of the table in dir.
+func readDeltaCommit(t *testing.T, dir, name string) []deltaAction {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, DeltaLogDir, name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var actions []deltaAction
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var action deltaAction
+		require.NoError(t, decoder.Decode(&action))
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+func writeDeltaVersion(t *testing.T, dir string, cfg *config.Config, epoch uint, cities ...string) {
+	t.Helper()
+	w, err := NewDeltaTableWriter(dir, cfg, &Provenance{
+		Version:   "1.2.3",
+		Databases: []SourceDatabase{{Name: "geo", BuildEpoch: epoch}},
+	})
+	require.NoError(t, err)
+	for i, city := range cities {
+		prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{1, 0, byte(i), 0}), 24)
+		require.NoError(t, w.WriteRow(prefix, []mmdbtype.DataType{
+			mmdbtype.String("US"),
+			mmdbtype.String(city),
+		}))
+	}
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Close())
+}
+
+func TestDeltaTableWriter_CommitsVersions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "table")
+	cfg := partitionTestConfig([]string{"country"}, 0)
+	cfg.Output.Parquet.TableFormat = config.TableFormatDelta
+
+	writeDeltaVersion(t, dir, cfg, 1000, "Dallas", "Austin")
+	writeDeltaVersion(t, dir, cfg, 2000, "Boston")
+
+	v0 := readDeltaCommit(t, dir, "00000000000000000000.json")
+	require.Len(t, v0, 4)
+	require.NotNil(t, v0[0].CommitInfo)
+	assert.Equal(t, "WRITE", v0[0].CommitInfo.Operation)
+	assert.Equal(t, "mmdbconvert/1.2.3", v0[0].CommitInfo.EngineInfo)
+	assert.Equal(t, map[string]uint{"geo": 1000}, v0[0].CommitInfo.BuildEpochs)
+	assert.Contains(t, v0[0].CommitInfo.UserMetadata, `"build_epoch":1000`)
+	assert.Equal(t, &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 2}, v0[1].Protocol)
+
+	metadata := v0[2].MetaData
+	require.NotNil(t, metadata)
+	assert.Equal(t, []string{"country"}, metadata.PartitionColumns)
+	assert.Equal(t, "parquet", metadata.Format.Provider)
+	assert.JSONEq(t, `{"type":"struct","fields":[
+		{"name":"network","type":"string","nullable":true,"metadata":{}},
+		{"name":"city","type":"string","nullable":true,"metadata":{}},
+		{"name":"country","type":"string","nullable":true,"metadata":{}}
+	]}`, metadata.SchemaString)
+
+	add := v0[3].Add
+	require.NotNil(t, add)
+	assert.Regexp(t, `^country=US/part-0000-[0-9a-f-]{36}\.parquet$`, add.Path)
+	require.Contains(t, add.PartitionValues, "country")
+	assert.Equal(t, "US", *add.PartitionValues["country"])
+	assert.JSONEq(t, `{"numRecords":2}`, add.Stats)
+	assert.True(t, add.DataChange)
+
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(add.Path)))
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), add.Size)
+
+	// The second version replaces the files of the first, which stay on
+	// disk, and keeps the unchanged metadata.
+	v1 := readDeltaCommit(t, dir, "00000000000000000001.json")
+	require.Len(t, v1, 3)
+	assert.Equal(t, map[string]uint{"geo": 2000}, v1[0].CommitInfo.BuildEpochs)
+	require.NotNil(t, v1[1].Remove)
+	assert.Equal(t, add.Path, v1[1].Remove.Path)
+	require.NotNil(t, v1[2].Add)
+	assert.NotEqual(t, add.Path, v1[2].Add.Path)
+	assert.JSONEq(t, `{"numRecords":1}`, v1[2].Add.Stats)
+
+	table, err := readDeltaTable(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), table.version)
+	assert.Equal(t, metadata.ID, table.metadata.ID)
+	assert.Equal(t, []string{v1[2].Add.Path}, table.files)
+}
+
+func TestDeltaTableWriter_SchemaChangeWritesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig(nil, 0)
+	cfg.Output.Parquet.TableFormat = config.TableFormatDelta
+	writeDeltaVersion(t, dir, cfg, 1000, "Dallas")
+
+	cfg.Network.Columns = []config.NetworkColumn{{Name: "start_int", Type: "start_int"}}
+	writeDeltaVersion(t, dir, cfg, 2000, "Austin")
+
+	v0 := readDeltaCommit(t, dir, "00000000000000000000.json")
+	v1 := readDeltaCommit(t, dir, "00000000000000000001.json")
+	require.NotNil(t, v1[1].MetaData)
+	assert.Equal(t, v0[2].MetaData.ID, v1[1].MetaData.ID)
+	assert.Contains(t, v1[1].MetaData.SchemaString, `{"name":"start_int","type":"long"`)
+}
+
+func TestDeltaTableWriter_EscapesPaths(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig([]string{"network_prefix"}, 0)
+	cfg.Output.Parquet.TableFormat = config.TableFormatDelta
+	writeDeltaVersion(t, dir, cfg, 1000, "Dallas")
+
+	v0 := readDeltaCommit(t, dir, "00000000000000000000.json")
+	add := v0[3].Add
+	require.NotNil(t, add)
+	assert.Regexp(t, `^network_prefix=1.0.0.0%252F8/part-0000-`, add.Path)
+	assert.Equal(t, "1.0.0.0/8", *add.PartitionValues["network_prefix"])
+}
+
+func TestDeltaTableWriter_RejectsMixedSchemas(t *testing.T) {
+	cfg := partitionTestConfig([]string{"ip_version"}, 0)
+	cfg.Output.Parquet.TableFormat = config.TableFormatDelta
+	cfg.Network.Columns = []config.NetworkColumn{{Name: "start_int", Type: "start_int"}}
+
+	w, err := NewDeltaTableWriter(t.TempDir(), cfg, nil)
+	require.NoError(t, err)
+	defer w.Close()
+	data := []mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.String("Dallas")}
+	require.NoError(t, w.WriteRow(netip.MustParsePrefix("1.0.0.0/24"), data))
+	require.NoError(t, w.WriteRow(netip.MustParsePrefix("2001:db8::/32"), data))
+
+	require.ErrorContains(t, w.Flush(), "have different schemas")
+}
+
+func TestDeltaTableWriter_RejectsOtherDirectories(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.csv"), nil, 0o600))
+
+	_, err := NewDeltaTableWriter(dir, partitionTestConfig(nil, 0), nil)
+	require.ErrorContains(t, err, "is not empty")
+
+	dir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, DeltaLogDir), 0o750))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, DeltaLogDir, "00000000000000000000.json"),
+		[]byte(`{"protocol":{"minReaderVersion":3,"minWriterVersion":7}}`+"\n"),
+		0o600,
+	))
+	_, err = NewDeltaTableWriter(dir, partitionTestConfig(nil, 0), nil)
+	require.ErrorContains(t, err, "requires reader version 3 and writer version 7")
+}
+
+func TestSparkType(t *testing.T) {
+	tests := []struct {
+		name string
+		node parquet.Node
+		want string
+	}{
+		{"string", parquet.String(), `"string"`},
+		{"int64", parquet.Int(64), `"long"`},
+		{"uint32", parquet.Uint(32), `"long"`},
+		{"uint64", parquet.Uint(64), `"decimal(20,0)"`},
+		{"decimal", parquet.Decimal(4, 9, parquet.Int32Type), `"decimal(9,4)"`},
+		{"double", parquet.Leaf(parquet.DoubleType), `"double"`},
+		{"binary", parquet.Leaf(parquet.FixedLenByteArrayType(16)), `"binary"`},
+		{
+			"list",
+			parquet.List(parquet.Optional(parquet.String())),
+			`{"type":"array","elementType":"string","containsNull":true}`,
+		},
+		{
+			"map",
+			parquet.Map(parquet.String(), parquet.Optional(parquet.Int(64))),
+			`{"type":"map","keyType":"string","valueType":"long","valueContainsNull":true}`,
+		},
+		{
+			"struct",
+			parquet.Group{"code": parquet.Optional(parquet.String())},
+			`{"type":"struct","fields":[{"name":"code","type":"string","nullable":true,"metadata":{}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sparkType(tt.node)
+			require.NoError(t, err)
+			b, err := json.Marshal(got)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(b))
+		})
+	}
+}
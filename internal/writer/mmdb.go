@@ -5,6 +5,7 @@ import (
 	"maps"
 	"net/netip"
 	"os"
+	"slices"
 
 	"github.com/maxmind/mmdbwriter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
@@ -15,41 +16,78 @@ import (
 
 // MMDBWriter writes merged MMDB data to MMDB format.
 type MMDBWriter struct {
-	tree     *mmdbwriter.Tree
-	config   *config.Config
-	filePath string
+	tree       *mmdbwriter.Tree
+	config     *config.Config
+	filePath   string
+	builder    recordBuilder
+	interner   *valueInterner // nil unless output.mmdb.low_memory is set
+	keyFilters []*keyFilter   // Per column, nil for columns without key options
 }
 
-// NewMMDBWriter creates a new MMDB writer.
-func NewMMDBWriter(outputPath string, cfg *config.Config, ipVersion int) (*MMDBWriter, error) {
+// NewMMDBWriter creates a new MMDB writer. If provenance is not nil, it is
+// recorded as JSON in the database description under ProvenanceKey.
+func NewMMDBWriter(
+	outputPath string,
+	cfg *config.Config,
+	ipVersion int,
+	provenance *Provenance,
+) (*MMDBWriter, error) {
 	if ipVersion != 4 && ipVersion != 6 {
 		return nil, fmt.Errorf("invalid IP version: %d", ipVersion)
 	}
 
+	description := cfg.Output.MMDB.Description
+	if provenance != nil {
+		encoded, err := provenance.JSON()
+		if err != nil {
+			return nil, err
+		}
+		description = maps.Clone(description)
+		if description == nil {
+			description = map[string]string{}
+		}
+		description[ProvenanceKey] = encoded
+	}
+
 	tree, err := mmdbwriter.New(mmdbwriter.Options{
 		DatabaseType:            cfg.Output.MMDB.DatabaseType,
-		Description:             cfg.Output.MMDB.Description,
+		Description:             description,
 		Languages:               cfg.Output.MMDB.Languages,
 		RecordSize:              *cfg.Output.MMDB.RecordSize,
 		IPVersion:               ipVersion,
 		IncludeReservedNetworks: *cfg.Output.MMDB.IncludeReservedNetworks,
+		DisableIPv4Aliasing:     cfg.Output.MMDB.DisableIPv4Aliasing,
+		DisableMetadataPointers: cfg.Output.MMDB.DisableMetadataPointers,
+		BuildEpoch:              cfg.Output.MMDB.BuildEpoch,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("creating MMDB tree: %w", err)
 	}
 
-	return &MMDBWriter{
-		tree:     tree,
-		config:   cfg,
-		filePath: outputPath,
-	}, nil
+	w := &MMDBWriter{
+		tree:       tree,
+		config:     cfg,
+		filePath:   outputPath,
+		keyFilters: make([]*keyFilter, len(cfg.Columns)),
+	}
+	for i, col := range cfg.Columns {
+		filter, err := newKeyFilter(col)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		w.keyFilters[i] = filter
+	}
+	if cfg.Output.MMDB.LowMemory {
+		w.interner = newValueInterner()
+	}
+	return w, nil
 }
 
 // WriteRow writes a single row with network prefix and column data.
 func (w *MMDBWriter) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType) error {
-	nested, err := w.buildNestedData(data)
+	nested, err := w.Record(data)
 	if err != nil {
-		return fmt.Errorf("building nested data: %w", err)
+		return err
 	}
 
 	ipnet := netipx.PrefixIPNet(prefix)
@@ -62,9 +100,9 @@ func (w *MMDBWriter) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType) err
 
 // WriteRange writes a range of IP addresses with the same data.
 func (w *MMDBWriter) WriteRange(start, end netip.Addr, data []mmdbtype.DataType) error {
-	nested, err := w.buildNestedData(data)
+	nested, err := w.Record(data)
 	if err != nil {
-		return fmt.Errorf("building nested data: %w", err)
+		return err
 	}
 
 	cidrs := netipx.IPRangeFrom(start, end).Prefixes()
@@ -77,6 +115,22 @@ func (w *MMDBWriter) WriteRange(start, end netip.Addr, data []mmdbtype.DataType)
 	return nil
 }
 
+// Record builds the record written for a row, sharing its values with earlier
+// records in low-memory mode. The returned map must not be modified.
+func (w *MMDBWriter) Record(data []mmdbtype.DataType) (mmdbtype.Map, error) {
+	nested, err := w.buildNestedData(data)
+	if err != nil {
+		return nil, fmt.Errorf("building nested data: %w", err)
+	}
+	if w.interner == nil {
+		return nested, nil
+	}
+	if err := w.interner.internValues(w.builder.root); err != nil {
+		return nil, fmt.Errorf("deduplicating record: %w", err)
+	}
+	return nested, nil
+}
+
 // Flush writes the MMDB tree to disk.
 func (w *MMDBWriter) Flush() error {
 	f, err := os.Create(w.filePath)
@@ -95,8 +149,6 @@ func (w *MMDBWriter) Flush() error {
 
 // buildNestedData converts flat column data to nested mmdbtype.Map.
 func (w *MMDBWriter) buildNestedData(flatData []mmdbtype.DataType) (mmdbtype.Map, error) {
-	root := make(mmdbtype.Map)
-
 	if len(flatData) < len(w.config.Columns) {
 		return nil, fmt.Errorf(
 			"data slice length %d is less than column count %d",
@@ -105,153 +157,373 @@ func (w *MMDBWriter) buildNestedData(flatData []mmdbtype.DataType) (mmdbtype.Map
 		)
 	}
 
+	w.builder.reset(len(w.config.Columns))
+
 	for i, col := range w.config.Columns {
 		value := flatData[i] //nolint:gosec // G602: bounds checked above
 		if value == nil {
 			continue
 		}
 
+		if w.keyFilters != nil && w.keyFilters[i] != nil {
+			filtered, err := w.keyFilters[i].apply(value)
+			if err != nil {
+				return nil, fmt.Errorf("filtering keys of column %s: %w", col.Name, err)
+			}
+			value = filtered
+		}
+
+		if col.Type != "" {
+			converted, err := convertMMDBValue(value, col.Type)
+			if err != nil {
+				return nil, fmt.Errorf("converting column %s to %s: %w", col.Name, col.Type, err)
+			}
+			value = converted
+		}
+
 		// Use output_path if set, otherwise use [name] for flat structure
 		path := col.OutputPath
 		if path == nil {
-			path = &config.Path{col.Name}
+			path = &config.Path{string(col.Name)}
 		}
 
-		var err error
-		root, err = mergeNestedValue(root, path.Segments(), value)
-		if err != nil {
+		if err := w.builder.merge(path.Segments(), value, col.MergeStrategy); err != nil {
 			return nil, fmt.Errorf("setting column %s: %w", col.Name, err)
 		}
 	}
 
-	return root, nil
+	return w.builder.root.m, nil
 }
 
 // mergeNestedValue returns a new map with value merged at the specified path.
-// If the value is a Map and a Map already exists at the target location, they are merged.
-// Neither root nor value are modified.
+// If something already exists at the target location, strategy (one of the
+// config.MergeStrategy constants, "" meaning config.MergeStrategyError)
+// decides how the two are combined; see resolveConflict. Neither root nor
+// value are modified.
 func mergeNestedValue(
 	root mmdbtype.Map,
 	path []any,
 	value mmdbtype.DataType,
+	strategy string,
 ) (mmdbtype.Map, error) {
+	b := recordBuilder{root: copyMap(root, 1)}
+	if err := b.merge(path, value, strategy); err != nil {
+		return nil, err
+	}
+	return b.root.m, nil
+}
+
+// mergeMaps returns a new map with contents merged from dest and source.
+// Keys present in both are combined according to strategy. Neither dest nor
+// source are modified.
+func mergeMaps(dest, source mmdbtype.Map, strategy string) (mmdbtype.Map, error) {
+	result := copyMap(dest, len(source))
+	if err := result.merge(source, strategy); err != nil {
+		return nil, err
+	}
+	return result.m, nil
+}
+
+// ownedMap is a map created by the recordBuilder, which may therefore be
+// updated in place, along with the maps under its keys that were also
+// created by the builder. Column values, such as the maps copied by
+// path = [], may be shared with other rows, so a map that is not owned is
+// copied the first time something is written into it. Building a record thus
+// copies each map at most once rather than once per column.
+type ownedMap struct {
+	m        mmdbtype.Map
+	children map[mmdbtype.String]*ownedMap // Owned maps stored in m
+}
+
+// copyMap returns an owned copy of m, which may be nil, with room for extra
+// more keys.
+func copyMap(m mmdbtype.Map, extra int) *ownedMap {
+	o := &ownedMap{m: make(mmdbtype.Map, len(m)+extra)}
+	maps.Copy(o.m, m)
+	return o
+}
+
+// store sets key to value, which is the map of child if child is not nil.
+func (o *ownedMap) store(key mmdbtype.String, value mmdbtype.DataType, child *ownedMap) {
+	o.m[key] = value
+	if child == nil {
+		delete(o.children, key)
+		return
+	}
+	if o.children == nil {
+		o.children = map[mmdbtype.String]*ownedMap{}
+	}
+	o.children[key] = child
+}
+
+// merge merges the keys of source into o. Keys present in both are combined
+// according to strategy.
+func (o *ownedMap) merge(source mmdbtype.Map, strategy string) error {
+	for key, sourceValue := range source {
+		destValue, exists := o.m[key]
+		if !exists {
+			// No conflict - add to result
+			o.store(key, sourceValue, nil)
+			continue
+		}
+
+		merged, child, ok, err := resolveConflict(destValue, o.children[key], sourceValue, strategy)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf(
+				"field conflict: key %s already exists (cannot merge %T with %T)",
+				key,
+				destValue,
+				sourceValue,
+			)
+		}
+		o.store(key, merged, child)
+	}
+	return nil
+}
+
+// recordBuilder assembles a record from column values.
+type recordBuilder struct {
+	root *ownedMap
+}
+
+// reset starts a new record with room for size keys. The maps of the previous
+// record are no longer owned, as they must not be modified after their record
+// is inserted.
+func (b *recordBuilder) reset(size int) {
+	b.root = copyMap(nil, size)
+}
+
+// merge merges value into the record at path, as mergeNestedValue does.
+func (b *recordBuilder) merge(path []any, value mmdbtype.DataType, strategy string) error {
 	// Special case: empty path means merge into root
 	if len(path) == 0 {
 		valueMap, ok := value.(mmdbtype.Map)
 		if !ok {
-			return nil, fmt.Errorf(
+			return fmt.Errorf(
 				"cannot set non-map value at root with empty path, got %T",
 				value,
 			)
 		}
-		return mergeMaps(root, valueMap)
+		return b.root.merge(valueMap, strategy)
+	}
+	if _, isIndex := pathIndex(path[0]); isIndex {
+		return fmt.Errorf("cannot index the record with %v: the root is a map", path[0])
 	}
 
-	// Copy root to avoid mutation
-	result := make(mmdbtype.Map, len(root))
-	maps.Copy(result, root)
+	_, _, _, err := set(b.root.m, b.root, path, 0, value, strategy)
+	return err
+}
 
-	// Navigate to parent, copying and creating nested maps as needed
-	current := result
-	for i := range len(path) - 1 {
+// set writes value at path[i:] within container, the value found at
+// path[:i]. container is a map if path[i] is a key and a slice if it is an
+// array index, or nil if nothing exists there yet; owned is its ownedMap if
+// the builder owns it. Array indexes may point at an existing element or one
+// past the last, which extends the slice. set returns the updated container
+// and its ownedMap, if any, and kept is true if the keep_first strategy left
+// the record unchanged because of a path conflict.
+//
+// Slices are copied on every write, and the maps in them are not owned, as
+// they are only written through array indexes in output paths.
+func set(
+	container mmdbtype.DataType,
+	owned *ownedMap,
+	path []any,
+	i int,
+	value mmdbtype.DataType,
+	strategy string,
+) (updated mmdbtype.DataType, updatedOwned *ownedMap, kept bool, err error) {
+	var (
+		existing      mmdbtype.DataType
+		existingOwned *ownedMap
+		exists        bool
+		store         func(mmdbtype.DataType, *ownedMap) (mmdbtype.DataType, *ownedMap)
+	)
+	if index, isIndex := pathIndex(path[i]); isIndex {
+		slice, _ := container.(mmdbtype.Slice)
+		if index > len(slice) {
+			return nil, nil, false, fmt.Errorf(
+				"array index %d at path %v is out of range: the array has %d elements",
+				index,
+				path[:i+1],
+				len(slice),
+			)
+		}
+		if index < len(slice) {
+			existing, exists = slice[index], true
+		}
+		store = func(v mmdbtype.DataType, _ *ownedMap) (mmdbtype.DataType, *ownedMap) {
+			result := slices.Clone(slice)
+			if index == len(result) {
+				return append(result, v), nil
+			}
+			result[index] = v
+			return result, nil
+		}
+	} else {
 		key, ok := path[i].(string)
 		if !ok {
-			return nil, fmt.Errorf("non-string key: %v", path[i])
+			return nil, nil, false, fmt.Errorf("invalid path segment: %v", path[i])
+		}
+		o := owned
+		if o == nil {
+			existingMap, _ := container.(mmdbtype.Map)
+			o = copyMap(existingMap, 1)
 		}
-
 		mmdbKey := mmdbtype.String(key)
+		existing, exists = o.m[mmdbKey]
+		existingOwned = o.children[mmdbKey]
+		store = func(v mmdbtype.DataType, child *ownedMap) (mmdbtype.DataType, *ownedMap) {
+			o.store(mmdbKey, v, child)
+			return o.m, o
+		}
+	}
 
-		if existing, exists := current[mmdbKey]; exists {
-			// Must be a map to navigate further
-			existingMap, ok := existing.(mmdbtype.Map)
-			if !ok {
-				return nil, fmt.Errorf("path conflict at %s: expected map, got %T", key, existing)
+	// Navigate further, copying and creating nested containers as needed
+	if i < len(path)-1 {
+		next, nextOwned := existing, existingOwned
+		if exists && !isContainerFor(existing, path[i+1]) {
+			switch strategy {
+			case config.MergeStrategyKeepFirst:
+				return container, owned, true, nil
+			case config.MergeStrategyOverwrite, config.MergeStrategyDeepMerge:
+				next, nextOwned = nil, nil
+			default:
+				want := "map"
+				if _, isIndex := pathIndex(path[i+1]); isIndex {
+					want = "array"
+				}
+				return nil, nil, false, fmt.Errorf(
+					"path conflict at %v: expected %s, got %T",
+					path[i],
+					want,
+					existing,
+				)
 			}
-			// Copy the nested map to avoid mutation
-			next := make(mmdbtype.Map, len(existingMap))
-			maps.Copy(next, existingMap)
-			current[mmdbKey] = next
-			current = next
-		} else {
-			// Create new nested map
-			next := make(mmdbtype.Map)
-			current[mmdbKey] = next
-			current = next
 		}
+		child, childOwned, kept, err := set(next, nextOwned, path, i+1, value, strategy)
+		if err != nil || kept {
+			return container, owned, kept, err
+		}
+		updated, updatedOwned = store(child, childOwned)
+		return updated, updatedOwned, false, nil
 	}
 
-	// Handle final key
-	finalKey, ok := path[len(path)-1].(string)
-	if !ok {
-		return nil, fmt.Errorf("non-string final key: %v", path[len(path)-1])
+	// Handle final segment
+	if !exists {
+		updated, updatedOwned = store(value, nil)
+		return updated, updatedOwned, false, nil
 	}
 
-	mmdbKey := mmdbtype.String(finalKey)
-
-	// If value is a Map and something already exists at this key, try to merge
-	if valueMap, ok := value.(mmdbtype.Map); ok {
-		if existing, exists := current[mmdbKey]; exists {
-			// If existing is also a Map, merge them
-			if existingMap, ok := existing.(mmdbtype.Map); ok {
-				merged, err := mergeMaps(existingMap, valueMap)
-				if err != nil {
-					return nil, err
-				}
-				current[mmdbKey] = merged
-				return result, nil
-			}
-			// Cannot merge map into non-map
-			return nil, fmt.Errorf(
+	merged, mergedOwned, ok, err := resolveConflict(existing, existingOwned, value, strategy)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if !ok {
+		if _, valueIsMap := value.(mmdbtype.Map); valueIsMap {
+			return nil, nil, false, fmt.Errorf(
 				"cannot merge map into non-map at path %v: existing value is %T",
 				path,
 				existing,
 			)
 		}
+		return nil, nil, false, fmt.Errorf(
+			"field conflict at path %v: a value already exists (cannot merge %T with %T)",
+			path,
+			existing,
+			value,
+		)
 	}
+	updated, updatedOwned = store(merged, mergedOwned)
+	return updated, updatedOwned, false, nil
+}
 
-	// No conflict or not a map - just set the value
-	current[mmdbKey] = value
-	return result, nil
+// pathIndex returns the array index of an output path segment, which TOML
+// decodes as int64, and whether the segment is one.
+func pathIndex(seg any) (int, bool) {
+	switch s := seg.(type) {
+	case int:
+		return s, true
+	case int64:
+		return int(s), true
+	default:
+		return 0, false
+	}
 }
 
-// mergeMaps returns a new map with contents merged from dest and source.
-// If both maps have the same key:
-// - If both values are maps, merge recursively.
-// - Otherwise, return error (fail-fast on conflicts).
-// Neither dest nor source are modified.
-func mergeMaps(dest, source mmdbtype.Map) (mmdbtype.Map, error) {
-	// Pre-allocate for efficiency
-	result := make(mmdbtype.Map, len(dest)+len(source))
-	maps.Copy(result, dest)
+// isContainerFor reports whether value can be navigated by seg: a map for a
+// key or a slice for an array index.
+func isContainerFor(value mmdbtype.DataType, seg any) bool {
+	if _, isIndex := pathIndex(seg); isIndex {
+		_, ok := value.(mmdbtype.Slice)
+		return ok
+	}
+	_, ok := value.(mmdbtype.Map)
+	return ok
+}
 
-	for key, sourceValue := range source {
-		if destValue, exists := result[key]; exists {
-			// Both have this key - check if both are maps
-			destMap, destIsMap := destValue.(mmdbtype.Map)
-			sourceMap, sourceIsMap := sourceValue.(mmdbtype.Map)
-
-			if destIsMap && sourceIsMap {
-				// Both are maps - merge recursively
-				merged, err := mergeMaps(destMap, sourceMap)
-				if err != nil {
-					return nil, err
-				}
-				result[key] = merged
-				continue
-			}
+// resolveConflict combines an existing value, whose ownedMap is owned if the
+// builder owns it, with a new value written to the same key:
+//
+//   - keep_first keeps the existing value.
+//   - overwrite replaces it with the new value.
+//   - deep_merge merges maps recursively, and the new value wins any other
+//     conflict.
+//   - append merges maps recursively and collects any other conflicting
+//     values into a slice, appending the elements of slices.
+//   - error (the default) merges maps recursively and fails on any other
+//     conflict.
+//
+// ok is false if the values conflict under the error strategy. Maps are
+// merged into existing in place if it is owned, and into an owned copy
+// otherwise; mergedOwned is the ownedMap of the result, if any.
+func resolveConflict(
+	existing mmdbtype.DataType,
+	owned *ownedMap,
+	value mmdbtype.DataType,
+	strategy string,
+) (merged mmdbtype.DataType, mergedOwned *ownedMap, ok bool, err error) {
+	switch strategy {
+	case config.MergeStrategyKeepFirst:
+		return existing, owned, true, nil
+	case config.MergeStrategyOverwrite:
+		return value, nil, true, nil
+	}
 
-			// Conflict: same key but at least one is not a map
-			return nil, fmt.Errorf(
-				"field conflict: key %s already exists (cannot merge %T with %T)",
-				key,
-				destValue,
-				sourceValue,
-			)
+	existingMap, existingIsMap := existing.(mmdbtype.Map)
+	valueMap, valueIsMap := value.(mmdbtype.Map)
+	if existingIsMap && valueIsMap {
+		if owned == nil {
+			owned = copyMap(existingMap, len(valueMap))
 		}
+		if err := owned.merge(valueMap, strategy); err != nil {
+			return nil, nil, false, err
+		}
+		return owned.m, owned, true, nil
+	}
 
-		// No conflict - add to result
-		result[key] = sourceValue
+	switch strategy {
+	case config.MergeStrategyDeepMerge:
+		return value, nil, true, nil
+	case config.MergeStrategyAppend:
+		return appendValues(existing, value), nil, true, nil
+	default:
+		return nil, nil, false, nil
 	}
+}
 
-	return result, nil
+// appendValues returns a new slice holding existing followed by value. Slices
+// contribute their elements rather than being nested.
+func appendValues(existing, value mmdbtype.DataType) mmdbtype.Slice {
+	var result mmdbtype.Slice
+	for _, v := range []mmdbtype.DataType{existing, value} {
+		if s, ok := v.(mmdbtype.Slice); ok {
+			result = append(result, s...)
+		} else {
+			result = append(result, v)
+		}
+	}
+	return result
 }
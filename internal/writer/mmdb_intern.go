@@ -0,0 +1,94 @@
+package writer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// valueInterner returns a single shared instance of each distinct map and
+// slice in the records passed to it. mmdbwriter only deduplicates whole
+// records, but records of neighboring networks often differ in a single field
+// while repeating the maps built from the other columns, e.g. the location
+// map of a city or the traits merged from several databases. Without
+// interning, every distinct record holds its own copy of them until the tree
+// is written.
+type valueInterner struct {
+	values map[[sha256.Size]byte]mmdbtype.DataType
+	key    keyBuffer
+}
+
+func newValueInterner() *valueInterner {
+	return &valueInterner{values: map[[sha256.Size]byte]mmdbtype.DataType{}}
+}
+
+// intern returns the shared instance of v. The first instance of a value
+// becomes the shared one. If v is the map of owned, which the record builder
+// owns, it is updated in place to refer to the shared instances of its values;
+// other values, such as the maps decoded from the source databases, are never
+// modified and are only shared as a whole. The returned value must not be
+// modified.
+func (vi *valueInterner) intern(
+	v mmdbtype.DataType,
+	owned *ownedMap,
+) (mmdbtype.DataType, error) {
+	switch v.(type) {
+	case mmdbtype.Map, mmdbtype.Slice:
+	default:
+		return v, nil
+	}
+
+	key, err := vi.key.sum(v)
+	if err != nil {
+		return nil, err
+	}
+	if shared, ok := vi.values[key]; ok {
+		return shared, nil
+	}
+
+	if owned != nil {
+		if err := vi.internValues(owned); err != nil {
+			return nil, err
+		}
+	}
+	vi.values[key] = v
+	return v, nil
+}
+
+// internValues replaces the values of the owned map o with their shared
+// instances. It is used for whole records, which mmdbwriter already
+// deduplicates. o must not be built on afterwards, as its values may then be
+// shared.
+func (vi *valueInterner) internValues(o *ownedMap) error {
+	for k, value := range o.m {
+		value, err := vi.intern(value, o.children[k])
+		if err != nil {
+			return err
+		}
+		o.m[k] = value
+	}
+	return nil
+}
+
+// keyBuffer serializes values in the MMDB data format without pointers, so
+// that two values have the same key exactly when they would be written the
+// same way.
+type keyBuffer struct {
+	bytes.Buffer
+}
+
+// WriteOrWritePointer implements the writer interface of mmdbtype.
+func (kb *keyBuffer) WriteOrWritePointer(v mmdbtype.DataType) (int64, error) {
+	return v.WriteTo(kb)
+}
+
+// sum returns the key of v.
+func (kb *keyBuffer) sum(v mmdbtype.DataType) ([sha256.Size]byte, error) {
+	kb.Reset()
+	if _, err := v.WriteTo(kb); err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("serializing %T: %w", v, err)
+	}
+	return sha256.Sum256(kb.Bytes()), nil
+}
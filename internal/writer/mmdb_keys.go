@@ -0,0 +1,230 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+// keyFilter prunes and renames the keys of a column's map values as
+// configured by its include_keys, exclude_keys, and rename options. Patterns
+// are paths within the value whose segments are map keys, array indexes, or
+// config.PathWildcard, which matches any key or index.
+type keyFilter struct {
+	include [][]any // Keys kept; other keys of the maps they point into are removed
+	exclude [][]any // Keys removed
+	rename  []keyRename
+}
+
+// keyRename renames the keys matched by path to to.
+type keyRename struct {
+	path []any
+	to   mmdbtype.String
+}
+
+// newKeyFilter returns the key filter of col, or nil if it has none.
+func newKeyFilter(col config.Column) (*keyFilter, error) {
+	if len(col.IncludeKeys) == 0 && len(col.ExcludeKeys) == 0 && len(col.Rename) == 0 {
+		return nil, nil
+	}
+
+	f := &keyFilter{}
+	for _, p := range col.IncludeKeys {
+		pattern, err := normalizeKeyPattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("include_keys: %w", err)
+		}
+		f.include = append(f.include, pattern)
+	}
+	for _, p := range col.ExcludeKeys {
+		pattern, err := normalizeKeyPattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("exclude_keys: %w", err)
+		}
+		f.exclude = append(f.exclude, pattern)
+	}
+	// Sorted so that the last of several renames matching a key wins
+	// deterministically.
+	for _, from := range slices.Sorted(maps.Keys(col.Rename)) {
+		pattern, err := normalizeKeyPattern(config.SplitKeyPath(from))
+		if err != nil {
+			return nil, fmt.Errorf("rename: %w", err)
+		}
+		f.rename = append(f.rename, keyRename{
+			path: pattern,
+			to:   mmdbtype.String(col.Rename[from]),
+		})
+	}
+	return f, nil
+}
+
+// normalizeKeyPattern converts the integer segments of p to int.
+func normalizeKeyPattern(p config.Path) ([]any, error) {
+	if len(p) == 0 {
+		return nil, errors.New("empty key path")
+	}
+	pattern := make([]any, len(p))
+	for i, seg := range p {
+		switch s := seg.(type) {
+		case string, int:
+			pattern[i] = s
+		case int64:
+			pattern[i] = int(s)
+		default:
+			return nil, fmt.Errorf("key path segments must be strings or integers, got %T", seg)
+		}
+	}
+	return pattern, nil
+}
+
+// apply returns value with its keys filtered and renamed. value itself is
+// never modified; the maps and slices that change are copied.
+func (f *keyFilter) apply(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+	filtered, _, err := f.applyAt(value, nil)
+	return filtered, err
+}
+
+// applyAt filters value, found at path within the column value, and reports
+// whether it changed.
+func (f *keyFilter) applyAt(
+	value mmdbtype.DataType,
+	path []any,
+) (mmdbtype.DataType, bool, error) {
+	if !f.appliesWithin(path) {
+		return value, false, nil
+	}
+
+	switch v := value.(type) {
+	case mmdbtype.Map:
+		return f.applyMap(v, path)
+	case mmdbtype.Slice:
+		var filtered mmdbtype.Slice
+		for i, elem := range v {
+			newElem, changed, err := f.applyAt(elem, append(path, i))
+			if err != nil {
+				return nil, false, err
+			}
+			if changed && filtered == nil {
+				filtered = slices.Clone(v)
+			}
+			if filtered != nil {
+				filtered[i] = newElem
+			}
+		}
+		if filtered == nil {
+			return v, false, nil
+		}
+		return filtered, true, nil
+	default:
+		return value, false, nil
+	}
+}
+
+// applyMap filters the keys of m, found at path within the column value, and
+// reports whether it changed.
+func (f *keyFilter) applyMap(m mmdbtype.Map, path []any) (mmdbtype.DataType, bool, error) {
+	restricted := hasPatternAt(f.include, path)
+
+	filtered := make(mmdbtype.Map, len(m))
+	origins := make(map[mmdbtype.String]mmdbtype.String, len(m))
+	changed := false
+	for key, value := range m {
+		keyPath := append(path, string(key))
+		if (restricted && !matchesAny(f.include, keyPath)) || matchesAny(f.exclude, keyPath) {
+			changed = true
+			continue
+		}
+
+		value, valueChanged, err := f.applyAt(value, keyPath)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = changed || valueChanged
+
+		newKey := key
+		for _, r := range f.rename {
+			if matchesPattern(r.path, keyPath) {
+				newKey = r.to
+			}
+		}
+		if newKey != key {
+			changed = true
+		}
+
+		if other, ok := origins[newKey]; ok {
+			first, second := min(key, other), max(key, other)
+			return nil, false, fmt.Errorf(
+				"renaming keys of %s: %q and %q would both be named %q",
+				describeKeyPath(path),
+				string(first),
+				string(second),
+				string(newKey),
+			)
+		}
+		origins[newKey] = key
+		filtered[newKey] = value
+	}
+
+	if !changed {
+		return m, false, nil
+	}
+	return filtered, true, nil
+}
+
+// appliesWithin reports whether a pattern names a key within the value at
+// path.
+func (f *keyFilter) appliesWithin(path []any) bool {
+	within := func(pattern []any) bool {
+		return len(pattern) > len(path) && matchesPattern(pattern[:len(path)], path)
+	}
+	if slices.ContainsFunc(f.include, within) || slices.ContainsFunc(f.exclude, within) {
+		return true
+	}
+	return slices.ContainsFunc(f.rename, func(r keyRename) bool { return within(r.path) })
+}
+
+// hasPatternAt reports whether one of patterns names a key of the map at
+// path.
+func hasPatternAt(patterns [][]any, path []any) bool {
+	return slices.ContainsFunc(patterns, func(pattern []any) bool {
+		return len(pattern) == len(path)+1 && matchesPattern(pattern[:len(path)], path)
+	})
+}
+
+// matchesAny reports whether one of patterns matches path.
+func matchesAny(patterns [][]any, path []any) bool {
+	return slices.ContainsFunc(patterns, func(pattern []any) bool {
+		return matchesPattern(pattern, path)
+	})
+}
+
+// matchesPattern reports whether pattern matches path segment by segment.
+func matchesPattern(pattern, path []any) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, seg := range pattern {
+		if seg != config.PathWildcard && seg != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// describeKeyPath formats a path within a column value for error messages.
+func describeKeyPath(path []any) string {
+	if len(path) == 0 {
+		return "the value"
+	}
+	segments := make([]string, len(path))
+	for i, seg := range path {
+		segments[i] = fmt.Sprint(seg)
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,177 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func TestKeyFilter(t *testing.T) {
+	names := func() mmdbtype.Map {
+		return mmdbtype.Map{
+			"en": mmdbtype.String("Germany"),
+			"de": mmdbtype.String("Deutschland"),
+			"fr": mmdbtype.String("Allemagne"),
+		}
+	}
+	record := func() mmdbtype.Map {
+		return mmdbtype.Map{
+			"country": mmdbtype.Map{
+				"iso_code": mmdbtype.String("DE"),
+				"names":    names(),
+			},
+			"subdivisions": mmdbtype.Slice{
+				mmdbtype.Map{"iso_code": mmdbtype.String("BE"), "names": names()},
+			},
+			"traits": mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		column  config.Column
+		want    mmdbtype.DataType
+		wantErr string
+	}{
+		{
+			name: "include with wildcards",
+			column: config.Column{IncludeKeys: []config.Path{
+				{"*", "names", "en"},
+				{"subdivisions", "*", "names", "de"},
+			}},
+			want: mmdbtype.Map{
+				"country": mmdbtype.Map{
+					"iso_code": mmdbtype.String("DE"),
+					"names":    mmdbtype.Map{"en": mmdbtype.String("Germany")},
+				},
+				"subdivisions": mmdbtype.Slice{
+					mmdbtype.Map{
+						"iso_code": mmdbtype.String("BE"),
+						"names":    mmdbtype.Map{"de": mmdbtype.String("Deutschland")},
+					},
+				},
+				"traits": mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+			},
+		},
+		{
+			name:   "include top-level keys",
+			column: config.Column{IncludeKeys: []config.Path{{"traits"}}},
+			want: mmdbtype.Map{
+				"traits": mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+			},
+		},
+		{
+			name: "exclude",
+			column: config.Column{ExcludeKeys: []config.Path{
+				{"traits"},
+				{"subdivisions", int64(0), "names"},
+				{"country", "names", "fr"},
+			}},
+			want: mmdbtype.Map{
+				"country": mmdbtype.Map{
+					"iso_code": mmdbtype.String("DE"),
+					"names": mmdbtype.Map{
+						"en": mmdbtype.String("Germany"),
+						"de": mmdbtype.String("Deutschland"),
+					},
+				},
+				"subdivisions": mmdbtype.Slice{
+					mmdbtype.Map{"iso_code": mmdbtype.String("BE")},
+				},
+			},
+		},
+		{
+			name: "rename",
+			column: config.Column{
+				ExcludeKeys: []config.Path{{"*", "names"}, {"subdivisions"}},
+				Rename: map[string]string{
+					"traits":           "network_traits",
+					"country.iso_code": "code",
+				},
+			},
+			want: mmdbtype.Map{
+				"country":        mmdbtype.Map{"code": mmdbtype.String("DE")},
+				"network_traits": mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+			},
+		},
+		{
+			name: "rename within array",
+			column: config.Column{
+				IncludeKeys: []config.Path{{"subdivisions"}},
+				ExcludeKeys: []config.Path{{"subdivisions", "*", "names"}},
+				Rename:      map[string]string{"subdivisions.0.iso_code": "code"},
+			},
+			want: mmdbtype.Map{
+				"subdivisions": mmdbtype.Slice{
+					mmdbtype.Map{"code": mmdbtype.String("BE")},
+				},
+			},
+		},
+		{
+			name: "rename collision",
+			column: config.Column{Rename: map[string]string{
+				"country.names.en": "name",
+				"country.names.de": "name",
+			}},
+			wantErr: `renaming keys of country.names: "de" and "en" would both be named "name"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newKeyFilter(tt.column)
+			require.NoError(t, err)
+
+			value := record()
+			got, err := f.apply(value)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, record(), value, "source value must not be modified")
+		})
+	}
+}
+
+func TestNewKeyFilter_None(t *testing.T) {
+	f, err := newKeyFilter(config.Column{Name: "country"})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+}
+
+func TestBuildNestedData_KeyFilters(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{
+				Name:        "record",
+				OutputPath:  &config.Path{},
+				IncludeKeys: []config.Path{{"country"}},
+				Rename:      map[string]string{"country": "registered_country"},
+			},
+		},
+	}
+	filters := make([]*keyFilter, len(cfg.Columns))
+	for i, col := range cfg.Columns {
+		f, err := newKeyFilter(col)
+		require.NoError(t, err)
+		filters[i] = f
+	}
+	writer := &MMDBWriter{config: cfg, keyFilters: filters}
+
+	result, err := writer.buildNestedData([]mmdbtype.DataType{
+		mmdbtype.Map{
+			"country":   mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+			"continent": mmdbtype.Map{"code": mmdbtype.String("EU")},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{
+		"registered_country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+	}, result)
+}
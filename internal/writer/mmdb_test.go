@@ -1,9 +1,15 @@
 package writer
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -88,7 +94,7 @@ func TestMergeNestedValue_EmptyPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := mergeNestedValue(tt.root, []any{}, tt.value)
+			result, err := mergeNestedValue(tt.root, []any{}, tt.value, "")
 
 			if tt.expectErr {
 				require.Error(t, err)
@@ -186,7 +192,7 @@ func TestMergeNestedValue_MapMergingAtPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := mergeNestedValue(tt.root, tt.path, tt.value)
+			result, err := mergeNestedValue(tt.root, tt.path, tt.value, "")
 
 			if tt.expectErr {
 				require.Error(t, err)
@@ -310,7 +316,7 @@ func TestMergeMaps(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := mergeMaps(tt.dest, tt.source)
+			result, err := mergeMaps(tt.dest, tt.source, "")
 
 			if tt.expectErr {
 				require.Error(t, err)
@@ -371,6 +377,16 @@ func TestBuildNestedData_EmptyPath(t *testing.T) {
 	assert.Equal(t, expected, result)
 }
 
+func TestBuildNestedData_DefaultPath(t *testing.T) {
+	writer := &MMDBWriter{
+		config: &config.Config{Columns: []config.Column{{Name: "country"}}},
+	}
+
+	result, err := writer.buildNestedData([]mmdbtype.DataType{mmdbtype.String("US")})
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{mmdbtype.String("country"): mmdbtype.String("US")}, result)
+}
+
 func TestMergeMaps_NoMutation(t *testing.T) {
 	// Test that mergeMaps doesn't mutate the input maps
 	original := mmdbtype.Map{
@@ -389,14 +405,14 @@ func TestMergeMaps_NoMutation(t *testing.T) {
 	source := original
 
 	// Merge same source reference into two different destinations
-	result1, err := mergeMaps(dest1, source)
+	result1, err := mergeMaps(dest1, source, "")
 	require.NoError(t, err)
 
 	// Verify original source map was not mutated by first merge
 	assert.Equal(t, expectedOriginal, original)
 
 	// Second merge with same source reference should succeed because source wasn't mutated
-	result2, err := mergeMaps(dest2, source)
+	result2, err := mergeMaps(dest2, source, "")
 	require.NoError(t, err)
 
 	// Verify original source map still not mutated
@@ -423,13 +439,13 @@ func TestMergeNestedValue_NoMutation(t *testing.T) {
 
 	// First write
 	root1 := make(mmdbtype.Map)
-	result1, err := mergeNestedValue(root1, []any{"traits"}, sharedMap)
+	result1, err := mergeNestedValue(root1, []any{"traits"}, sharedMap, "")
 	require.NoError(t, err)
 	assert.NotNil(t, result1)
 
 	// Second write with SAME reference (simulates accumulator reuse)
 	root2 := make(mmdbtype.Map)
-	result2, err := mergeNestedValue(root2, []any{"traits"}, sharedMap)
+	result2, err := mergeNestedValue(root2, []any{"traits"}, sharedMap, "")
 	require.NoError(t, err)
 	assert.NotNil(t, result2)
 
@@ -486,3 +502,538 @@ func TestBuildNestedData_MapMergingAtPath(t *testing.T) {
 
 	assert.Equal(t, expected, result)
 }
+
+func TestBuildNestedData_SharedColumnValues(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "all", Path: config.Path{}, OutputPath: &config.Path{}},
+			{Name: "traits", Path: config.Path{}, OutputPath: &config.Path{"traits"}},
+			{Name: "isp", OutputPath: &config.Path{"traits", "isp"}},
+		},
+	}
+	writer := &MMDBWriter{config: cfg}
+
+	// The readers return the same maps for every network of a record, so the
+	// builder must not write into them.
+	all := mmdbtype.Map{
+		mmdbtype.String("traits"): mmdbtype.Map{
+			mmdbtype.String("is_anonymous"): mmdbtype.Bool(true),
+		},
+	}
+	traits := mmdbtype.Map{mmdbtype.String("is_vpn"): mmdbtype.Bool(false)}
+
+	for _, isp := range []string{"Cable Co", "Fiber Co"} {
+		result, err := writer.buildNestedData([]mmdbtype.DataType{
+			all,
+			traits,
+			mmdbtype.String(isp),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, mmdbtype.Map{
+			mmdbtype.String("traits"): mmdbtype.Map{
+				mmdbtype.String("is_anonymous"): mmdbtype.Bool(true),
+				mmdbtype.String("is_vpn"):       mmdbtype.Bool(false),
+				mmdbtype.String("isp"):          mmdbtype.String(isp),
+			},
+		}, result)
+	}
+
+	assert.Equal(t, mmdbtype.Map{
+		mmdbtype.String("traits"): mmdbtype.Map{
+			mmdbtype.String("is_anonymous"): mmdbtype.Bool(true),
+		},
+	}, all)
+	assert.Equal(t, mmdbtype.Map{mmdbtype.String("is_vpn"): mmdbtype.Bool(false)}, traits)
+}
+
+func TestValueInterner(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "city", OutputPath: &config.Path{"city"}},
+			{Name: "country", OutputPath: &config.Path{"country", "iso_code"}},
+			{Name: "continent", Path: config.Path{}, OutputPath: &config.Path{"continent"}},
+		},
+	}
+	writer := &MMDBWriter{config: cfg, interner: newValueInterner()}
+
+	continent := mmdbtype.Map{mmdbtype.String("code"): mmdbtype.String("NA")}
+	record := func(city string, continent mmdbtype.Map) mmdbtype.Map {
+		t.Helper()
+		r, err := writer.Record([]mmdbtype.DataType{
+			mmdbtype.String(city),
+			mmdbtype.String("US"),
+			continent,
+		})
+		require.NoError(t, err)
+		return r
+	}
+	dallas := record("Dallas", continent)
+	austin := record("Austin", continent.Copy().(mmdbtype.Map))
+
+	assert.Equal(t, mmdbtype.Map{
+		mmdbtype.String("city"): mmdbtype.String("Austin"),
+		mmdbtype.String("country"): mmdbtype.Map{
+			mmdbtype.String("iso_code"): mmdbtype.String("US"),
+		},
+		mmdbtype.String("continent"): continent,
+	}, austin)
+
+	// The identical country and continent maps of different records are the
+	// same instance. Column values become the shared instance as they are.
+	pointer := func(v mmdbtype.DataType) uintptr {
+		return reflect.ValueOf(v).Pointer()
+	}
+	for _, key := range []mmdbtype.String{"country", "continent"} {
+		assert.Equal(t, pointer(dallas[key]), pointer(austin[key]), key)
+	}
+	assert.Equal(t, pointer(continent), pointer(austin["continent"]))
+
+	// Values of different types are kept apart even if they are equal as
+	// numbers.
+	u16, err := writer.interner.intern(mmdbtype.Slice{mmdbtype.Uint16(1)}, nil)
+	require.NoError(t, err)
+	u32, err := writer.interner.intern(mmdbtype.Slice{mmdbtype.Uint32(1)}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Slice{mmdbtype.Uint16(1)}, u16)
+	assert.Equal(t, mmdbtype.Slice{mmdbtype.Uint32(1)}, u32)
+}
+
+func TestMMDBWriter_LowMemory(t *testing.T) {
+	recordSize := 28
+	includeReserved := false
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			MMDB: config.MMDBConfig{
+				DatabaseType:            "Test-DB",
+				RecordSize:              &recordSize,
+				IncludeReservedNetworks: &includeReserved,
+				LowMemory:               true,
+			},
+		},
+		Columns: []config.Column{
+			{Name: "country", Path: config.Path{}, OutputPath: &config.Path{"country"}},
+			{Name: "city", OutputPath: &config.Path{"city", "name"}},
+		},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.mmdb")
+	writer, err := NewMMDBWriter(outputPath, cfg, 6, nil)
+	require.NoError(t, err)
+	require.NotNil(t, writer.interner)
+
+	country := mmdbtype.Map{mmdbtype.String("iso_code"): mmdbtype.String("US")}
+	cities := map[string]string{
+		"1.0.0.0/24": "Dallas",
+		"1.0.1.0/24": "Austin",
+		"1.0.2.0/24": "Dallas",
+	}
+	for prefix, city := range cities {
+		require.NoError(t, writer.WriteRow(
+			netip.MustParsePrefix(prefix),
+			[]mmdbtype.DataType{country, mmdbtype.String(city)},
+		))
+	}
+	require.NoError(t, writer.Flush())
+
+	reader, err := maxminddb.Open(outputPath)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	for prefix, city := range cities {
+		var got map[string]any
+		addr := netip.MustParsePrefix(prefix).Addr()
+		require.NoError(t, reader.Lookup(addr).Decode(&got))
+		assert.Equal(t, map[string]any{
+			"country": map[string]any{"iso_code": "US"},
+			"city":    map[string]any{"name": city},
+		}, got, prefix)
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	// Two full records that both contain traits, as when copying two
+	// databases with path = [] into the root.
+	first := mmdbtype.Map{
+		mmdbtype.String("traits"): mmdbtype.Map{
+			mmdbtype.String("network_type"): mmdbtype.String("cable"),
+			mmdbtype.String("is_anycast"):   mmdbtype.Bool(false),
+		},
+		mmdbtype.String("sources"): mmdbtype.String("enterprise"),
+	}
+	second := mmdbtype.Map{
+		mmdbtype.String("traits"): mmdbtype.Map{
+			mmdbtype.String("network_type"): mmdbtype.String("mobile"),
+			mmdbtype.String("is_anonymous"): mmdbtype.Bool(true),
+		},
+		mmdbtype.String("sources"): mmdbtype.Slice{mmdbtype.String("anonymous")},
+	}
+
+	tests := []struct {
+		strategy    string
+		expected    mmdbtype.Map
+		errContains string
+	}{
+		{
+			strategy:    config.MergeStrategyError,
+			errContains: "field conflict",
+		},
+		{
+			strategy: config.MergeStrategyKeepFirst,
+			expected: first,
+		},
+		{
+			strategy: config.MergeStrategyOverwrite,
+			expected: second,
+		},
+		{
+			strategy: config.MergeStrategyDeepMerge,
+			expected: mmdbtype.Map{
+				mmdbtype.String("traits"): mmdbtype.Map{
+					mmdbtype.String("network_type"): mmdbtype.String("mobile"),
+					mmdbtype.String("is_anycast"):   mmdbtype.Bool(false),
+					mmdbtype.String("is_anonymous"): mmdbtype.Bool(true),
+				},
+				mmdbtype.String("sources"): mmdbtype.Slice{mmdbtype.String("anonymous")},
+			},
+		},
+		{
+			strategy: config.MergeStrategyAppend,
+			expected: mmdbtype.Map{
+				mmdbtype.String("traits"): mmdbtype.Map{
+					mmdbtype.String("network_type"): mmdbtype.Slice{
+						mmdbtype.String("cable"),
+						mmdbtype.String("mobile"),
+					},
+					mmdbtype.String("is_anycast"):   mmdbtype.Bool(false),
+					mmdbtype.String("is_anonymous"): mmdbtype.Bool(true),
+				},
+				mmdbtype.String("sources"): mmdbtype.Slice{
+					mmdbtype.String("enterprise"),
+					mmdbtype.String("anonymous"),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			writer := &MMDBWriter{config: &config.Config{
+				Columns: []config.Column{
+					{Name: "enterprise", Path: config.Path{}, OutputPath: &config.Path{}},
+					{
+						Name:          "anonymous",
+						Path:          config.Path{},
+						OutputPath:    &config.Path{},
+						MergeStrategy: tt.strategy,
+					},
+				},
+			}}
+
+			result, err := writer.buildNestedData([]mmdbtype.DataType{first, second})
+			if tt.errContains != "" {
+				require.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestMergeNestedValue_Strategies(t *testing.T) {
+	root := mmdbtype.Map{
+		mmdbtype.String("city"): mmdbtype.String("Berlin"),
+	}
+
+	tests := []struct {
+		strategy    string
+		path        []any
+		expected    mmdbtype.Map
+		errContains string
+	}{
+		{
+			strategy:    "",
+			path:        []any{"city"},
+			errContains: "field conflict at path [city]",
+		},
+		{
+			strategy: config.MergeStrategyKeepFirst,
+			path:     []any{"city"},
+			expected: root,
+		},
+		{
+			strategy: config.MergeStrategyOverwrite,
+			path:     []any{"city"},
+			expected: mmdbtype.Map{mmdbtype.String("city"): mmdbtype.String("Munich")},
+		},
+		{
+			strategy: config.MergeStrategyAppend,
+			path:     []any{"city"},
+			expected: mmdbtype.Map{mmdbtype.String("city"): mmdbtype.Slice{
+				mmdbtype.String("Berlin"),
+				mmdbtype.String("Munich"),
+			}},
+		},
+		{
+			strategy:    config.MergeStrategyError,
+			path:        []any{"city", "name"},
+			errContains: "path conflict at city",
+		},
+		{
+			strategy: config.MergeStrategyKeepFirst,
+			path:     []any{"city", "name"},
+			expected: root,
+		},
+		{
+			strategy: config.MergeStrategyDeepMerge,
+			path:     []any{"city", "name"},
+			expected: mmdbtype.Map{mmdbtype.String("city"): mmdbtype.Map{
+				mmdbtype.String("name"): mmdbtype.String("Munich"),
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.strategy, tt.path), func(t *testing.T) {
+			result, err := mergeNestedValue(root, tt.path, mmdbtype.String("Munich"), tt.strategy)
+			if tt.errContains != "" {
+				require.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+			assert.Equal(t, mmdbtype.String("Berlin"), root[mmdbtype.String("city")])
+		})
+	}
+}
+
+func TestMergeNestedValue_ArrayIndexes(t *testing.T) {
+	subdivision := func(isoCode string) mmdbtype.Map {
+		return mmdbtype.Map{mmdbtype.String("iso_code"): mmdbtype.String(isoCode)}
+	}
+	root := mmdbtype.Map{
+		mmdbtype.String("subdivisions"): mmdbtype.Slice{subdivision("BY")},
+		mmdbtype.String("country"):      mmdbtype.String("DE"),
+	}
+
+	tests := []struct {
+		name        string
+		path        []any
+		strategy    string
+		expected    mmdbtype.Map
+		errContains string
+	}{
+		{
+			name: "new array",
+			path: []any{"regions", int64(0), "iso_code"},
+			expected: mmdbtype.Map{
+				mmdbtype.String("subdivisions"): mmdbtype.Slice{subdivision("BY")},
+				mmdbtype.String("country"):      mmdbtype.String("DE"),
+				mmdbtype.String("regions"):      mmdbtype.Slice{subdivision("BE")},
+			},
+		},
+		{
+			name: "extend array",
+			path: []any{"subdivisions", int64(1), "iso_code"},
+			expected: mmdbtype.Map{
+				mmdbtype.String("subdivisions"): mmdbtype.Slice{
+					subdivision("BY"),
+					subdivision("BE"),
+				},
+				mmdbtype.String("country"): mmdbtype.String("DE"),
+			},
+		},
+		{
+			name: "existing element",
+			path: []any{"subdivisions", 0, "name"},
+			expected: mmdbtype.Map{
+				mmdbtype.String("subdivisions"): mmdbtype.Slice{
+					mmdbtype.Map{
+						mmdbtype.String("iso_code"): mmdbtype.String("BY"),
+						mmdbtype.String("name"):     mmdbtype.String("BE"),
+					},
+				},
+				mmdbtype.String("country"): mmdbtype.String("DE"),
+			},
+		},
+		{
+			name: "array element value",
+			path: []any{"codes", int64(0)},
+			expected: mmdbtype.Map{
+				mmdbtype.String("subdivisions"): mmdbtype.Slice{subdivision("BY")},
+				mmdbtype.String("country"):      mmdbtype.String("DE"),
+				mmdbtype.String("codes"):        mmdbtype.Slice{mmdbtype.String("BE")},
+			},
+		},
+		{
+			name:        "conflicting element",
+			path:        []any{"subdivisions", int64(0), "iso_code"},
+			errContains: "field conflict at path [subdivisions 0 iso_code]",
+		},
+		{
+			name:        "index past the end",
+			path:        []any{"subdivisions", int64(2), "iso_code"},
+			errContains: "array index 2 at path [subdivisions 2] is out of range: the array has 1 elements",
+		},
+		{
+			name:        "index into non-array",
+			path:        []any{"country", int64(0)},
+			errContains: "path conflict at country: expected array, got mmdbtype.String",
+		},
+		{
+			name:     "index into non-array with keep_first",
+			path:     []any{"country", int64(0)},
+			strategy: config.MergeStrategyKeepFirst,
+			expected: root,
+		},
+		{
+			name:     "index into non-array with overwrite",
+			path:     []any{"country", int64(0)},
+			strategy: config.MergeStrategyOverwrite,
+			expected: mmdbtype.Map{
+				mmdbtype.String("subdivisions"): mmdbtype.Slice{subdivision("BY")},
+				mmdbtype.String("country"):      mmdbtype.Slice{mmdbtype.String("BE")},
+			},
+		},
+		{
+			name:        "index at the root",
+			path:        []any{int64(0)},
+			errContains: "cannot index the record with 0: the root is a map",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := mergeNestedValue(root, tt.path, mmdbtype.String("BE"), tt.strategy)
+			if tt.errContains != "" {
+				require.ErrorContains(t, err, tt.errContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+			assert.Equal(t, mmdbtype.Slice{subdivision("BY")}, root[mmdbtype.String("subdivisions")])
+		})
+	}
+}
+
+func TestBuildNestedData_ArrayIndexes(t *testing.T) {
+	writer := &MMDBWriter{
+		config: &config.Config{
+			Columns: []config.Column{
+				{Name: "sub1", OutputPath: &config.Path{"subdivisions", int64(0), "iso_code"}},
+				{Name: "sub2", OutputPath: &config.Path{"subdivisions", int64(1), "iso_code"}},
+				{Name: "sub1_name", OutputPath: &config.Path{"subdivisions", int64(0), "names", "en"}},
+			},
+		},
+	}
+
+	result, err := writer.buildNestedData([]mmdbtype.DataType{
+		mmdbtype.String("ENG"),
+		mmdbtype.String("WSM"),
+		mmdbtype.String("England"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{
+		mmdbtype.String("subdivisions"): mmdbtype.Slice{
+			mmdbtype.Map{
+				mmdbtype.String("iso_code"): mmdbtype.String("ENG"),
+				mmdbtype.String("names"): mmdbtype.Map{
+					mmdbtype.String("en"): mmdbtype.String("England"),
+				},
+			},
+			mmdbtype.Map{mmdbtype.String("iso_code"): mmdbtype.String("WSM")},
+		},
+	}, result)
+
+	_, err = writer.buildNestedData([]mmdbtype.DataType{nil, mmdbtype.String("WSM"), nil})
+	require.ErrorContains(t, err, "setting column sub2: array index 1 at path [subdivisions 1]")
+}
+
+func TestMMDBWriter_Provenance(t *testing.T) {
+	recordSize := 28
+	includeReserved := false
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			MMDB: config.MMDBConfig{
+				DatabaseType:            "Test-DB",
+				Description:             map[string]string{"en": "Test database"},
+				RecordSize:              &recordSize,
+				IncludeReservedNetworks: &includeReserved,
+			},
+		},
+		Columns: []config.Column{{Name: "country", OutputPath: &config.Path{"country"}}},
+	}
+	provenance := &Provenance{
+		Version:      "1.0.0",
+		ConfigSHA256: "abc123",
+		Databases: []SourceDatabase{
+			{Name: "city", DatabaseType: "GeoIP2-City", BuildEpoch: 1700000000, IPVersion: 6},
+		},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.mmdb")
+	writer, err := NewMMDBWriter(outputPath, cfg, 6, provenance)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("1.0.0.0/24"),
+		[]mmdbtype.DataType{mmdbtype.String("AU")},
+	))
+	require.NoError(t, writer.Flush())
+
+	reader, err := maxminddb.Open(outputPath)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	description := reader.Metadata.Description
+	assert.Equal(t, "Test database", description["en"])
+
+	var got Provenance
+	require.NoError(t, json.Unmarshal([]byte(description[ProvenanceKey]), &got))
+	assert.Equal(t, *provenance, got)
+
+	// The configured description is not modified.
+	assert.Equal(t, map[string]string{"en": "Test database"}, cfg.Output.MMDB.Description)
+}
+
+func TestMMDBWriter_TreeOptions(t *testing.T) {
+	for _, disableAliasing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disable_ipv4_aliasing=%t", disableAliasing), func(t *testing.T) {
+			recordSize := 28
+			includeReserved := false
+			cfg := &config.Config{
+				Output: config.OutputConfig{
+					MMDB: config.MMDBConfig{
+						DatabaseType:            "Test-DB",
+						RecordSize:              &recordSize,
+						IncludeReservedNetworks: &includeReserved,
+						DisableIPv4Aliasing:     disableAliasing,
+						DisableMetadataPointers: true,
+						BuildEpoch:              1700000000,
+					},
+				},
+				Columns: []config.Column{{Name: "country"}},
+			}
+
+			outputPath := filepath.Join(t.TempDir(), "output.mmdb")
+			writer, err := NewMMDBWriter(outputPath, cfg, 6, nil)
+			require.NoError(t, err)
+			require.NoError(t, writer.WriteRow(
+				netip.MustParsePrefix("1.0.0.0/24"),
+				[]mmdbtype.DataType{mmdbtype.String("AU")},
+			))
+			require.NoError(t, writer.Flush())
+
+			reader, err := maxminddb.Open(outputPath)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			assert.Equal(t, uint(1700000000), reader.Metadata.BuildEpoch)
+
+			// IPv4-mapped IPv6 addresses only find the IPv4 data through the
+			// ::ffff:0:0/96 alias.
+			result := reader.Lookup(netip.MustParseAddr("::ffff:1.0.0.1"))
+			require.NoError(t, result.Err())
+			assert.Equal(t, !disableAliasing, result.Found())
+		})
+	}
+}
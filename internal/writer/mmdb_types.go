@@ -0,0 +1,191 @@
+package writer
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/maxmind/mmdbconvert/internal/expr"
+)
+
+// convertMMDBValue converts value to the MMDB type named by typeHint. Arrays
+// are converted element by element. Conversions that would change the value,
+// such as a negative number to an unsigned type or 2.5 to an integer, fail.
+func convertMMDBValue(value mmdbtype.DataType, typeHint string) (mmdbtype.DataType, error) {
+	if s, ok := value.(mmdbtype.Slice); ok {
+		converted := make(mmdbtype.Slice, len(s))
+		for i, v := range s {
+			c, err := convertMMDBValue(v, typeHint)
+			if err != nil {
+				return nil, fmt.Errorf("array element %d: %w", i, err)
+			}
+			converted[i] = c
+		}
+		return converted, nil
+	}
+
+	switch typeHint {
+	case "string":
+		s, err := expr.FormatScalar(value)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbtype.String(s), nil
+	case "bool":
+		return toMMDBBool(value)
+	case "uint16":
+		i, err := toMMDBInteger(value, typeHint, 0, math.MaxUint16)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbtype.Uint16(i.Uint64()), nil //nolint:gosec // G115: range checked above
+	case "uint32":
+		i, err := toMMDBInteger(value, typeHint, 0, math.MaxUint32)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbtype.Uint32(i.Uint64()), nil //nolint:gosec // G115: range checked above
+	case "uint64":
+		i, err := toMMDBInteger(value, typeHint, 0, math.MaxUint64)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbtype.Uint64(i.Uint64()), nil
+	case "int32":
+		i, err := toMMDBInteger(value, typeHint, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbtype.Int32(i.Int64()), nil //nolint:gosec // G115: range checked above
+	case "float32":
+		if f32, ok := value.(mmdbtype.Float32); ok {
+			return f32, nil
+		}
+		f, err := toMMDBFloat(value, typeHint)
+		if err != nil {
+			return nil, err
+		}
+		// A float32 cannot hold most decimal fractions exactly either, so
+		// the conversion is lossy if the float32 reads as a different
+		// decimal number, as 37.751 does not but 37.7510001 does.
+		f32 := float32(f)
+		if strconv.FormatFloat(float64(f32), 'g', -1, 32) !=
+			strconv.FormatFloat(f, 'g', -1, 64) {
+			return nil, fmt.Errorf("value %v cannot be represented as float32", f)
+		}
+		return mmdbtype.Float32(f32), nil
+	case "double":
+		f, err := toMMDBFloat(value, typeHint)
+		if err != nil {
+			return nil, err
+		}
+		return mmdbtype.Float64(f), nil
+	default:
+		return nil, fmt.Errorf("unknown type hint: %s", typeHint)
+	}
+}
+
+// toMMDBBool converts a bool, the integers 0 and 1, or the strings "true"
+// and "false" to a bool.
+func toMMDBBool(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+	switch v := value.(type) {
+	case mmdbtype.Bool:
+		return v, nil
+	case mmdbtype.String:
+		switch v {
+		case "true":
+			return mmdbtype.Bool(true), nil
+		case "false":
+			return mmdbtype.Bool(false), nil
+		}
+		return nil, fmt.Errorf("string %q cannot be converted to bool", string(v))
+	}
+
+	i, err := toMMDBInteger(value, "bool", 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	return mmdbtype.Bool(i.Sign() != 0), nil
+}
+
+// toMMDBInteger converts an integer, a float without a fractional part, or a
+// string holding an integer to an integer in [minValue, maxValue]. typeName
+// is the hinted type, used in error messages.
+func toMMDBInteger(
+	value mmdbtype.DataType,
+	typeName string,
+	minValue int64,
+	maxValue uint64,
+) (*big.Int, error) {
+	i, ok := bigInteger(value)
+	if !ok {
+		switch v := value.(type) {
+		case mmdbtype.Float32, mmdbtype.Float64:
+			f, _ := toFloat64(v)
+			if math.IsInf(f, 0) || math.IsNaN(f) || f != math.Trunc(f) {
+				return nil, fmt.Errorf("value %v cannot be converted to %s", f, typeName)
+			}
+			i, _ = big.NewFloat(f).Int(nil)
+		case mmdbtype.String:
+			if i, ok = new(big.Int).SetString(string(v), 10); !ok {
+				return nil, fmt.Errorf("string %q cannot be converted to %s", string(v), typeName)
+			}
+		default:
+			return nil, fmt.Errorf("cannot convert %T to %s", value, typeName)
+		}
+	}
+
+	if i.Cmp(big.NewInt(minValue)) < 0 || i.Cmp(new(big.Int).SetUint64(maxValue)) > 0 {
+		return nil, fmt.Errorf("value %s overflows %s", i.String(), typeName)
+	}
+	return i, nil
+}
+
+// toMMDBFloat converts a float, an integer that a double holds exactly, or a
+// string holding a number to a float64. typeName is the hinted type, used in
+// error messages.
+func toMMDBFloat(value mmdbtype.DataType, typeName string) (float64, error) {
+	switch v := value.(type) {
+	case mmdbtype.Float32:
+		return float64(v), nil
+	case mmdbtype.Float64:
+		return float64(v), nil
+	case mmdbtype.String:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("string %q cannot be converted to %s", string(v), typeName)
+		}
+		return f, nil
+	}
+
+	i, ok := bigInteger(value)
+	if !ok {
+		return 0, fmt.Errorf("cannot convert %T to %s", value, typeName)
+	}
+	f, accuracy := new(big.Float).SetInt(i).Float64()
+	if accuracy != big.Exact {
+		return 0, fmt.Errorf("value %s cannot be represented as %s", i.String(), typeName)
+	}
+	return f, nil
+}
+
+// bigInteger returns value as a big.Int if it is one of the integer types.
+func bigInteger(value mmdbtype.DataType) (*big.Int, bool) {
+	switch v := value.(type) {
+	case mmdbtype.Int32:
+		return big.NewInt(int64(v)), true
+	case mmdbtype.Uint16:
+		return new(big.Int).SetUint64(uint64(v)), true
+	case mmdbtype.Uint32:
+		return new(big.Int).SetUint64(uint64(v)), true
+	case mmdbtype.Uint64:
+		return new(big.Int).SetUint64(uint64(v)), true
+	case *mmdbtype.Uint128:
+		return (*big.Int)(v), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,123 @@
+package writer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func TestConvertMMDBValue(t *testing.T) {
+	uint128 := func(s string) *mmdbtype.Uint128 {
+		i, ok := new(big.Int).SetString(s, 10)
+		require.True(t, ok)
+		return (*mmdbtype.Uint128)(i)
+	}
+
+	tests := []struct {
+		name     string
+		value    mmdbtype.DataType
+		typeHint string
+		want     mmdbtype.DataType
+		wantErr  string
+	}{
+		{"uint16 to uint32", mmdbtype.Uint16(100), "uint32", mmdbtype.Uint32(100), ""},
+		{"uint64 to uint16", mmdbtype.Uint64(65535), "uint16", mmdbtype.Uint16(65535), ""},
+		{"uint128 to uint64", uint128("42"), "uint64", mmdbtype.Uint64(42), ""},
+		{"integral double to uint32", mmdbtype.Float64(1000), "uint32", mmdbtype.Uint32(1000), ""},
+		{"string to int32", mmdbtype.String("-17"), "int32", mmdbtype.Int32(-17), ""},
+		{"int32 to double", mmdbtype.Int32(-5), "double", mmdbtype.Float64(-5), ""},
+		{"double to float32", mmdbtype.Float64(37.751), "float32", mmdbtype.Float32(37.751), ""},
+		{"float32 to float32", mmdbtype.Float32(0.1), "float32", mmdbtype.Float32(0.1), ""},
+		{"string to double", mmdbtype.String("2.5"), "double", mmdbtype.Float64(2.5), ""},
+		{"bool to string", mmdbtype.Bool(true), "string", mmdbtype.String("true"), ""},
+		{"uint32 to string", mmdbtype.Uint32(7), "string", mmdbtype.String("7"), ""},
+		{"one to bool", mmdbtype.Uint16(1), "bool", mmdbtype.Bool(true), ""},
+		{"string to bool", mmdbtype.String("false"), "bool", mmdbtype.Bool(false), ""},
+		{
+			"array elements",
+			mmdbtype.Slice{mmdbtype.Uint64(1), mmdbtype.String("2")},
+			"uint16",
+			mmdbtype.Slice{mmdbtype.Uint16(1), mmdbtype.Uint16(2)},
+			"",
+		},
+		{"uint32 overflows uint16", mmdbtype.Uint32(65536), "uint16", nil, "value 65536 overflows uint16"},
+		{"negative to uint32", mmdbtype.Int32(-1), "uint32", nil, "value -1 overflows uint32"},
+		{"uint128 overflows uint64", uint128("18446744073709551616"), "uint64", nil, "overflows uint64"},
+		{"fraction to uint32", mmdbtype.Float64(2.5), "uint32", nil, "value 2.5 cannot be converted to uint32"},
+		{"non-numeric string", mmdbtype.String("abc"), "uint32", nil, `string "abc" cannot be converted to uint32`},
+		{
+			"double loses float32 precision",
+			mmdbtype.Float64(37.7510001),
+			"float32",
+			nil,
+			"value 37.7510001 cannot be represented as float32",
+		},
+		{
+			"uint64 loses double precision",
+			mmdbtype.Uint64(1<<53 + 1),
+			"double",
+			nil,
+			"value 9007199254740993 cannot be represented as double",
+		},
+		{"two to bool", mmdbtype.Uint32(2), "bool", nil, "value 2 overflows bool"},
+		{"bool to uint32", mmdbtype.Bool(true), "uint32", nil, "cannot convert mmdbtype.Bool to uint32"},
+		{"map to string", mmdbtype.Map{}, "string", nil, "cannot convert map to string"},
+		{
+			"array element error",
+			mmdbtype.Slice{mmdbtype.Uint16(1), mmdbtype.Int32(-1)},
+			"uint16",
+			nil,
+			"array element 1: value -1 overflows uint16",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertMMDBValue(tt.value, tt.typeHint)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuildNestedData_TypeHints(t *testing.T) {
+	writer := &MMDBWriter{
+		config: &config.Config{
+			Columns: []config.Column{
+				{
+					Name:       "accuracy_radius",
+					OutputPath: &config.Path{"location", "accuracy_radius"},
+					Type:       "uint16",
+				},
+				{Name: "is_anonymous", Type: "string"},
+			},
+		},
+	}
+
+	result, err := writer.buildNestedData([]mmdbtype.DataType{
+		mmdbtype.Uint32(100),
+		mmdbtype.Bool(true),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{
+		mmdbtype.String("location"): mmdbtype.Map{
+			mmdbtype.String("accuracy_radius"): mmdbtype.Uint16(100),
+		},
+		mmdbtype.String("is_anonymous"): mmdbtype.String("true"),
+	}, result)
+
+	_, err = writer.buildNestedData([]mmdbtype.DataType{mmdbtype.Uint32(70000), nil})
+	require.ErrorContains(
+		t,
+		err,
+		"converting column accuracy_radius to uint16: value 70000 overflows uint16",
+	)
+}
@@ -4,13 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"net/netip"
+	"reflect"
+	"slices"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/parquet-go/parquet-go"
 	"github.com/parquet-go/parquet-go/compress"
-	"go4.org/netipx"
+	"github.com/parquet-go/parquet-go/encoding"
 
 	"github.com/maxmind/mmdbconvert/internal/config"
 	"github.com/maxmind/mmdbconvert/internal/network"
@@ -31,13 +32,37 @@ const (
 
 // ParquetWriter writes merged MMDB data to Parquet format.
 type ParquetWriter struct {
-	writer       *parquet.GenericWriter[map[string]any]
-	config       *config.Config
-	schema       *parquet.Schema
-	rowGroupSize int
-	rowCount     int
-	ipVersion    int
-	hasBucket    bool
+	writer        *parquet.Writer
+	out           io.Writer
+	options       []parquet.WriterOption // Writer options other than the schema
+	config        *config.Config
+	schema        *parquet.Schema
+	rowGroupSize  int          // Rows per row group, or 0 for no row limit
+	rowGroupBytes int64        // Approximate bytes per row group, or 0 for no byte limit
+	rowCount      int          // Rows in the current row group
+	totalRows     int64        // Rows written to the file
+	rowGroupStart int64        // Estimated file size when the current row group began
+	cutPending    bool         // The row group is full and is cut at the next boundary
+	lastPrefix    netip.Prefix // Network of the last row written
+	ipVersion     int
+	hasBucket     bool
+	listColumns   []bool          // Whether each data column is a LIST column
+	nestedTypes   []*nestedType   // Inferred type of each nested column, nil for other columns
+	sampleRows    int             // Rows to buffer before inferring nested types
+	pending       []pendingRow    // Rows buffered until nested types are inferred
+	fields        []fieldWriter   // Writer of each schema field, in schema order
+	batch         []parquet.Row   // Rows not yet passed to the Parquet writer
+	values        []parquet.Value // Values of the rows in batch
+	scratch       []byte          // Byte array values of the rows in batch
+	batchBytes    int64           // Estimated size of the rows in batch
+	warnings      io.Writer       // Receives warnings about values not written as is
+}
+
+// pendingRow is a row buffered while the schema of nested columns is not yet
+// known.
+type pendingRow struct {
+	prefix netip.Prefix
+	data   []mmdbtype.DataType
 }
 
 // NewParquetWriter creates a new Parquet writer.
@@ -47,55 +72,200 @@ func NewParquetWriter(w io.Writer, cfg *config.Config) (*ParquetWriter, error) {
 
 // NewParquetWriterWithIPVersion creates a Parquet writer scoped to a specific IP version.
 // ipVersion should be 0 (mixed), 4, or 6.
+//
+// If any column has a nested type hint ("struct", "list", "map", or "auto"),
+// the schema is built once the first output.parquet.schema_sample_rows rows
+// have been buffered and the types of those columns inferred from them,
+// unless the types are set up front with SetNestedTypes.
 func NewParquetWriterWithIPVersion(
 	w io.Writer,
 	cfg *config.Config,
 	ipVersion int,
 ) (*ParquetWriter, error) {
-	// Build schema from config
-	schema, err := buildSchema(cfg, ipVersion)
-	if err != nil {
-		return nil, fmt.Errorf("building Parquet schema: %w", err)
-	}
-
 	// Get compression codec
 	codec, err := getCompressionCodec(cfg.Output.Parquet.Compression)
 	if err != nil {
 		return nil, fmt.Errorf("getting compression codec: %w", err)
 	}
 
-	// Build writer options: schema, compression, and optional sorting metadata
+	// Build writer options: compression, page layout, bloom filters, and
+	// optional sorting metadata
 	opts := []parquet.WriterOption{
-		schema,
 		parquet.Compression(codec),
 	}
+	opts = append(opts, pageOptions(cfg)...)
 	if sortOpt := determineSortingColumns(cfg); sortOpt != nil {
 		opts = append(opts, sortOpt)
 	}
 
+	const defaultSchemaSampleRows = 10000
+	sampleRows := cfg.Output.Parquet.SchemaSampleRows
+	if sampleRows <= 0 {
+		sampleRows = defaultSchemaSampleRows
+	}
+
+	listColumns := make([]bool, len(cfg.Columns))
+	nestedTypes := make([]*nestedType, len(cfg.Columns))
+	hasNested := false
+	for i, col := range cfg.Columns {
+		listColumns[i] = col.IsList()
+		if isNestedTypeHint(col.Type) {
+			nestedTypes[i] = newNestedType(col)
+			hasNested = true
+		}
+	}
+
+	writer := &ParquetWriter{
+		out:           w,
+		options:       opts,
+		config:        cfg,
+		rowGroupSize:  cfg.Output.Parquet.RowGroupSize,
+		rowGroupBytes: int64(cfg.Output.Parquet.RowGroupBytes),
+		ipVersion:     ipVersion,
+		hasBucket:     hasNetworkBucketColumn(cfg),
+		listColumns:   listColumns,
+		nestedTypes:   nestedTypes,
+		sampleRows:    sampleRows,
+		warnings:      io.Discard,
+	}
+	if !hasNested {
+		if err := writer.open(); err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// open builds the schema and creates the underlying Parquet writer.
+func (w *ParquetWriter) open() error {
+	// Build schema from config
+	schema, err := buildSchema(w.config, w.ipVersion, w.nestedTypes)
+	if err != nil {
+		return fmt.Errorf("building Parquet schema: %w", err)
+	}
+
+	fields, err := buildFieldWriters(w.config, schema)
+	if err != nil {
+		return fmt.Errorf("building Parquet schema: %w", err)
+	}
+
 	// Create Parquet writer with options
-	parquetWriter := parquet.NewGenericWriter[map[string]any](w, opts...)
+	opts := append([]parquet.WriterOption{schema}, w.options...)
+	w.writer = parquet.NewWriter(w.out, opts...)
+	w.schema = schema
+	w.fields = fields
+	return nil
+}
 
-	return &ParquetWriter{
-		writer:       parquetWriter,
-		config:       cfg,
-		schema:       schema,
-		rowGroupSize: cfg.Output.Parquet.RowGroupSize,
-		ipVersion:    ipVersion,
-		hasBucket:    hasNetworkBucketColumn(cfg),
-	}, nil
+// SetKeyValueMetadata sets a key-value pair in the Parquet file metadata.
+func (w *ParquetWriter) SetKeyValueMetadata(key, value string) {
+	if w.writer == nil {
+		w.options = append(w.options, parquet.KeyValueMetadata(key, value))
+		return
+	}
+	w.writer.SetKeyValueMetadata(key, value)
 }
 
 // WriteRow writes a single row with network prefix and column data.
 // If a network_bucket column is configured, this may write multiple rows
 // (one per bucket the network spans).
 func (w *ParquetWriter) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType) error {
+	if w.writer == nil {
+		return w.bufferRow(prefix, data)
+	}
+	if w.cutPending && w.crossesBoundary(prefix) {
+		if err := w.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+	w.lastPrefix = prefix
 	if w.hasBucket {
 		return w.writeRowsWithBucketing(prefix, data)
 	}
 	return w.writeSingleRow(prefix, netip.Prefix{}, data)
 }
 
+// bufferRow buffers a row until enough rows have been seen to infer the
+// types of nested columns.
+func (w *ParquetWriter) bufferRow(prefix netip.Prefix, data []mmdbtype.DataType) error {
+	// The caller may reuse data after WriteRow returns.
+	w.pending = append(w.pending, pendingRow{prefix: prefix, data: slices.Clone(data)})
+	if len(w.pending) < w.sampleRows {
+		return nil
+	}
+	return w.inferAndOpen()
+}
+
+// inferAndOpen infers the types of nested columns from the buffered rows,
+// opens the writer, and writes the buffered rows.
+func (w *ParquetWriter) inferAndOpen() error {
+	for i, t := range w.nestedTypes {
+		if t == nil || t.complete {
+			continue
+		}
+		for _, row := range w.pending {
+			if err := t.observe(row.data[i]); err != nil {
+				return fmt.Errorf(
+					"inferring type of column '%s': %w",
+					w.config.Columns[i].Name,
+					err,
+				)
+			}
+		}
+		t.finalize()
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	pending := w.pending
+	w.pending = nil
+	for _, row := range pending {
+		if err := w.WriteRow(row.prefix, row.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWarnings sets the writer that receives warnings about values that are
+// not written as is, such as struct fields that were not in the rows sampled
+// to infer the schema. Warnings are discarded by default or if warnings is
+// nil.
+func (w *ParquetWriter) SetWarnings(warnings io.Writer) {
+	if warnings == nil {
+		warnings = io.Discard
+	}
+	w.warnings = warnings
+}
+
+// SetNestedTypes sets the types of the nested columns inferred by
+// InferNestedTypes, so that they are not inferred from the sampled rows. It
+// must be called before any row is written. If the types of all nested
+// columns are known, the writer is opened right away.
+func (w *ParquetWriter) SetNestedTypes(types *NestedTypes) error {
+	if types == nil {
+		return nil
+	}
+	if w.writer != nil || len(w.pending) > 0 {
+		return errors.New("nested types must be set before any row is written")
+	}
+
+	sampled := false
+	for i, t := range types.types {
+		if t != nil {
+			w.nestedTypes[i] = t
+		} else if w.nestedTypes[i] != nil {
+			sampled = true
+		}
+	}
+	if sampled {
+		return nil
+	}
+	return w.open()
+}
+
 // getBucketSize returns the bucket prefix length for the given IP version.
 func (w *ParquetWriter) getBucketSize(isIPv6 bool) int {
 	if isIPv6 {
@@ -143,149 +313,151 @@ func (w *ParquetWriter) writeRowsWithBucketing(
 
 // writeSingleRow writes a single row with the given prefix and optional bucket.
 // If bucket.IsValid() is false, the bucket column is not written.
+//
+// Rows are buffered and passed to the Parquet writer in batches.
 func (w *ParquetWriter) writeSingleRow(
 	prefix netip.Prefix,
 	bucket netip.Prefix,
 	data []mmdbtype.DataType,
 ) error {
-	// Build row with network columns + data columns
-	row := map[string]any{}
-
-	// Add network column values
-	for _, netCol := range w.config.Network.Columns {
-		value, err := w.generateNetworkColumnValue(prefix, bucket, netCol.Type)
-		if err != nil {
-			return fmt.Errorf("generating network column '%s': %w", netCol.Name, err)
-		}
-		row[string(netCol.Name)] = value
+	if err := w.appendRow(prefix, bucket, data); err != nil {
+		return err
 	}
-
-	// Add data column values (with type conversion)
-	for i, col := range w.config.Columns {
-		value := data[i]
-		converted, err := convertToParquetType(value, col.Type)
-		if err != nil {
-			return fmt.Errorf("converting column '%s': %w", col.Name, err)
+	if len(w.batch) >= parquetBatchRows {
+		if err := w.writeBatch(); err != nil {
+			return err
 		}
-		row[string(col.Name)] = converted
-	}
-
-	// Write the row
-	if _, err := w.writer.Write([]map[string]any{row}); err != nil {
-		return fmt.Errorf("writing Parquet row: %w", err)
 	}
 
 	w.rowCount++
-
-	// Flush row group if we've reached the size limit
-	if w.rowCount >= w.rowGroupSize {
-		if err := w.writer.Flush(); err != nil {
-			return fmt.Errorf("flushing row group: %w", err)
+	w.totalRows++
+
+	// Flush row group if we've reached the size limit, or wait for the next
+	// row group boundary if one is configured.
+	if w.rowGroupFull() {
+		if w.boundaryBits(prefix.Addr()) > 0 {
+			w.cutPending = true
+			return nil
 		}
-		w.rowCount = 0
+		return w.flushRowGroup()
 	}
 
 	return nil
 }
 
-// Flush ensures all buffered data is written.
-func (w *ParquetWriter) Flush() error {
-	if err := w.writer.Close(); err != nil {
-		return fmt.Errorf("closing Parquet writer: %w", err)
+// rowGroupFull reports whether the current row group has reached the row or
+// byte limit. The byte size is the writer's estimate of the row group's size
+// on disk, which counts the current page uncompressed, plus the estimated
+// size of the rows not yet passed to it.
+func (w *ParquetWriter) rowGroupFull() bool {
+	if w.rowGroupSize > 0 && w.rowCount >= w.rowGroupSize {
+		return true
 	}
-	return nil
+	if w.rowGroupBytes == 0 {
+		return false
+	}
+	return w.writer.Size()-w.rowGroupStart+w.batchBytes >= w.rowGroupBytes
 }
 
-// generateNetworkColumnValue generates the value for a network column.
-// bucket is only used for NetworkColumnBucket; for other column types it is ignored.
-func (w *ParquetWriter) generateNetworkColumnValue(
-	prefix netip.Prefix,
-	bucket netip.Prefix,
-	colType string,
-) (any, error) {
-	addr := prefix.Addr()
+// boundaryBits returns the prefix length at which row groups holding addr
+// may be cut, or 0 if they may be cut after any row.
+func (w *ParquetWriter) boundaryBits(addr netip.Addr) int {
+	if addr.Is4() {
+		return w.config.Output.Parquet.IPv4RowGroupBoundary
+	}
+	return w.config.Output.Parquet.IPv6RowGroupBoundary
+}
 
-	switch colType {
-	case NetworkColumnCIDR:
-		return prefix.String(), nil
+// crossesBoundary reports whether prefix starts in a different boundary
+// block than the last row written, so that cutting the row group before it
+// keeps each block within a single row group.
+func (w *ParquetWriter) crossesBoundary(prefix netip.Prefix) bool {
+	last := w.lastPrefix.Addr()
+	addr := prefix.Addr()
+	if last.Is4() != addr.Is4() {
+		return true
+	}
+	bits := w.boundaryBits(addr)
+	return netip.PrefixFrom(last, bits).Masked() != netip.PrefixFrom(addr, bits).Masked()
+}
 
-	case NetworkColumnStartIP:
-		return addr.String(), nil
+// flushRowGroup ends the current row group.
+func (w *ParquetWriter) flushRowGroup() error {
+	if err := w.writeBatch(); err != nil {
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("flushing row group: %w", err)
+	}
+	w.rowCount = 0
+	w.rowGroupStart = w.writer.Size()
+	w.cutPending = false
+	return nil
+}
 
-	case NetworkColumnEndIP:
-		endIP := netipx.PrefixLastIP(prefix)
-		return endIP.String(), nil
+// spill writes the rows of the current row group to the output, so that they
+// are not held in memory while no rows are written. Rows buffered to infer
+// the types of nested columns stay buffered.
+func (w *ParquetWriter) spill() error {
+	if w.writer == nil || (w.rowCount == 0 && len(w.batch) == 0) {
+		return nil
+	}
+	return w.flushRowGroup()
+}
 
-	case NetworkColumnStartInt:
-		if addr.Is4() {
-			if w.ipVersion == ipVersion6 {
-				return nil, errors.New("encountered IPv4 address in IPv6-specific writer")
-			}
-			return int64(network.IPv4ToUint32(addr)), nil
-		}
-		if w.ipVersion == ipVersion4 {
-			return nil, errors.New(
-				"start_int column type only supports IPv4 in IPv4-only Parquet files; configure output.ipv4_file and output.ipv6_file to emit IPv6 integer columns",
-			)
-		}
-		if w.ipVersion == ipVersion6 {
-			return ipv6IntBytes(addr), nil
-		}
-		return nil, errors.New(
-			"start_int column type only supports IPv4 unless you configure output.ipv4_file and output.ipv6_file",
-		)
+// convertDataValue converts the value of data column i to the Parquet type of
+// the column.
+func (w *ParquetWriter) convertDataValue(i int, value mmdbtype.DataType) (any, error) {
+	col := w.config.Columns[i]
+	switch {
+	case w.nestedTypes[i] != nil:
+		return w.nestedTypes[i].convert(value, col.Name, w.warnings)
+	case w.listColumns[i]:
+		return convertToParquetList(value, col.Type)
+	default:
+		return convertToParquetType(value, col.Type)
+	}
+}
 
-	case NetworkColumnEndInt:
-		endIP := netipx.PrefixLastIP(prefix)
-		if endIP.Is4() {
-			if w.ipVersion == ipVersion6 {
-				return nil, errors.New("encountered IPv4 address in IPv6-specific writer")
-			}
-			return int64(network.IPv4ToUint32(endIP)), nil
-		}
-		if w.ipVersion == ipVersion4 {
-			return nil, errors.New(
-				"end_int column type only supports IPv4 in IPv4-only Parquet files; configure output.ipv4_file and output.ipv6_file to emit IPv6 integer columns",
-			)
-		}
-		if w.ipVersion == ipVersion6 {
-			return ipv6IntBytes(endIP), nil
-		}
-		return nil, errors.New(
-			"end_int column type only supports IPv4 unless you configure output.ipv4_file and output.ipv6_file",
-		)
+// NumRows returns the number of rows written so far. Rows buffered to infer
+// nested column types are only counted once the schema is known.
+func (w *ParquetWriter) NumRows() int64 {
+	return w.totalRows
+}
 
-	case NetworkColumnBucket:
-		if !bucket.IsValid() {
-			return nil, errors.New("invalid bucket but network_bucket column requested")
-		}
-		bucketAddr := bucket.Addr()
-		if bucketAddr.Is4() {
-			// IPv4: int64 (same as start_int)
-			return int64(network.IPv4ToUint32(bucketAddr)), nil
-		}
-		// IPv6: hex string by default, int64 when explicitly configured
-		if w.config.Output.Parquet.IPv6BucketType != config.IPv6BucketTypeInt {
-			return fmt.Sprintf("%x", bucketAddr.As16()), nil
-		}
-		val, err := network.IPv6BucketToInt64(bucketAddr)
-		if err != nil {
-			return nil, fmt.Errorf("converting IPv6 bucket to int64: %w", err)
+// Flush ensures all buffered data is written.
+func (w *ParquetWriter) Flush() error {
+	if w.writer == nil {
+		if err := w.inferAndOpen(); err != nil {
+			return err
 		}
-		return val, nil
-
-	default:
-		return nil, fmt.Errorf("unknown network column type: %s", colType)
 	}
+	if err := w.writeBatch(); err != nil {
+		return err
+	}
+	if err := w.writer.Close(); err != nil {
+		return fmt.Errorf("closing Parquet writer: %w", err)
+	}
+	return nil
 }
 
-// buildSchema builds a Parquet schema from the config.
-func buildSchema(cfg *config.Config, ipVersion int) (*parquet.Schema, error) {
-	fields := make(parquet.Group)
+// buildSchema builds a Parquet schema from the config. nestedTypes holds the
+// inferred type of each column with a nested type hint and nil otherwise.
+//
+// Fields are in config order: network columns first, then data columns.
+func buildSchema(
+	cfg *config.Config,
+	ipVersion int,
+	nestedTypes []*nestedType,
+) (*parquet.Schema, error) {
+	fields := make(columnGroup, 0, len(cfg.Network.Columns)+len(cfg.Columns))
 
 	// Add network columns
 	for _, netCol := range cfg.Network.Columns {
 		node, err := buildNetworkNode(netCol, ipVersion, cfg)
+		if err == nil {
+			node, err = withEncoding(node, netCol.Encoding)
+		}
 		if err != nil {
 			return nil, fmt.Errorf(
 				"building node for network column '%s': %w",
@@ -293,23 +465,98 @@ func buildSchema(cfg *config.Config, ipVersion int) (*parquet.Schema, error) {
 				err,
 			)
 		}
-		fields[string(netCol.Name)] = node
+		fields = append(fields, columnField{
+			Node: parquet.Optional(node),
+			name: string(netCol.Name),
+		})
 	}
 
 	// Add data columns
-	for _, col := range cfg.Columns {
+	for i, col := range cfg.Columns {
+		if nestedTypes[i] != nil {
+			fields = append(fields, columnField{
+				Node: parquet.Optional(nestedTypes[i].node()),
+				name: string(col.Name),
+			})
+			continue
+		}
 		node, err := buildDataNode(col)
 		if err != nil {
 			return nil, fmt.Errorf("building node for column '%s': %w", col.Name, err)
 		}
-		fields[string(col.Name)] = node
+		fields = append(fields, columnField{Node: node, name: string(col.Name)})
 	}
 
 	schema := parquet.NewSchema("mmdb", fields)
 	return schema, nil
 }
 
-// buildNetworkNode builds a Parquet node for a network column.
+// columnGroup is a group node whose fields keep the order they were added in.
+// parquet.Group sorts its fields by name, which would make the column order
+// of the file differ from the config.
+type columnGroup []parquet.Field
+
+func (g columnGroup) ID() int                     { return 0 }
+func (g columnGroup) String() string              { return parquet.NewSchema("", g).String() }
+func (g columnGroup) Type() parquet.Type          { return parquet.Group{}.Type() }
+func (g columnGroup) Optional() bool              { return false }
+func (g columnGroup) Repeated() bool              { return false }
+func (g columnGroup) Required() bool              { return true }
+func (g columnGroup) Leaf() bool                  { return false }
+func (g columnGroup) Fields() []parquet.Field     { return g }
+func (g columnGroup) Encoding() encoding.Encoding { return nil }
+func (g columnGroup) Compression() compress.Codec { return nil }
+
+// GoType returns the Go type of the equivalent parquet.Group. Rows are
+// written as parquet.Row values, so only the field names matter.
+func (g columnGroup) GoType() reflect.Type {
+	group := make(parquet.Group, len(g))
+	for _, field := range g {
+		group[field.Name()] = field
+	}
+	return group.GoType()
+}
+
+// columnField is a named field of a columnGroup.
+type columnField struct {
+	parquet.Node
+	name string
+}
+
+func (f columnField) Name() string { return f.name }
+
+// Value returns the value of the field in a map[string]any row.
+func (f columnField) Value(base reflect.Value) reflect.Value {
+	if base.Kind() == reflect.Interface {
+		base = base.Elem()
+	}
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// withEncoding returns the leaf node using the named column encoding. An
+// empty name keeps the writer's default encoding.
+func withEncoding(node parquet.Node, name string) (parquet.Node, error) {
+	var enc encoding.Encoding
+	switch name {
+	case "":
+		return node, nil
+	case config.EncodingDictionary:
+		enc = &parquet.RLEDictionary
+	case config.EncodingPlain:
+		enc = &parquet.Plain
+	case config.EncodingDelta:
+		kind := node.Type().Kind()
+		if kind != parquet.Int32 && kind != parquet.Int64 {
+			return nil, fmt.Errorf("delta encoding requires an integer column, not %s", kind)
+		}
+		enc = &parquet.DeltaBinaryPacked
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+	return parquet.Encoded(node, enc), nil
+}
+
+// buildNetworkNode builds the required Parquet node for a network column.
 func buildNetworkNode(
 	col config.NetworkColumn,
 	ipVersion int,
@@ -318,57 +565,122 @@ func buildNetworkNode(
 	switch col.Type {
 	case NetworkColumnCIDR, NetworkColumnStartIP, NetworkColumnEndIP:
 		// String columns
-		return parquet.Optional(parquet.String()), nil
+		return parquet.String(), nil
 
 	case NetworkColumnStartInt, NetworkColumnEndInt:
 		if ipVersion == ipVersion6 {
-			return parquet.Optional(parquet.Leaf(parquet.FixedLenByteArrayType(16))), nil
+			return parquet.Leaf(parquet.FixedLenByteArrayType(16)), nil
 		}
-		return parquet.Optional(parquet.Int(64)), nil
+		return parquet.Int(64), nil
+
+	case NetworkColumnStartIntHi, NetworkColumnStartIntLo,
+		NetworkColumnEndIntHi, NetworkColumnEndIntLo:
+		return parquet.Int(64), nil
 
 	case NetworkColumnBucket:
 		// IPv6 bucket: string (hex) by default, int64 when explicitly configured
 		if ipVersion == ipVersion6 &&
 			cfg.Output.Parquet.IPv6BucketType != config.IPv6BucketTypeInt {
-			return parquet.Optional(parquet.String()), nil
+			return parquet.String(), nil
 		}
-		return parquet.Optional(parquet.Int(64)), nil
+		return parquet.Int(64), nil
 
 	default:
 		return nil, fmt.Errorf("unknown network column type: %s", col.Type)
 	}
 }
 
-func ipv6IntBytes(addr netip.Addr) []byte {
-	b := addr.As16()
-	out := make([]byte, 16)
-	copy(out, b[:])
-	return out
-}
-
-// buildDataNode builds a Parquet node for a data column.
+// buildDataNode builds a Parquet node for a data column. List columns (see
+// config.Column.IsList) become LIST columns of the hinted element type.
 func buildDataNode(col config.Column) (parquet.Node, error) {
-	// Default to string if no type specified
-	if col.Type == "" || col.Type == "string" {
-		return parquet.Optional(parquet.String()), nil
+	node, err := buildScalarNode(col.Type)
+	if err != nil {
+		return nil, err
+	}
+	node, err = withEncoding(node, col.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if col.IsList() {
+		return parquet.Optional(parquet.List(node)), nil
 	}
+	return parquet.Optional(node), nil
+}
+
+// buildScalarNode builds the required Parquet node for a type hint.
+func buildScalarNode(typeHint string) (parquet.Node, error) {
+	switch typeHint {
+	case "", "string":
+		// Default to string if no type specified
+		return parquet.String(), nil
 
-	switch col.Type {
 	case "int64":
-		return parquet.Optional(parquet.Int(64)), nil
+		return parquet.Int(64), nil
+
+	case "uint32":
+		return parquet.Uint(32), nil
+
+	case "uint64":
+		return parquet.Uint(64), nil
+
+	case "uint128":
+		// 16 big-endian bytes, which sort in numeric order
+		return parquet.Leaf(parquet.FixedLenByteArrayType(16)), nil
 
 	case "float64":
-		return parquet.Optional(parquet.Leaf(parquet.DoubleType)), nil
+		return parquet.Leaf(parquet.DoubleType), nil
 
 	case "bool":
-		return parquet.Optional(parquet.Leaf(parquet.BooleanType)), nil
+		return parquet.Leaf(parquet.BooleanType), nil
 
 	case "binary":
-		return parquet.Optional(parquet.Leaf(parquet.ByteArrayType)), nil
+		return parquet.Leaf(parquet.ByteArrayType), nil
+
+	default:
+		precision, scale, ok, err := config.ParseDecimalType(typeHint)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown column type: %s", typeHint)
+		}
+		return parquet.Decimal(scale, precision, decimalPhysicalType(precision)), nil
+	}
+}
 
+// decimalPhysicalType returns the smallest physical type that holds the
+// unscaled values of a decimal with the given precision.
+func decimalPhysicalType(precision int) parquet.Type {
+	switch {
+	case precision <= 9:
+		return parquet.Int32Type
+	case precision <= 18:
+		return parquet.Int64Type
 	default:
-		return nil, fmt.Errorf("unknown column type: %s", col.Type)
+		return parquet.FixedLenByteArrayType(16)
+	}
+}
+
+// convertToParquetList converts the value of a list column to a slice of
+// element values of the hinted type.
+func convertToParquetList(value any, typeHint string) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	list, ok := value.(mmdbtype.Slice)
+	if !ok {
+		return nil, fmt.Errorf("expected list but found %T", value)
+	}
+	elements := make([]any, len(list))
+	for i, element := range list {
+		converted, err := convertToParquetType(element, typeHint)
+		if err != nil {
+			return nil, fmt.Errorf("list element %d: %w", i, err)
+		}
+		elements[i] = converted
 	}
+	return elements, nil
 }
 
 // convertToParquetType converts a value to the appropriate Parquet type.
@@ -384,49 +696,39 @@ func convertToParquetType(value any, typeHint string) (any, error) {
 
 	switch typeHint {
 	case "int64":
-		switch v := value.(type) {
-		case mmdbtype.Int32:
-			return int64(v), nil
-		case mmdbtype.Uint16:
-			return int64(v), nil
-		case mmdbtype.Uint32:
-			return int64(v), nil
-		case mmdbtype.Uint64:
-			if v > 9223372036854775807 {
-				return nil, fmt.Errorf("uint64 value %d overflows int64", v)
-			}
-			return int64(v), nil
-		case *mmdbtype.Uint128:
-			i := (*big.Int)(v)
-			if !i.IsInt64() {
-				return nil, fmt.Errorf("uint128 value %s overflows int64", i.String())
-			}
-			return i.Int64(), nil
-		default:
-			return nil, fmt.Errorf("cannot convert %T to int64", value)
+		i, err := toInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		return i, nil
+
+	case "uint32":
+		u, err := toUint32(value)
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+
+	case "uint64":
+		u, err := toUint64(value)
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+
+	case "uint128":
+		b, err := toUint128(value)
+		if err != nil {
+			return nil, err
 		}
+		return b[:], nil
 
 	case "float64":
-		switch v := value.(type) {
-		case mmdbtype.Float32:
-			return float64(v), nil
-		case mmdbtype.Float64:
-			return float64(v), nil
-		case mmdbtype.Int32:
-			return float64(v), nil
-		case mmdbtype.Uint16:
-			return float64(v), nil
-		case mmdbtype.Uint32:
-			return float64(v), nil
-		case mmdbtype.Uint64:
-			return float64(v), nil
-		case *mmdbtype.Uint128:
-			i := (*big.Int)(v)
-			f, _ := i.Float64()
-			return f, nil
-		default:
-			return nil, fmt.Errorf("cannot convert %T to float64", value)
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, err
 		}
+		return f, nil
 
 	case "bool":
 		if v, ok := value.(mmdbtype.Bool); ok {
@@ -441,7 +743,14 @@ func convertToParquetType(value any, typeHint string) (any, error) {
 		return nil, fmt.Errorf("cannot convert %T to binary", value)
 
 	default:
-		return nil, fmt.Errorf("unknown type hint: %s", typeHint)
+		precision, scale, ok, err := config.ParseDecimalType(typeHint)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown type hint: %s", typeHint)
+		}
+		return toDecimal(value, precision, scale)
 	}
 }
 
@@ -463,16 +772,67 @@ func getCompressionCodec(name string) (compress.Codec, error) {
 	}
 }
 
-// determineSortingColumns returns a sorting writer config if a start_int column
-// is configured. MMDB data is naturally sorted by network prefix, so we declare
-// this sort order in the Parquet metadata to help query engines optimize lookups.
+// pageOptions returns the writer options for page size, column index bounds,
+// and bloom filters. Zero values keep the parquet-go defaults.
+//
+// The column and offset indexes themselves are always written. Together with
+// smaller pages they let query engines skip pages within a row group, and the
+// column index size limit keeps long values such as IPv6 addresses from being
+// truncated in the page bounds.
+func pageOptions(cfg *config.Config) []parquet.WriterOption {
+	pq := cfg.Output.Parquet
+
+	var opts []parquet.WriterOption
+	if pq.PageSize > 0 {
+		opts = append(opts, parquet.PageBufferSize(pq.PageSize))
+	}
+	if pq.ColumnIndexSizeLimit > 0 {
+		limit := pq.ColumnIndexSizeLimit
+		opts = append(opts, parquet.ColumnIndexSizeLimit(func([]string) int { return limit }))
+	}
+	if len(pq.BloomFilterColumns) > 0 {
+		bitsPerValue := uint(10)
+		if pq.BloomFilterBitsPerValue > 0 {
+			bitsPerValue = uint(pq.BloomFilterBitsPerValue)
+		}
+		filters := make([]parquet.BloomFilterColumn, len(pq.BloomFilterColumns))
+		for i, name := range pq.BloomFilterColumns {
+			filters[i] = parquet.SplitBlockFilter(bitsPerValue, name)
+		}
+		opts = append(opts, parquet.BloomFilters(filters...))
+	}
+	return opts
+}
+
+// determineSortingColumns returns a sorting writer config if a start_int or
+// start_int_hi column is configured. MMDB data is naturally sorted by network
+// prefix, so we declare this sort order in the Parquet metadata to help query
+// engines optimize lookups. A start_int_lo column is only sorted within equal
+// start_int_hi values, so it is declared after start_int_hi.
 func determineSortingColumns(cfg *config.Config) parquet.WriterOption {
+	var hi, lo string
 	for _, col := range cfg.Network.Columns {
-		if col.Type == NetworkColumnStartInt {
+		switch col.Type {
+		case NetworkColumnStartInt:
 			return parquet.SortingWriterConfig(
 				parquet.SortingColumns(parquet.Ascending(string(col.Name))),
 			)
+		case NetworkColumnStartIntHi:
+			if hi == "" {
+				hi = string(col.Name)
+			}
+		case NetworkColumnStartIntLo:
+			if lo == "" {
+				lo = string(col.Name)
+			}
 		}
 	}
-	return nil
+	if hi == "" {
+		return nil
+	}
+	sorting := []parquet.SortingColumn{parquet.Ascending(hi)}
+	if lo != "" {
+		sorting = append(sorting, parquet.Ascending(lo))
+	}
+	return parquet.SortingWriterConfig(parquet.SortingColumns(sorting...))
 }
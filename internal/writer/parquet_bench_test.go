@@ -0,0 +1,180 @@
+package writer
+
+import (
+	"bytes"
+	"io"
+	"net/netip"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+// BenchmarkParquetWriteRow benchmarks writing Parquet rows for consecutive
+// networks, as during a large merge. Row groups are flushed every
+// row_group_size rows, so this includes encoding and compression.
+func BenchmarkParquetWriteRow(b *testing.B) {
+	cfg := &config.Config{
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+				{Name: "start_int", Type: "start_int"},
+				{Name: "end_int", Type: "end_int"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "country", Database: "db", Path: config.Path{"country", "iso_code"}},
+			{Name: "city", Database: "db", Path: config.Path{"city", "name"}},
+			{
+				Name:     "latitude",
+				Database: "db",
+				Path:     config.Path{"location", "latitude"},
+				Type:     "float64",
+			},
+			{
+				Name:     "longitude",
+				Database: "db",
+				Path:     config.Path{"location", "longitude"},
+				Type:     "float64",
+			},
+			{
+				Name:     "geoname_id",
+				Database: "db",
+				Path:     config.Path{"city", "geoname_id"},
+				Type:     "int64",
+			},
+		},
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "snappy",
+				RowGroupSize: 500000,
+			},
+		},
+	}
+
+	writer, err := NewParquetWriterWithIPVersion(io.Discard, cfg, IPVersion4)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Data in column order: country, city, latitude, longitude, geoname_id
+	data := []mmdbtype.DataType{
+		mmdbtype.String("US"),
+		mmdbtype.String("New York"),
+		mmdbtype.Float64(40.7128),
+		mmdbtype.Float64(-74.0060),
+		mmdbtype.Uint32(5128581),
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	i := uint32(0)
+	for b.Loop() {
+		addr := netip.AddrFrom4([4]byte{byte(i >> 16), byte(i >> 8), byte(i), 0})
+		i++
+		if err := writer.WriteRow(netip.PrefixFrom(addr, 24), data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkParquetPageIndex writes the synthetic file behind the page index
+// measurements in docs/parquet-queries.md: 500,000 IPv4 /24 networks in one
+// row group. Besides the write time, it reports the pages per column, the
+// start_int pages whose bounds contain a looked-up address, and the file
+// size. Run it with:
+//
+//	go test ./internal/writer -run '^$' -bench ParquetPageIndex -benchtime 1x
+func BenchmarkParquetPageIndex(b *testing.B) {
+	tests := []struct {
+		name         string
+		pageSize     int
+		bloomColumns []string
+	}{
+		{name: "page_size=262144", pageSize: 262144},
+		{name: "page_size=65536", pageSize: 65536},
+		{name: "page_size=8192", pageSize: 8192},
+		{
+			name:         "page_size=262144/bloom=network_bucket",
+			pageSize:     262144,
+			bloomColumns: []string{"network_bucket"},
+		},
+	}
+
+	const numRows = 500000
+	countries := []string{"DE", "FR", "GB", "JP", "US"}
+	lookup := uint32(numRows/2) << 8
+
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			cfg := &config.Config{
+				Network: config.NetworkConfig{
+					Columns: []config.NetworkColumn{
+						{Name: "start_int", Type: "start_int"},
+						{Name: "end_int", Type: "end_int"},
+						{Name: "network_bucket", Type: "network_bucket"},
+					},
+				},
+				Columns: []config.Column{
+					{Name: "country_iso", Database: "db", Path: config.Path{"country", "iso_code"}},
+				},
+				Output: config.OutputConfig{
+					Parquet: config.ParquetConfig{
+						Compression:          "snappy",
+						RowGroupSize:         numRows,
+						IPv4BucketSize:       16,
+						PageSize:             tt.pageSize,
+						ColumnIndexSizeLimit: 16,
+						BloomFilterColumns:   tt.bloomColumns,
+					},
+				},
+			}
+
+			buf := &bytes.Buffer{}
+			for b.Loop() {
+				buf.Reset()
+				writer, err := NewParquetWriterWithIPVersion(buf, cfg, IPVersion4)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for i := range uint32(numRows) {
+					addr := netip.AddrFrom4([4]byte{byte(i >> 16), byte(i >> 8), byte(i), 0})
+					// Neighbouring networks mostly share a country, as in
+					// real databases.
+					data := []mmdbtype.DataType{mmdbtype.String(countries[(i/1000)%uint32(len(countries))])}
+					if err := writer.WriteRow(netip.PrefixFrom(addr, 24), data); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := writer.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			column, _ := file.Schema().Lookup("start_int")
+			index, err := file.RowGroups()[0].ColumnChunks()[column.ColumnIndex].ColumnIndex()
+			if err != nil {
+				b.Fatal(err)
+			}
+			matching := 0
+			for page := range index.NumPages() {
+				if index.MinValue(page).Uint32() <= lookup && lookup <= index.MaxValue(page).Uint32() {
+					matching++
+				}
+			}
+			b.ReportMetric(float64(index.NumPages()), "pages/column")
+			b.ReportMetric(float64(matching), "pages/lookup")
+			b.ReportMetric(float64(buf.Len())/1e6, "MB")
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package writer
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+// nestedKind is the Parquet type inferred for a value of a nested column.
+type nestedKind int
+
+const (
+	kindNull nestedKind = iota // No non-null value observed yet
+	kindString
+	kindInt64
+	kindDouble
+	kindBool
+	kindBinary
+	kindStruct
+	kindList
+	kindMap
+)
+
+func (k nestedKind) String() string {
+	switch k {
+	case kindNull:
+		return "null"
+	case kindString:
+		return "string"
+	case kindInt64:
+		return "int64"
+	case kindDouble:
+		return "double"
+	case kindBool:
+		return "bool"
+	case kindBinary:
+		return "binary"
+	case kindStruct:
+		return "struct"
+	case kindList:
+		return "list"
+	case kindMap:
+		return "map"
+	default:
+		return fmt.Sprintf("nestedKind(%d)", int(k))
+	}
+}
+
+// isNestedTypeHint reports whether a column type hint asks for a nested
+// Parquet type inferred from the data.
+func isNestedTypeHint(typeHint string) bool {
+	switch typeHint {
+	case "struct", "list", "map", "auto":
+		return true
+	default:
+		return false
+	}
+}
+
+// nestedType is the Parquet type of a nested column, inferred by observing
+// its values. Nested maps become structs whose fields are the union of the
+// keys seen, integers and floats widen to double, and any other conflict, as
+// well as a field that was only ever null, falls back to a string holding the
+// JSON encoding of the value. Unsigned integers above the int64 range are
+// strings too.
+type nestedType struct {
+	kind   nestedKind
+	fixed  bool                   // Kind was set by the type hint and cannot change
+	fields map[string]*nestedType // kindStruct
+	elem   *nestedType            // kindList element or kindMap value
+
+	// complete is set on column types inferred from every value of the
+	// column, which need no sampling.
+	complete bool
+	// dropped holds the struct fields that were not seen while inferring
+	// the type and have been warned about.
+	dropped map[string]bool
+	// overflowed is set once a value too large for an inferred int64 has
+	// been warned about.
+	overflowed bool
+}
+
+// NestedTypes holds the types of the nested columns of a config that were
+// inferred from all of their values, so that every Parquet file written for
+// the config has the same schema whatever rows it holds.
+type NestedTypes struct {
+	types []*nestedType // Indexed like config.Columns, nil for other columns
+}
+
+// InferNestedTypes infers the types of the database-sourced nested columns of
+// cfg. scan must call observe with every value that the given columns can
+// take, as merger.Merger.ScanColumnValues does. Computed columns cannot be
+// scanned; their types are still inferred from the first
+// output.parquet.schema_sample_rows rows. InferNestedTypes returns nil if
+// there is nothing to infer.
+func InferNestedTypes(
+	cfg *config.Config,
+	scan func(columns []int, observe func(col int, value mmdbtype.DataType) error) error,
+) (*NestedTypes, error) {
+	types := make([]*nestedType, len(cfg.Columns))
+	var columns []int
+	for i, col := range cfg.Columns {
+		if isNestedTypeHint(col.Type) && col.Expression == "" {
+			types[i] = newNestedType(col)
+			columns = append(columns, i)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil
+	}
+
+	err := scan(columns, func(col int, value mmdbtype.DataType) error {
+		if err := types[col].observe(value); err != nil {
+			return fmt.Errorf("inferring type of column '%s': %w", cfg.Columns[col].Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range columns {
+		types[i].finalize()
+		types[i].complete = true
+	}
+	return &NestedTypes{types: types}, nil
+}
+
+// newNestedType returns the type to infer for a column with a nested type
+// hint. List columns (see config.Column.IsList) hold a list of that type.
+func newNestedType(col config.Column) *nestedType {
+	var t *nestedType
+	switch col.Type {
+	case "struct":
+		t = &nestedType{kind: kindStruct, fixed: true, fields: map[string]*nestedType{}}
+	case "list":
+		t = &nestedType{kind: kindList, fixed: true, elem: &nestedType{}}
+	case "map":
+		t = &nestedType{kind: kindMap, fixed: true, elem: &nestedType{}}
+	default: // "auto"
+		t = &nestedType{}
+	}
+	if col.IsList() {
+		return &nestedType{kind: kindList, fixed: true, elem: t}
+	}
+	return t
+}
+
+// kindOf returns the kind a value would be inferred as on its own.
+func kindOf(value mmdbtype.DataType) nestedKind {
+	switch value.(type) {
+	case mmdbtype.String, *mmdbtype.Uint128:
+		return kindString
+	case mmdbtype.Int32, mmdbtype.Uint16, mmdbtype.Uint32:
+		return kindInt64
+	case mmdbtype.Uint64:
+		if value.(mmdbtype.Uint64) > math.MaxInt64 {
+			return kindString
+		}
+		return kindInt64
+	case mmdbtype.Float32, mmdbtype.Float64:
+		return kindDouble
+	case mmdbtype.Bool:
+		return kindBool
+	case mmdbtype.Bytes:
+		return kindBinary
+	case mmdbtype.Map:
+		return kindStruct
+	case mmdbtype.Slice:
+		return kindList
+	default:
+		return kindString
+	}
+}
+
+// observe widens t so that it can hold value. It only fails when value
+// doesn't match a kind fixed by the type hint.
+func (t *nestedType) observe(value mmdbtype.DataType) error {
+	if value == nil {
+		return nil
+	}
+
+	kind := kindOf(value)
+	if t.kind == kindMap && kind == kindStruct {
+		kind = kindMap
+	}
+
+	switch {
+	case t.kind == kind:
+	case t.fixed:
+		return fmt.Errorf("expected %s but found %T", t.kind, value)
+	case t.kind == kindNull:
+		t.kind = kind
+		switch kind {
+		case kindStruct:
+			t.fields = map[string]*nestedType{}
+		case kindList:
+			t.elem = &nestedType{}
+		}
+	case isNumericKind(t.kind) && isNumericKind(kind):
+		t.kind = kindDouble
+		return nil
+	default:
+		t.kind = kindString
+		t.fields = nil
+		t.elem = nil
+		return nil
+	}
+
+	switch v := value.(type) {
+	case mmdbtype.Map:
+		if t.kind != kindStruct && t.kind != kindMap {
+			return nil
+		}
+		for key, fieldValue := range v {
+			target := t.elem
+			if t.kind == kindStruct {
+				target = t.fields[string(key)]
+				if target == nil {
+					target = &nestedType{}
+					t.fields[string(key)] = target
+				}
+			}
+			if err := target.observe(fieldValue); err != nil {
+				return fmt.Errorf("field %q: %w", key, err)
+			}
+		}
+	case mmdbtype.Slice:
+		if t.kind != kindList {
+			return nil
+		}
+		for i, element := range v {
+			if err := t.elem.observe(element); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func isNumericKind(k nestedKind) bool {
+	return k == kindInt64 || k == kindDouble
+}
+
+// finalize replaces types that cannot be written as Parquet groups, i.e.
+// types with no observed values and structs with no fields, by strings.
+func (t *nestedType) finalize() {
+	switch t.kind {
+	case kindNull:
+		t.kind = kindString
+	case kindStruct:
+		if len(t.fields) == 0 {
+			t.kind = kindString
+			t.fields = nil
+			return
+		}
+		for _, field := range t.fields {
+			field.finalize()
+		}
+	case kindList, kindMap:
+		t.elem.finalize()
+	}
+}
+
+// node returns the required Parquet node for the type. Struct fields, list
+// elements and map values are optional.
+func (t *nestedType) node() parquet.Node {
+	switch t.kind {
+	case kindInt64:
+		return parquet.Int(64)
+	case kindDouble:
+		return parquet.Leaf(parquet.DoubleType)
+	case kindBool:
+		return parquet.Leaf(parquet.BooleanType)
+	case kindBinary:
+		return parquet.Leaf(parquet.ByteArrayType)
+	case kindStruct:
+		group := make(parquet.Group, len(t.fields))
+		for name, field := range t.fields {
+			group[name] = parquet.Optional(field.node())
+		}
+		return group
+	case kindList:
+		return parquet.List(parquet.Optional(t.elem.node()))
+	case kindMap:
+		return parquet.Map(parquet.String(), parquet.Optional(t.elem.node()))
+	default:
+		return parquet.String()
+	}
+}
+
+// convert converts a value of the column to the Go representation the
+// Parquet writer expects for the type. Fields missing from a map are written
+// as null. Types inferred from a sample may not fit later values: a field
+// that was not seen has no place in the schema and is dropped, and an
+// unsigned integer above the int64 range is written as null, with a warning
+// written to warnings.
+func (t *nestedType) convert(
+	value mmdbtype.DataType,
+	column mmdbtype.String,
+	warnings io.Writer,
+) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch t.kind {
+	case kindInt64:
+		if v, ok := value.(mmdbtype.Uint64); ok && v > math.MaxInt64 {
+			t.warnOverflow(v, column, warnings)
+			return nil, nil
+		}
+		return convertToParquetType(value, "int64")
+	case kindDouble:
+		return convertToParquetType(value, "float64")
+	case kindBool:
+		return convertToParquetType(value, "bool")
+	case kindBinary:
+		return convertToParquetType(value, "binary")
+
+	case kindStruct, kindMap:
+		m, ok := value.(mmdbtype.Map)
+		if !ok {
+			return nil, fmt.Errorf("expected map but found %T", value)
+		}
+		out := make(map[string]any, len(m))
+		for key, fieldValue := range m {
+			target := t.elem
+			if t.kind == kindStruct {
+				target = t.fields[string(key)]
+				if target == nil {
+					t.dropField(string(key), column, warnings)
+					continue
+				}
+			}
+			converted, err := target.convert(fieldValue, column, warnings)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", key, err)
+			}
+			out[string(key)] = converted
+		}
+		return out, nil
+
+	case kindList:
+		s, ok := value.(mmdbtype.Slice)
+		if !ok {
+			return nil, fmt.Errorf("expected slice but found %T", value)
+		}
+		out := make([]any, len(s))
+		for i, element := range s {
+			converted, err := t.elem.convert(element, column, warnings)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			out[i] = converted
+		}
+		return out, nil
+
+	default:
+		return convertToString(value)
+	}
+}
+
+// dropField warns, once per field, that a struct field is not written.
+func (t *nestedType) dropField(key string, column mmdbtype.String, warnings io.Writer) {
+	if t.dropped[key] {
+		return
+	}
+	if t.dropped == nil {
+		t.dropped = map[string]bool{}
+	}
+	t.dropped[key] = true
+	fmt.Fprintf(
+		warnings,
+		"Warning: column '%s': dropping field %q, which was not in the rows sampled to infer the schema (output.parquet.schema_sample_rows)\n",
+		column,
+		key,
+	)
+}
+
+// warnOverflow warns, once per type, that a value too large for int64 is
+// written as null.
+func (t *nestedType) warnOverflow(v mmdbtype.Uint64, column mmdbtype.String, warnings io.Writer) {
+	if t.overflowed {
+		return
+	}
+	t.overflowed = true
+	fmt.Fprintf(
+		warnings,
+		"Warning: column '%s': writing %d as null, since it overflows the int64 type inferred from the rows sampled to infer the schema (output.parquet.schema_sample_rows)\n",
+		column,
+		uint64(v),
+	)
+}
@@ -0,0 +1,446 @@
+package writer
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"math"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func TestNestedType_Infer(t *testing.T) {
+	tests := []struct {
+		name     string
+		column   config.Column
+		values   []mmdbtype.DataType
+		expected string
+	}{
+		{
+			name:   "struct with fields missing from some records",
+			column: config.Column{Type: "struct"},
+			values: []mmdbtype.DataType{
+				mmdbtype.Map{
+					"geoname_id": mmdbtype.Uint32(2643743),
+					"names":      mmdbtype.Map{"en": mmdbtype.String("London")},
+				},
+				mmdbtype.Map{"confidence": mmdbtype.Uint16(50)},
+				nil,
+			},
+			expected: "group{confidence:int64,geoname_id:int64,names:group{en:string}}",
+		},
+		{
+			name:   "integers and floats widen to double",
+			column: config.Column{Type: "list"},
+			values: []mmdbtype.DataType{
+				mmdbtype.Slice{mmdbtype.Int32(1), mmdbtype.Float64(2.5)},
+			},
+			expected: "list<double>",
+		},
+		{
+			name:   "conflicting types fall back to string",
+			column: config.Column{Type: "struct"},
+			values: []mmdbtype.DataType{
+				mmdbtype.Map{"value": mmdbtype.String("a")},
+				mmdbtype.Map{"value": mmdbtype.Map{"nested": mmdbtype.Bool(true)}},
+			},
+			expected: "group{value:string}",
+		},
+		{
+			name:   "unsigned integers above the int64 range are strings",
+			column: config.Column{Type: "struct"},
+			values: []mmdbtype.DataType{
+				mmdbtype.Map{"small": mmdbtype.Uint64(1), "mixed": mmdbtype.Uint64(1)},
+				mmdbtype.Map{"mixed": mmdbtype.Uint64(math.MaxUint64)},
+			},
+			expected: "group{mixed:string,small:int64}",
+		},
+		{
+			name:   "map values",
+			column: config.Column{Type: "map"},
+			values: []mmdbtype.DataType{
+				mmdbtype.Map{"en": mmdbtype.String("London"), "de": mmdbtype.String("London")},
+				mmdbtype.Map{"ja": mmdbtype.String("ロンドン")},
+			},
+			expected: "map<string>",
+		},
+		{
+			name:   "auto infers scalars",
+			column: config.Column{Type: "auto"},
+			values: []mmdbtype.DataType{
+				(*mmdbtype.Uint128)(big.NewInt(1)),
+			},
+			expected: "string",
+		},
+		{
+			name:   "wildcard column holds a list of structs",
+			column: config.Column{Type: "struct", Path: config.Path{"subdivisions", "*"}},
+			values: []mmdbtype.DataType{
+				mmdbtype.Slice{mmdbtype.Map{"iso_code": mmdbtype.String("ENG")}},
+			},
+			expected: "list<group{iso_code:string}>",
+		},
+		{
+			name:     "nothing observed",
+			column:   config.Column{Type: "struct"},
+			values:   []mmdbtype.DataType{nil, mmdbtype.Map{}},
+			expected: "string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nt := newNestedType(tt.column)
+			for _, value := range tt.values {
+				require.NoError(t, nt.observe(value))
+			}
+			nt.finalize()
+			assert.Equal(t, tt.expected, describeNestedType(nt))
+		})
+	}
+}
+
+func TestNestedType_ObserveRejectsHintMismatch(t *testing.T) {
+	nt := newNestedType(config.Column{Type: "struct"})
+	err := nt.observe(mmdbtype.String("London"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected struct but found mmdbtype.String")
+}
+
+func TestNestedType_ConvertDropsUnseenField(t *testing.T) {
+	var warned bytes.Buffer
+	nt := newNestedType(config.Column{Type: "struct"})
+	require.NoError(t, nt.observe(mmdbtype.Map{"a": mmdbtype.String("x")}))
+	nt.finalize()
+
+	for range 2 {
+		got, err := nt.convert(mmdbtype.Map{
+			"a": mmdbtype.String("x"),
+			"b": mmdbtype.String("y"),
+		}, "record", &warned)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"a": "x"}, got)
+	}
+	assert.Equal(t,
+		"Warning: column 'record': dropping field \"b\", which was not in the rows sampled to infer the schema (output.parquet.schema_sample_rows)\n",
+		warned.String(),
+	)
+}
+
+func TestNestedType_ConvertOverflowingUint64(t *testing.T) {
+	var warned bytes.Buffer
+	nt := newNestedType(config.Column{Type: "list"})
+	require.NoError(t, nt.observe(mmdbtype.Slice{mmdbtype.Uint64(1)}))
+	nt.finalize()
+
+	for range 2 {
+		got, err := nt.convert(mmdbtype.Slice{
+			mmdbtype.Uint64(2),
+			mmdbtype.Uint64(math.MaxUint64),
+		}, "counts", &warned)
+		require.NoError(t, err)
+		assert.Equal(t, []any{int64(2), nil}, got)
+	}
+	assert.Equal(t,
+		"Warning: column 'counts': writing 18446744073709551615 as null, since it overflows the int64 type inferred from the rows sampled to infer the schema (output.parquet.schema_sample_rows)\n",
+		warned.String(),
+	)
+}
+
+func TestInferNestedTypes(t *testing.T) {
+	cfg := &config.Config{
+		Columns: []config.Column{
+			{Name: "record", Database: "geo", Path: config.Path{}, Type: "struct"},
+			{Name: "code", Database: "geo", Path: config.Path{"code"}},
+			{Name: "computed", Expression: "code", Type: "auto"},
+		},
+	}
+
+	var scanned []int
+	types, err := InferNestedTypes(cfg, func(
+		columns []int,
+		observe func(int, mmdbtype.DataType) error,
+	) error {
+		scanned = columns
+		for _, value := range []mmdbtype.DataType{
+			mmdbtype.Map{"a": mmdbtype.Uint32(1)},
+			mmdbtype.Map{"b": mmdbtype.String("x")},
+		} {
+			if err := observe(0, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Computed columns are left to be inferred from the sampled rows.
+	assert.Equal(t, []int{0}, scanned)
+	require.Len(t, types.types, 3)
+	assert.Equal(t, "group{a:int64,b:string}", describeNestedType(types.types[0]))
+	assert.Nil(t, types.types[1])
+	assert.Nil(t, types.types[2])
+
+	types, err = InferNestedTypes(&config.Config{
+		Columns: []config.Column{{Name: "computed", Expression: "1", Type: "auto"}},
+	}, func([]int, func(int, mmdbtype.DataType) error) error {
+		t.Fatal("nothing should be scanned")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Nil(t, types)
+}
+
+func TestParquetWriter_SetNestedTypes(t *testing.T) {
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{Compression: "none", SchemaSampleRows: 1},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{{Name: "network", Type: "cidr"}},
+		},
+		Columns: []config.Column{
+			{Name: "record", Database: "geo", Path: config.Path{}, Type: "struct"},
+		},
+	}
+	types, err := InferNestedTypes(cfg, func(
+		_ []int,
+		observe func(int, mmdbtype.DataType) error,
+	) error {
+		if err := observe(0, mmdbtype.Map{"a": mmdbtype.String("x")}); err != nil {
+			return err
+		}
+		return observe(0, mmdbtype.Map{"b": mmdbtype.Bool(true)})
+	})
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+	require.NoError(t, writer.SetNestedTypes(types))
+	// With all nested types known, nothing is sampled.
+	require.NotNil(t, writer.writer)
+
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	require.NoError(t, writer.WriteRow(prefix, []mmdbtype.DataType{
+		mmdbtype.Map{"a": mmdbtype.String("x")},
+	}))
+	require.NoError(t, writer.WriteRow(netip.MustParsePrefix("10.0.1.0/24"), []mmdbtype.DataType{
+		mmdbtype.Map{"b": mmdbtype.Bool(true)},
+	}))
+	require.NoError(t, writer.Flush())
+	require.EqualError(t, writer.SetNestedTypes(types),
+		"nested types must be set before any row is written")
+
+	type record struct {
+		A *string `parquet:"a,optional"`
+		B *bool   `parquet:"b,optional"`
+	}
+	type recordRow struct {
+		Record *record `parquet:"record,optional"`
+	}
+	reader := parquet.NewGenericReader[recordRow](bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+
+	got := make([]recordRow, 2)
+	n, err := reader.Read(got)
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	require.Equal(t, 2, n)
+	require.NotNil(t, got[1].Record)
+	assert.Nil(t, got[1].Record.A)
+	require.NotNil(t, got[1].Record.B)
+	assert.True(t, *got[1].Record.B)
+}
+
+func TestParquetWriter_NestedUint64(t *testing.T) {
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{Compression: "none", SchemaSampleRows: 1},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{{Name: "network", Type: "cidr"}},
+		},
+		Columns: []config.Column{
+			{Name: "inferred", Database: "geo", Path: config.Path{}, Type: "struct"},
+			{Name: "sampled", Database: "geo", Path: config.Path{}, Type: "struct"},
+		},
+	}
+	values := []mmdbtype.DataType{
+		mmdbtype.Map{"n": mmdbtype.Uint64(1)},
+		mmdbtype.Map{"n": mmdbtype.Uint64(math.MaxUint64)},
+	}
+	types, err := InferNestedTypes(cfg, func(
+		_ []int,
+		observe func(int, mmdbtype.DataType) error,
+	) error {
+		// Only the first column is scanned, so the second is sampled from
+		// the first row.
+		for _, value := range values {
+			if err := observe(0, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	types.types[1] = nil
+
+	var warned bytes.Buffer
+	buf := &bytes.Buffer{}
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+	writer.SetWarnings(&warned)
+	require.NoError(t, writer.SetNestedTypes(types))
+
+	for i, value := range values {
+		prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{10, 0, byte(i), 0}), 24)
+		require.NoError(t, writer.WriteRow(prefix, []mmdbtype.DataType{value, value}))
+	}
+	require.NoError(t, writer.Flush())
+	assert.Contains(t, warned.String(), "column 'sampled': writing 18446744073709551615 as null")
+
+	type inferred struct {
+		N *string `parquet:"n,optional"`
+	}
+	type sampled struct {
+		N *int64 `parquet:"n,optional"`
+	}
+	type row struct {
+		Inferred *inferred `parquet:"inferred,optional"`
+		Sampled  *sampled  `parquet:"sampled,optional"`
+	}
+	reader := parquet.NewGenericReader[row](bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+
+	got := make([]row, 2)
+	n, err := reader.Read(got)
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	require.Equal(t, 2, n)
+	assert.Equal(t, "1", *got[0].Inferred.N)
+	assert.Equal(t, "18446744073709551615", *got[1].Inferred.N)
+	assert.Equal(t, int64(1), *got[0].Sampled.N)
+	require.NotNil(t, got[1].Sampled)
+	assert.Nil(t, got[1].Sampled.N)
+}
+
+func TestParquetWriter_NestedColumns(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:      "none",
+				RowGroupSize:     500000,
+				SchemaSampleRows: 2,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "city", Path: config.Path{"city"}, Type: "struct"},
+			{Name: "country_names", Path: config.Path{"country", "names"}, Type: "map"},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	rows := [][]mmdbtype.DataType{
+		{
+			mmdbtype.Map{
+				"geoname_id": mmdbtype.Uint32(2643743),
+				"names":      mmdbtype.Map{"en": mmdbtype.String("London")},
+			},
+			mmdbtype.Map{"en": mmdbtype.String("United Kingdom")},
+		},
+		{
+			mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Paris")}},
+			nil,
+		},
+		// Written after the schema was inferred from the first two rows.
+		{nil, mmdbtype.Map{"de": mmdbtype.String("Frankreich")}},
+	}
+	for i, data := range rows {
+		prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{10, 0, byte(i), 0}), 24)
+		require.NoError(t, writer.WriteRow(prefix, data))
+	}
+	require.NoError(t, writer.Flush())
+
+	type cityNames struct {
+		En *string `parquet:"en,optional"`
+	}
+	type city struct {
+		GeonameID *int64     `parquet:"geoname_id,optional"`
+		Names     *cityNames `parquet:"names,optional"`
+	}
+	type nestedRow struct {
+		Network      string             `parquet:"network"`
+		City         *city              `parquet:"city,optional"`
+		CountryNames map[string]*string `parquet:"country_names,optional"`
+	}
+
+	reader := parquet.NewGenericReader[nestedRow](bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+
+	got := make([]nestedRow, 3)
+	n, err := reader.Read(got)
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	require.Equal(t, 3, n)
+
+	require.NotNil(t, got[0].City)
+	require.NotNil(t, got[0].City.GeonameID)
+	assert.Equal(t, int64(2643743), *got[0].City.GeonameID)
+	assert.Equal(t, "London", *got[0].City.Names.En)
+	assert.Equal(t, "United Kingdom", *got[0].CountryNames["en"])
+
+	require.NotNil(t, got[1].City)
+	assert.Nil(t, got[1].City.GeonameID)
+	assert.Equal(t, "Paris", *got[1].City.Names.En)
+	assert.Empty(t, got[1].CountryNames)
+
+	assert.Nil(t, got[2].City)
+	assert.Equal(t, "Frankreich", *got[2].CountryNames["de"])
+}
+
+// describeNestedType renders an inferred type compactly for assertions.
+func describeNestedType(t *nestedType) string {
+	switch t.kind {
+	case kindStruct:
+		names := slices.Sorted(maps.Keys(t.fields))
+		var b strings.Builder
+		b.WriteString("group{")
+		for i, name := range names {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(name + ":" + describeNestedType(t.fields[name]))
+		}
+		b.WriteByte('}')
+		return b.String()
+	case kindList:
+		return "list<" + describeNestedType(t.elem) + ">"
+	case kindMap:
+		return "map<" + describeNestedType(t.elem) + ">"
+	default:
+		return t.kind.String()
+	}
+}
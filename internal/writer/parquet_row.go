@@ -0,0 +1,712 @@
+package writer
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+	"go4.org/netipx"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/network"
+)
+
+// parquetBatchRows is the number of rows buffered before they are passed to
+// the Parquet writer in a single call.
+const parquetBatchRows = 1024
+
+// fieldWriter appends the values of one top-level field of the schema to a
+// row. Scalar fields are converted directly to Parquet values; the values of
+// LIST fields and fields with a nested type hint are converted as for
+// convertDataValue and then walked along the field's node.
+type fieldWriter struct {
+	name    string
+	netCol  *config.NetworkColumn // Network column, nil for a data column
+	dataCol int                   // Index of the data column in config.Columns
+	column  int                   // Column index of the field's first leaf
+	nested  parquet.Node          // Node of a LIST or nested field, nil for other fields
+}
+
+// buildFieldWriters returns a field writer for each field of the schema, in
+// schema order.
+func buildFieldWriters(cfg *config.Config, schema *parquet.Schema) ([]fieldWriter, error) {
+	netCols := make(map[string]*config.NetworkColumn, len(cfg.Network.Columns))
+	for i := range cfg.Network.Columns {
+		netCols[string(cfg.Network.Columns[i].Name)] = &cfg.Network.Columns[i]
+	}
+	dataCols := make(map[string]int, len(cfg.Columns))
+	for i, col := range cfg.Columns {
+		dataCols[string(col.Name)] = i
+	}
+
+	fields := make([]fieldWriter, 0, len(schema.Fields()))
+	column := 0
+	for _, field := range schema.Fields() {
+		fw := fieldWriter{name: field.Name(), column: column}
+		if netCol, ok := netCols[fw.name]; ok {
+			fw.netCol = netCol
+		} else if i, ok := dataCols[fw.name]; ok {
+			fw.dataCol = i
+		} else {
+			return nil, fmt.Errorf("schema field '%s' is not a configured column", fw.name)
+		}
+
+		// A column with a nested type hint may have been inferred as a
+		// scalar, but its values still need converting.
+		if !field.Leaf() || (fw.netCol == nil && isNestedTypeHint(cfg.Columns[fw.dataCol].Type)) {
+			fw.nested = field
+		}
+
+		fields = append(fields, fw)
+		column += countLeaves(field)
+	}
+	return fields, nil
+}
+
+// countLeaves returns the number of leaf columns of a node.
+func countLeaves(node parquet.Node) int {
+	if node.Leaf() {
+		return 1
+	}
+	n := 0
+	for _, field := range node.Fields() {
+		n += countLeaves(field)
+	}
+	return n
+}
+
+// appendRow appends the values of a row to w.values and adds the row to the
+// current batch.
+func (w *ParquetWriter) appendRow(
+	prefix netip.Prefix,
+	bucket netip.Prefix,
+	data []mmdbtype.DataType,
+) error {
+	start := len(w.values)
+	for i := range w.fields {
+		f := &w.fields[i]
+		if f.nested != nil {
+			if err := w.appendNestedField(f, data[f.dataCol]); err != nil {
+				return fmt.Errorf("converting column '%s': %w", f.name, err)
+			}
+			continue
+		}
+
+		var value parquet.Value
+		var err error
+		if f.netCol != nil {
+			value, err = w.networkValue(prefix, bucket, f.netCol.Type)
+			if err != nil {
+				return fmt.Errorf("generating network column '%s': %w", f.name, err)
+			}
+		} else {
+			value, err = w.scalarValue(data[f.dataCol], w.config.Columns[f.dataCol].Type)
+			if err != nil {
+				return fmt.Errorf("converting column '%s': %w", f.name, err)
+			}
+		}
+
+		definitionLevel := 1
+		if value.IsNull() {
+			definitionLevel = 0
+		}
+		w.values = append(w.values, value.Level(0, definitionLevel, f.column))
+	}
+
+	row := w.values[start:len(w.values):len(w.values)]
+	for _, value := range row {
+		w.batchBytes += valueSize(value)
+	}
+	w.batch = append(w.batch, row)
+	return nil
+}
+
+// appendNestedField appends the values of a LIST field or a field with a
+// nested type hint.
+func (w *ParquetWriter) appendNestedField(f *fieldWriter, value mmdbtype.DataType) error {
+	converted, err := w.convertDataValue(f.dataCol, value)
+	if err != nil {
+		return err
+	}
+
+	start := len(w.values)
+	if err := w.appendNode(f.nested, converted, f.column, nodeLevels{}); err != nil {
+		return err
+	}
+	// Values of repeated groups were appended entry by entry; a row holds
+	// the values of each column contiguously.
+	slices.SortStableFunc(w.values[start:], func(a, b parquet.Value) int {
+		return cmp.Compare(a.Column(), b.Column())
+	})
+	return nil
+}
+
+// nodeLevels are the repetition and definition levels of the values appended
+// for a node.
+type nodeLevels struct {
+	rep   int // Repetition level of the node's first value
+	def   int // Definition level reached by the enclosing nodes
+	depth int // Number of enclosing repeated nodes
+}
+
+// appendNode appends the values of node, whose first leaf is column, for a
+// value converted by convertDataValue: nil, a scalar, []any for LIST nodes,
+// or map[string]any for MAP and group nodes.
+func (w *ParquetWriter) appendNode(
+	node parquet.Node,
+	value any,
+	column int,
+	levels nodeLevels,
+) error {
+	if node.Optional() {
+		if value == nil {
+			w.appendNulls(node, column, levels)
+			return nil
+		}
+		levels.def++
+	}
+
+	if node.Leaf() {
+		v, err := w.leafValue(node.Type().Kind(), value)
+		if err != nil {
+			return err
+		}
+		w.values = append(w.values, v.Level(levels.rep, levels.def, column))
+		return nil
+	}
+
+	logicalType := node.Type().LogicalType()
+	switch {
+	case logicalType != nil && logicalType.List != nil:
+		list, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("expected list but found %T", value)
+		}
+		repeated := node.Fields()[0]
+		return w.appendRepeated(repeated, column, levels, len(list), func(i int, lv nodeLevels) error {
+			return w.appendNode(repeated.Fields()[0], list[i], column, lv)
+		})
+
+	case logicalType != nil && logicalType.Map != nil:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected map but found %T", value)
+		}
+		keys := slices.Sorted(maps.Keys(m))
+		repeated := node.Fields()[0]
+		return w.appendRepeated(repeated, column, levels, len(keys), func(i int, lv nodeLevels) error {
+			entry := map[string]any{"key": keys[i], "value": m[keys[i]]}
+			return w.appendNode(repeated, entry, column, lv)
+		})
+
+	default:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected map but found %T", value)
+		}
+		for _, field := range node.Fields() {
+			if err := w.appendNode(field, m[field.Name()], column, levels); err != nil {
+				return err
+			}
+			column += countLeaves(field)
+		}
+		return nil
+	}
+}
+
+// appendRepeated appends n entries of a repeated node using appendEntry, or
+// nulls if there are none.
+func (w *ParquetWriter) appendRepeated(
+	repeated parquet.Node,
+	column int,
+	levels nodeLevels,
+	n int,
+	appendEntry func(i int, levels nodeLevels) error,
+) error {
+	if n == 0 {
+		w.appendNulls(repeated, column, levels)
+		return nil
+	}
+	entry := nodeLevels{rep: levels.rep, def: levels.def + 1, depth: levels.depth + 1}
+	for i := range n {
+		if i > 0 {
+			entry.rep = entry.depth
+		}
+		if err := appendEntry(i, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendNulls appends a null for each leaf of node.
+func (w *ParquetWriter) appendNulls(node parquet.Node, column int, levels nodeLevels) {
+	for i := range countLeaves(node) {
+		w.values = append(w.values, parquet.Value{}.Level(levels.rep, levels.def, column+i))
+	}
+}
+
+// leafValue converts a scalar produced by convertToParquetType to a value of
+// the given kind.
+func (w *ParquetWriter) leafValue(kind parquet.Kind, value any) (parquet.Value, error) {
+	switch v := value.(type) {
+	case string:
+		if kind == parquet.ByteArray {
+			return w.stringValue(v), nil
+		}
+	case []byte:
+		if kind == parquet.ByteArray || kind == parquet.FixedLenByteArray {
+			return w.bytesValue(kind, v), nil
+		}
+	case int64:
+		if kind == parquet.Int64 {
+			return parquet.Int64Value(v), nil
+		}
+	case int32:
+		if kind == parquet.Int32 {
+			return parquet.Int32Value(v), nil
+		}
+	case uint32:
+		if kind == parquet.Int32 {
+			return parquet.Int32Value(int32(v)), nil // Bit pattern of the unsigned value
+		}
+	case uint64:
+		if kind == parquet.Int64 {
+			return parquet.Int64Value(int64(v)), nil // Bit pattern of the unsigned value
+		}
+	case float64:
+		if kind == parquet.Double {
+			return parquet.DoubleValue(v), nil
+		}
+	case bool:
+		if kind == parquet.Boolean {
+			return parquet.BooleanValue(v), nil
+		}
+	}
+	return parquet.Value{}, fmt.Errorf("cannot write %T as %s", value, kind)
+}
+
+// writeBatch passes the buffered rows to the Parquet writer.
+func (w *ParquetWriter) writeBatch() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	if _, err := w.writer.WriteRows(w.batch); err != nil {
+		return fmt.Errorf("writing Parquet rows: %w", err)
+	}
+	w.batch = w.batch[:0]
+	w.values = w.values[:0]
+	w.scratch = w.scratch[:0]
+	w.batchBytes = 0
+	return nil
+}
+
+// valueSize estimates the plain-encoded size of a value.
+func valueSize(value parquet.Value) int64 {
+	if value.IsNull() {
+		return 0
+	}
+	switch value.Kind() {
+	case parquet.ByteArray:
+		return 4 + int64(len(value.ByteArray())) // Length prefix and bytes
+	case parquet.FixedLenByteArray:
+		return int64(len(value.ByteArray()))
+	case parquet.Boolean:
+		return 1
+	case parquet.Int32, parquet.Float:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// bytesValue copies b to the scratch buffer, which lives until the batch is
+// written, and returns a value of the given kind referencing the copy.
+func (w *ParquetWriter) bytesValue(kind parquet.Kind, b []byte) parquet.Value {
+	start := len(w.scratch)
+	w.scratch = append(w.scratch, b...)
+	if kind == parquet.FixedLenByteArray {
+		return parquet.FixedLenByteArrayValue(w.scratch[start:])
+	}
+	return parquet.ByteArrayValue(w.scratch[start:])
+}
+
+// stringValue copies s to the scratch buffer and returns a BYTE_ARRAY value
+// referencing the copy.
+func (w *ParquetWriter) stringValue(s string) parquet.Value {
+	start := len(w.scratch)
+	w.scratch = append(w.scratch, s...)
+	return parquet.ByteArrayValue(w.scratch[start:])
+}
+
+// textValue appends the text produced by appendTo to the scratch buffer and
+// returns a BYTE_ARRAY value referencing it.
+func (w *ParquetWriter) textValue(appendTo func([]byte) []byte) parquet.Value {
+	start := len(w.scratch)
+	w.scratch = appendTo(w.scratch)
+	return parquet.ByteArrayValue(w.scratch[start:])
+}
+
+// networkValue returns the value of a network column. bucket is only used for
+// NetworkColumnBucket; for other column types it is ignored.
+func (w *ParquetWriter) networkValue(
+	prefix netip.Prefix,
+	bucket netip.Prefix,
+	colType string,
+) (parquet.Value, error) {
+	addr := prefix.Addr()
+
+	switch colType {
+	case NetworkColumnCIDR:
+		return w.textValue(prefix.AppendTo), nil
+
+	case NetworkColumnStartIP:
+		return w.textValue(addr.AppendTo), nil
+
+	case NetworkColumnEndIP:
+		return w.textValue(netipx.PrefixLastIP(prefix).AppendTo), nil
+
+	case NetworkColumnStartInt:
+		return w.integerAddrValue(addr, colType)
+
+	case NetworkColumnEndInt:
+		return w.integerAddrValue(netipx.PrefixLastIP(prefix), colType)
+
+	case NetworkColumnStartIntHi, NetworkColumnStartIntLo:
+		return parquet.Int64Value(int64Half(addr, colType)), nil
+
+	case NetworkColumnEndIntHi, NetworkColumnEndIntLo:
+		return parquet.Int64Value(int64Half(netipx.PrefixLastIP(prefix), colType)), nil
+
+	case NetworkColumnBucket:
+		if !bucket.IsValid() {
+			return parquet.Value{}, errors.New(
+				"invalid bucket but network_bucket column requested",
+			)
+		}
+		bucketAddr := bucket.Addr()
+		if bucketAddr.Is4() {
+			// IPv4: int64 (same as start_int)
+			return parquet.Int64Value(int64(network.IPv4ToUint32(bucketAddr))), nil
+		}
+		// IPv6: hex string by default, int64 when explicitly configured
+		if w.config.Output.Parquet.IPv6BucketType != config.IPv6BucketTypeInt {
+			b := bucketAddr.As16()
+			return w.textValue(func(dst []byte) []byte {
+				return hex.AppendEncode(dst, b[:])
+			}), nil
+		}
+		val, err := network.IPv6BucketToInt64(bucketAddr)
+		if err != nil {
+			return parquet.Value{}, fmt.Errorf("converting IPv6 bucket to int64: %w", err)
+		}
+		return parquet.Int64Value(val), nil
+
+	default:
+		return parquet.Value{}, fmt.Errorf("unknown network column type: %s", colType)
+	}
+}
+
+// integerAddrValue returns the value of a start_int or end_int column: an
+// int64 for IPv4 and 16 big-endian bytes for IPv6.
+func (w *ParquetWriter) integerAddrValue(addr netip.Addr, colType string) (parquet.Value, error) {
+	if addr.Is4() {
+		if w.ipVersion == ipVersion6 {
+			return parquet.Value{}, errors.New("encountered IPv4 address in IPv6-specific writer")
+		}
+		return parquet.Int64Value(int64(network.IPv4ToUint32(addr))), nil
+	}
+	if w.ipVersion == ipVersion4 {
+		return parquet.Value{}, fmt.Errorf(
+			"%s column type only supports IPv4 in IPv4-only Parquet files; configure output.ipv4_file and output.ipv6_file to emit IPv6 integer columns",
+			colType,
+		)
+	}
+	if w.ipVersion == ipVersion6 {
+		b := addr.As16()
+		return w.bytesValue(parquet.FixedLenByteArray, b[:]), nil
+	}
+	return parquet.Value{}, fmt.Errorf(
+		"%s column type only supports IPv4 unless you configure output.ipv4_file and output.ipv6_file",
+		colType,
+	)
+}
+
+// scalarValue converts the value of a scalar data column to a Parquet value
+// of the hinted type.
+func (w *ParquetWriter) scalarValue(
+	value mmdbtype.DataType,
+	typeHint string,
+) (parquet.Value, error) {
+	if value == nil {
+		return parquet.Value{}, nil
+	}
+
+	switch typeHint {
+	case "", "string":
+		if s, ok := value.(mmdbtype.String); ok {
+			return w.stringValue(string(s)), nil
+		}
+		s, err := convertToString(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return w.stringValue(s), nil
+
+	case "int64":
+		i, err := toInt64(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.Int64Value(i), nil
+
+	case "uint32":
+		u, err := toUint32(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.Int32Value(int32(u)), nil // Bit pattern of the unsigned value
+
+	case "uint64":
+		u, err := toUint64(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.Int64Value(int64(u)), nil // Bit pattern of the unsigned value
+
+	case "uint128":
+		b, err := toUint128(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return w.bytesValue(parquet.FixedLenByteArray, b[:]), nil
+
+	case "float64":
+		f, err := toFloat64(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.DoubleValue(f), nil
+
+	case "bool":
+		if v, ok := value.(mmdbtype.Bool); ok {
+			return parquet.BooleanValue(bool(v)), nil
+		}
+		return parquet.Value{}, fmt.Errorf("cannot convert %T to bool", value)
+
+	case "binary":
+		if v, ok := value.(mmdbtype.Bytes); ok {
+			return w.bytesValue(parquet.ByteArray, v), nil
+		}
+		return parquet.Value{}, fmt.Errorf("cannot convert %T to binary", value)
+
+	default:
+		converted, err := convertToParquetType(value, typeHint)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		switch v := converted.(type) {
+		case int32:
+			return parquet.Int32Value(v), nil
+		case int64:
+			return parquet.Int64Value(v), nil
+		case []byte:
+			return w.bytesValue(parquet.FixedLenByteArray, v), nil
+		default:
+			return parquet.Value{}, fmt.Errorf("unknown type hint: %s", typeHint)
+		}
+	}
+}
+
+// toInt64 converts an integer value to int64.
+func toInt64(value any) (int64, error) {
+	switch v := value.(type) {
+	case mmdbtype.Int32:
+		return int64(v), nil
+	case mmdbtype.Uint16:
+		return int64(v), nil
+	case mmdbtype.Uint32:
+		return int64(v), nil
+	case mmdbtype.Uint64:
+		if v > 9223372036854775807 {
+			return 0, fmt.Errorf("uint64 value %d overflows int64", v)
+		}
+		return int64(v), nil
+	case *mmdbtype.Uint128:
+		i := (*big.Int)(v)
+		if !i.IsInt64() {
+			return 0, fmt.Errorf("uint128 value %s overflows int64", i.String())
+		}
+		return i.Int64(), nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to int64", value)
+	}
+}
+
+// toFloat64 converts a numeric value to float64.
+func toFloat64(value any) (float64, error) {
+	switch v := value.(type) {
+	case mmdbtype.Float32:
+		return float64(v), nil
+	case mmdbtype.Float64:
+		return float64(v), nil
+	case mmdbtype.Int32:
+		return float64(v), nil
+	case mmdbtype.Uint16:
+		return float64(v), nil
+	case mmdbtype.Uint32:
+		return float64(v), nil
+	case mmdbtype.Uint64:
+		return float64(v), nil
+	case *mmdbtype.Uint128:
+		f, _ := (*big.Int)(v).Float64()
+		return f, nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to float64", value)
+	}
+}
+
+// toUint64 converts a non-negative integer value to uint64.
+func toUint64(value any) (uint64, error) {
+	return toUnsigned(value, "uint64")
+}
+
+// toUint32 converts a non-negative integer value to uint32.
+func toUint32(value any) (uint32, error) {
+	u, err := toUnsigned(value, "uint32")
+	if err != nil {
+		return 0, err
+	}
+	if u > math.MaxUint32 {
+		return 0, fmt.Errorf("value %d overflows uint32", u)
+	}
+	return uint32(u), nil
+}
+
+// toUnsigned converts a non-negative integer value to uint64. typeName is the
+// hinted type, used in error messages.
+func toUnsigned(value any, typeName string) (uint64, error) {
+	switch v := value.(type) {
+	case mmdbtype.Int32:
+		if v < 0 {
+			return 0, fmt.Errorf("negative value %d cannot be converted to %s", v, typeName)
+		}
+		return uint64(v), nil
+	case mmdbtype.Uint16:
+		return uint64(v), nil
+	case mmdbtype.Uint32:
+		return uint64(v), nil
+	case mmdbtype.Uint64:
+		return uint64(v), nil
+	case *mmdbtype.Uint128:
+		i := (*big.Int)(v)
+		if !i.IsUint64() {
+			return 0, fmt.Errorf("uint128 value %s overflows %s", i.String(), typeName)
+		}
+		return i.Uint64(), nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to %s", value, typeName)
+	}
+}
+
+// toUint128 converts a non-negative integer value to 16 big-endian bytes.
+func toUint128(value any) ([16]byte, error) {
+	var b [16]byte
+	if v, ok := value.(*mmdbtype.Uint128); ok {
+		i := (*big.Int)(v)
+		if i.Sign() < 0 || i.BitLen() > 128 {
+			return b, fmt.Errorf("value %s does not fit in uint128", i.String())
+		}
+		i.FillBytes(b[:])
+		return b, nil
+	}
+	u, err := toUnsigned(value, "uint128")
+	if err != nil {
+		return b, err
+	}
+	binary.BigEndian.PutUint64(b[8:], u)
+	return b, nil
+}
+
+// toDecimal converts a numeric value to the unscaled integer of a
+// decimal(precision, scale) column: int32 for precisions up to 9, int64 up to
+// 18, and 16 big-endian two's complement bytes above that. Floats are rounded
+// to scale digits.
+func toDecimal(value any, precision, scale int) (any, error) {
+	var digits string
+	switch v := value.(type) {
+	case mmdbtype.Float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, fmt.Errorf("cannot convert %v to decimal", v)
+		}
+		digits = strconv.FormatFloat(float64(v), 'f', scale, 32)
+	case mmdbtype.Float64:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, fmt.Errorf("cannot convert %v to decimal", v)
+		}
+		digits = strconv.FormatFloat(float64(v), 'f', scale, 64)
+	case mmdbtype.Int32:
+		digits = strconv.FormatInt(int64(v), 10) + "." + strings.Repeat("0", scale)
+	case mmdbtype.Uint16, mmdbtype.Uint32, mmdbtype.Uint64, *mmdbtype.Uint128:
+		u, err := toUnsignedString(v)
+		if err != nil {
+			return nil, err
+		}
+		digits = u + "." + strings.Repeat("0", scale)
+	default:
+		return nil, fmt.Errorf("cannot convert %T to decimal", value)
+	}
+
+	// Drop the decimal point to get the unscaled integer.
+	unscaled := strings.Replace(strings.TrimSuffix(digits, "."), ".", "", 1)
+	magnitude := strings.TrimLeft(strings.TrimPrefix(unscaled, "-"), "0")
+	if len(magnitude) > precision {
+		return nil, fmt.Errorf(
+			"value %s overflows decimal(%d,%d)",
+			strings.TrimSuffix(digits, "."),
+			precision,
+			scale,
+		)
+	}
+
+	switch {
+	case precision <= 9:
+		i, err := strconv.ParseInt(unscaled, 10, 32)
+		return int32(i), err
+	case precision <= 18:
+		return strconv.ParseInt(unscaled, 10, 64)
+	default:
+		i, ok := new(big.Int).SetString(unscaled, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid decimal value %s", digits)
+		}
+		if i.Sign() < 0 {
+			// Two's complement
+			i.Add(i, new(big.Int).Lsh(big.NewInt(1), 128))
+		}
+		return i.FillBytes(make([]byte, 16)), nil
+	}
+}
+
+// toUnsignedString formats an unsigned integer value in decimal.
+func toUnsignedString(value any) (string, error) {
+	if v, ok := value.(*mmdbtype.Uint128); ok {
+		return (*big.Int)(v).String(), nil
+	}
+	u, err := toUnsigned(value, "decimal")
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(u, 10), nil
+}
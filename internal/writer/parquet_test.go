@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -146,6 +150,135 @@ func TestParquetWriter_NetworkColumns(t *testing.T) {
 	assert.Len(t, pf.Schema().Fields(), 5)
 }
 
+func TestParquetWriter_ColumnOrder(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "none",
+				RowGroupSize: 500000,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "start_ip", Type: "start_ip"},
+				{Name: "end_ip", Type: "end_ip"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "zip", Type: "string"},
+			{Name: "country", Type: "string"},
+			{Name: "city", Type: "struct"},
+			{Name: "asn", Type: "int64"},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("10.0.0.0/24"),
+		[]mmdbtype.DataType{
+			mmdbtype.String("12345"),
+			mmdbtype.String("US"),
+			mmdbtype.Map{"name": mmdbtype.String("Springfield")},
+			mmdbtype.Uint32(64496),
+		},
+	))
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	var names []string
+	for _, field := range pf.Schema().Fields() {
+		names = append(names, field.Name())
+	}
+	assert.Equal(t, []string{"start_ip", "end_ip", "zip", "country", "city", "asn"}, names)
+
+	rows := readParquetRows(t, buf)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "10.0.0.0", rows[0]["start_ip"])
+	assert.Equal(t, "12345", rows[0]["zip"])
+	assert.Equal(t, "US", rows[0]["country"])
+}
+
+func TestParquetWriter_ColumnEncodings(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "none",
+				RowGroupSize: 500000,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "start_int", Type: "start_int", Encoding: "delta"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "country", Encoding: "dictionary"},
+			{Name: "city", Encoding: "plain"},
+			{
+				Name:     "subdivisions",
+				Path:     config.Path{"subdivisions", "*", "iso_code"},
+				Encoding: "dictionary",
+			},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	for i := range 100 {
+		require.NoError(t, writer.WriteRow(
+			netip.MustParsePrefix(fmt.Sprintf("10.0.%d.0/24", i)),
+			[]mmdbtype.DataType{
+				mmdbtype.String("US"),
+				mmdbtype.String(fmt.Sprintf("city%d", i)),
+				mmdbtype.Slice{mmdbtype.String("CA")},
+			},
+		))
+	}
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), pf.NumRows())
+
+	encodings := map[string][]format.Encoding{}
+	for _, chunk := range pf.Metadata().RowGroups[0].Columns {
+		name := strings.Join(chunk.MetaData.PathInSchema, ".")
+		encodings[name] = chunk.MetaData.Encoding
+	}
+	assert.Contains(t, encodings["start_int"], format.DeltaBinaryPacked)
+	assert.Contains(t, encodings["country"], format.RLEDictionary)
+	assert.NotContains(t, encodings["city"], format.RLEDictionary)
+	assert.Contains(t, encodings["subdivisions.list.element"], format.RLEDictionary)
+}
+
+func TestParquetWriter_DeltaEncodingRequiresInteger(t *testing.T) {
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{Compression: "none"},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "country", Encoding: "delta"},
+		},
+	}
+
+	_, err := NewParquetWriter(&bytes.Buffer{}, cfg)
+	require.ErrorContains(t, err, "delta encoding requires an integer column")
+}
+
 func TestParquetWriter_DataTypes(t *testing.T) {
 	buf := &bytes.Buffer{}
 
@@ -285,6 +418,86 @@ func TestParquetWriter_IPv6StartInt(t *testing.T) {
 	assert.Equal(t, 16, endCol.Node.Type().Length())
 }
 
+func TestParquetWriter_IPv6IntHalves(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "none",
+				RowGroupSize: 100,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "start_int_hi", Type: "start_int_hi"},
+				{Name: "start_int_lo", Type: "start_int_lo"},
+				{Name: "end_int_hi", Type: "end_int_hi"},
+				{Name: "end_int_lo", Type: "end_int_lo"},
+			},
+		},
+		Columns: []config.Column{},
+	}
+
+	// Integer halves work in a single file holding both IP versions.
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("1.2.3.0/24"),
+		[]mmdbtype.DataType{},
+	))
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("2001:db8::/64"),
+		[]mmdbtype.DataType{},
+	))
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	startCol, ok := pf.Schema().Lookup("start_int_hi")
+	require.True(t, ok)
+	assert.Equal(t, parquet.Int64, startCol.Node.Type().Kind())
+
+	sortingCols := pf.RowGroups()[0].SortingColumns()
+	require.Len(t, sortingCols, 2)
+	assert.Equal(t, []string{"start_int_hi"}, sortingCols[0].Path())
+	assert.Equal(t, []string{"start_int_lo"}, sortingCols[1].Path())
+
+	type halvesRow struct {
+		StartHi int64 `parquet:"start_int_hi"`
+		StartLo int64 `parquet:"start_int_lo"`
+		EndHi   int64 `parquet:"end_int_hi"`
+		EndLo   int64 `parquet:"end_int_lo"`
+	}
+	reader := parquet.NewGenericReader[halvesRow](bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+
+	rows := make([]halvesRow, 2)
+	n, err := reader.Read(rows)
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	require.Equal(t, 2, n)
+
+	// 1.2.3.0/24 as ::ffff:1.2.3.0 - ::ffff:1.2.3.255
+	assert.Equal(t, halvesRow{
+		StartHi: math.MinInt64,
+		StartLo: math.MinInt64 + 0x0000ffff01020300,
+		EndHi:   math.MinInt64,
+		EndLo:   math.MinInt64 + 0x0000ffff010203ff,
+	}, rows[0])
+
+	// 2001:db8::/64 spans the whole lower half
+	assert.Equal(t, halvesRow{
+		StartHi: math.MinInt64 + 0x20010db800000000,
+		StartLo: math.MinInt64,
+		EndHi:   math.MinInt64 + 0x20010db800000000,
+		EndLo:   math.MaxInt64,
+	}, rows[1])
+}
+
 func TestParquetWriter_Compression(t *testing.T) {
 	compressions := []string{"none", "snappy", "gzip", "lz4", "zstd"}
 
@@ -375,6 +588,284 @@ func TestParquetWriter_RowGroupSize(t *testing.T) {
 	assert.GreaterOrEqual(t, len(pf.RowGroups()), 2)
 }
 
+func TestParquetWriter_Batches(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "none",
+				RowGroupSize: 1500, // Not a multiple of the batch size
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "start_int", Type: "start_int"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "value", Type: "string"},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	const numRows = 5000
+	for i := range numRows {
+		addr := netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 0})
+		err := writer.WriteRow(netip.PrefixFrom(addr, 24), []mmdbtype.DataType{
+			mmdbtype.String(fmt.Sprintf("row%d", i)),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(numRows), pf.NumRows())
+	assert.Len(t, pf.RowGroups(), 4)
+
+	type batchRow struct {
+		StartInt int64  `parquet:"start_int"`
+		Value    string `parquet:"value"`
+	}
+	reader := parquet.NewGenericReader[batchRow](bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+
+	rows := make([]batchRow, numRows)
+	n, err := reader.Read(rows)
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	require.Equal(t, numRows, n)
+	for i, row := range rows {
+		assert.Equal(t, int64(0x0a000000+i<<8), row.StartInt)
+		assert.Equal(t, fmt.Sprintf("row%d", i), row.Value)
+	}
+}
+
+func TestParquetWriter_RowGroupBytes(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	const rowGroupBytes = 16 * 1024
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:   "none",
+				RowGroupBytes: rowGroupBytes,
+				PageSize:      1024,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "start_int", Type: "start_int"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "value", Type: "string"},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	const numRows = 10000
+	for i := range numRows {
+		prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 0}), 32)
+		err := writer.WriteRow(prefix, []mmdbtype.DataType{
+			mmdbtype.String(fmt.Sprintf("value-%06d", i)),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(numRows), pf.NumRows())
+
+	rowGroups := pf.Metadata().RowGroups
+	require.Greater(t, len(rowGroups), 2)
+	for _, rg := range rowGroups[:len(rowGroups)-1] {
+		// The size of buffered, not yet encoded values is estimated, so row
+		// groups are only approximately the target size.
+		assert.InEpsilon(t, rowGroupBytes, rg.TotalByteSize, 0.1)
+	}
+}
+
+func TestParquetWriter_RowGroupBoundary(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:          "none",
+				RowGroupSize:         3,
+				IPv4RowGroupBoundary: 16,
+				IPv6RowGroupBoundary: 32,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "value", Type: "string"},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	networks := []string{
+		// A full row group is not cut inside 10.0.0.0/16.
+		"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24", "10.0.4.0/24",
+		// Not full when leaving 10.1.0.0/16, so 10.2.0.0/24 joins it.
+		"10.1.0.0/24", "10.1.1.0/24",
+		"10.2.0.0/24",
+		// Changing IP version always crosses a boundary.
+		"2001:db8::/48", "2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:3::/48",
+		"2001:db9::/48",
+	}
+	for _, network := range networks {
+		err := writer.WriteRow(
+			netip.MustParsePrefix(network),
+			[]mmdbtype.DataType{mmdbtype.String(network)},
+		)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	var sizes []int64
+	for _, rg := range pf.RowGroups() {
+		sizes = append(sizes, rg.NumRows())
+	}
+	assert.Equal(t, []int64{5, 3, 4, 1}, sizes)
+}
+
+func TestParquetWriter_SetKeyValueMetadata(t *testing.T) {
+	tests := []struct {
+		name       string
+		columnType string
+	}{
+		{name: "writer already open", columnType: "string"},
+		{name: "writer opened after schema inference", columnType: "struct"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			cfg := &config.Config{
+				Output: config.OutputConfig{
+					Parquet: config.ParquetConfig{
+						Compression:      "none",
+						RowGroupSize:     500000,
+						SchemaSampleRows: 10,
+					},
+				},
+				Network: config.NetworkConfig{
+					Columns: []config.NetworkColumn{{Name: "network", Type: "cidr"}},
+				},
+				Columns: []config.Column{{Name: "city", Type: tt.columnType}},
+			}
+
+			writer, err := NewParquetWriter(buf, cfg)
+			require.NoError(t, err)
+
+			writer.SetKeyValueMetadata(ProvenanceKey, `{"mmdbconvert_version":"1.0.0"}`)
+
+			var value mmdbtype.DataType = mmdbtype.String("London")
+			if tt.columnType == "struct" {
+				value = mmdbtype.Map{"name": value}
+			}
+			prefix := netip.MustParsePrefix("10.0.0.0/24")
+			require.NoError(t, writer.WriteRow(prefix, []mmdbtype.DataType{value}))
+			require.NoError(t, writer.Flush())
+
+			pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			got, ok := pf.Lookup(ProvenanceKey)
+			require.True(t, ok)
+			assert.JSONEq(t, `{"mmdbconvert_version":"1.0.0"}`, got)
+		})
+	}
+}
+
+func TestParquetWriter_PageIndexAndBloomFilters(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:             "none",
+				RowGroupSize:            500000,
+				PageSize:                1024,
+				ColumnIndexSizeLimit:    64,
+				BloomFilterColumns:      []string{"country_iso"},
+				BloomFilterBitsPerValue: 10,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "start_ip", Type: "start_ip"},
+				{Name: "start_int", Type: "start_int"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "country_iso", Type: "string"},
+		},
+	}
+
+	writer, err := NewParquetWriterWithIPVersion(buf, cfg, IPVersion6)
+	require.NoError(t, err)
+
+	countries := []string{"DE", "FR", "US"}
+	const numRows = 2000
+	for i := range numRows {
+		addr := netip.AddrFrom16([16]byte{
+			0x20, 0x01, 0x0d, 0xb8, 0xff, 0xff, 0xff, 0xff,
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, byte(i >> 8), byte(i),
+		})
+		data := []mmdbtype.DataType{mmdbtype.String(countries[i%len(countries)])}
+		require.NoError(t, writer.WriteRow(netip.PrefixFrom(addr, 128), data))
+	}
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, pf.RowGroups(), 1)
+	chunks := pf.RowGroups()[0].ColumnChunks()
+
+	leaf := func(name string) parquet.ColumnChunk {
+		column, ok := pf.Schema().Lookup(name)
+		require.True(t, ok, "column %s", name)
+		return chunks[column.ColumnIndex]
+	}
+
+	// Small pages produce many entries in the column index.
+	columnIndex, err := leaf("start_int").ColumnIndex()
+	require.NoError(t, err)
+	assert.Greater(t, columnIndex.NumPages(), 1)
+
+	// Page bounds of long strings are not truncated below the limit.
+	columnIndex, err = leaf("start_ip").ColumnIndex()
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8:ffff:ffff:ffff:ffff:ffff:0", columnIndex.MinValue(0).String())
+
+	bloom := leaf("country_iso").BloomFilter()
+	require.NotNil(t, bloom)
+	found, err := bloom.Check(parquet.ValueOf("FR"))
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	assert.Nil(t, leaf("start_ip").BloomFilter())
+}
+
 func TestParquetWriter_SortingMetadata(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -482,6 +973,142 @@ func TestParquetWriter_SortingMetadata(t *testing.T) {
 	}
 }
 
+func TestParquetWriter_ListColumns(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "none",
+				RowGroupSize: 500000,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "subdivisions", Path: config.Path{"subdivisions", "*", "iso_code"}},
+			{
+				Name: "geoname_ids",
+				Path: config.Path{"subdivisions", "*", "geoname_id"},
+				Type: "int64",
+			},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("10.0.0.0/24"),
+		[]mmdbtype.DataType{
+			mmdbtype.Slice{mmdbtype.String("ENG"), mmdbtype.String("LND")},
+			mmdbtype.Slice{mmdbtype.Uint32(6269131), mmdbtype.Uint32(2643743)},
+		},
+	))
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("10.0.1.0/24"),
+		[]mmdbtype.DataType{nil, nil},
+	))
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Contains(t, pf.Schema().String(), "optional group subdivisions (LIST)")
+
+	type listRow struct {
+		Network      string   `parquet:"network"`
+		Subdivisions []string `parquet:"subdivisions,list"`
+		GeonameIDs   []int64  `parquet:"geoname_ids,list"`
+	}
+	reader := parquet.NewGenericReader[listRow](bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+
+	rows := make([]listRow, 2)
+	n, err := reader.Read(rows)
+	if err != nil {
+		require.ErrorIs(t, err, io.EOF)
+	}
+	require.Equal(t, 2, n)
+	assert.Equal(t, []string{"ENG", "LND"}, rows[0].Subdivisions)
+	assert.Equal(t, []int64{6269131, 2643743}, rows[0].GeonameIDs)
+	assert.Empty(t, rows[1].Subdivisions)
+	assert.Empty(t, rows[1].GeonameIDs)
+}
+
+func TestParquetWriter_UnsignedAndDecimalTypes(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	cfg := &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:  "none",
+				RowGroupSize: 500000,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "asn", Type: "uint32"},
+			{Name: "counter", Type: "uint64"},
+			{Name: "big", Type: "uint128"},
+			{Name: "latitude", Type: "decimal(9,6)"},
+			{Name: "total", Type: "decimal(30,2)"},
+		},
+	}
+
+	writer, err := NewParquetWriter(buf, cfg)
+	require.NoError(t, err)
+
+	big128 := new(big.Int).Lsh(big.NewInt(1), 100)
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("10.0.0.0/24"),
+		[]mmdbtype.DataType{
+			mmdbtype.Uint32(4200000000),
+			mmdbtype.Uint64(18000000000000000000),
+			(*mmdbtype.Uint128)(big128),
+			mmdbtype.Float64(51.507351),
+			mmdbtype.Float64(12.5),
+		},
+	))
+	require.NoError(t, writer.WriteRow(
+		netip.MustParsePrefix("10.0.1.0/24"),
+		[]mmdbtype.DataType{nil, nil, nil, nil, nil},
+	))
+	require.NoError(t, writer.Flush())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	schema := pf.Schema().String()
+	assert.Contains(t, schema, "optional int32 asn (INT(32,false))")
+	assert.Contains(t, schema, "optional int64 counter (INT(64,false))")
+	assert.Contains(t, schema, "optional fixed_len_byte_array(16) big;")
+	assert.Contains(t, schema, "optional int32 latitude (DECIMAL(9,6))")
+	assert.Contains(t, schema, "optional fixed_len_byte_array(16) total (DECIMAL(30,2))")
+
+	rows := readParquetRows(t, buf)
+	require.Len(t, rows, 2)
+
+	// Unsigned values are stored as the bit pattern of the signed physical type.
+	assert.Equal(t, uint32(4200000000), uint32(rows[0]["asn"].(int32)))
+	assert.Equal(t, uint64(18000000000000000000), uint64(rows[0]["counter"].(int64)))
+	assert.Equal(t, big128.FillBytes(make([]byte, 16)), rows[0]["big"])
+	assert.Equal(t, int32(51507351), rows[0]["latitude"])
+	assert.Equal(t, big.NewInt(1250).FillBytes(make([]byte, 16)), rows[0]["total"])
+
+	assert.Nil(t, rows[1]["asn"])
+	assert.Nil(t, rows[1]["counter"])
+	assert.Nil(t, rows[1]["big"])
+	assert.Nil(t, rows[1]["latitude"])
+	assert.Nil(t, rows[1]["total"])
+}
+
 func TestConvertToParquetType(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -501,6 +1128,37 @@ func TestConvertToParquetType(t *testing.T) {
 		{"binary", mmdbtype.Bytes([]byte{0xaa, 0xbb}), "binary", []byte{0xaa, 0xbb}, false},
 		{"invalid int conversion", mmdbtype.String("hello"), "int64", nil, true},
 		{"invalid bool conversion", mmdbtype.String("true"), "bool", nil, true},
+		{"uint32", mmdbtype.Uint32(4294967295), "uint32", uint32(4294967295), false},
+		{"uint64 overflows uint32", mmdbtype.Uint64(1 << 32), "uint32", nil, true},
+		{"negative to uint32", mmdbtype.Int32(-1), "uint32", nil, true},
+		{"uint64 above int64", mmdbtype.Uint64(1 << 63), "uint64", uint64(1 << 63), false},
+		{
+			"uint128 to uint128",
+			(*mmdbtype.Uint128)(new(big.Int).Lsh(big.NewInt(1), 127)),
+			"uint128",
+			append([]byte{0x80}, make([]byte, 15)...),
+			false,
+		},
+		{
+			"uint32 to uint128",
+			mmdbtype.Uint32(0x01020304),
+			"uint128",
+			[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4},
+			false,
+		},
+		{"float64 to decimal", mmdbtype.Float64(-74.006), "decimal(9,6)", int32(-74006000), false},
+		{"float32 to decimal", mmdbtype.Float32(40.7128), "decimal(9,4)", int32(407128), false},
+		{"decimal rounding", mmdbtype.Float64(1.25), "decimal(18,1)", int64(12), false},
+		{"uint32 to decimal", mmdbtype.Uint32(42), "decimal(18,2)", int64(4200), false},
+		{
+			"negative decimal above 18 digits",
+			mmdbtype.Int32(-1),
+			"decimal(20,0)",
+			bytes.Repeat([]byte{0xff}, 16),
+			false,
+		},
+		{"decimal overflow", mmdbtype.Float64(1000), "decimal(5,2)", nil, true},
+		{"string to decimal", mmdbtype.String("1.5"), "decimal(5,2)", nil, true},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,619 @@
+package writer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/expr"
+	"github.com/maxmind/mmdbconvert/internal/network"
+)
+
+const (
+	// ManifestFile is the name of the JSON file listing the files of a
+	// partitioned dataset.
+	ManifestFile = "_manifest.json"
+	// SuccessFile is the name of the empty file written last to mark a
+	// partitioned dataset as complete.
+	SuccessFile = "_SUCCESS"
+
+	// defaultPartition is the Hive directory value for null and empty values.
+	defaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+	// defaultMaxOpenFiles is the default of output.parquet.max_open_files.
+	defaultMaxOpenFiles = 64
+)
+
+// PartitionedWriter writes a Hive-style partitioned Parquet dataset: one
+// directory level per partition key, e.g. ip_version=4/country=US, each
+// holding part-0000.parquet, part-0001.parquet, ... files written by a
+// ParquetWriter. Partition columns are encoded in the directory names and
+// are not written to the files.
+type PartitionedWriter struct {
+	dir         string
+	config      *config.Config // Config of the dataset files, without partition columns
+	keys        []partitionKey
+	keep        []int // Indexes of the data columns written to the files
+	maxRows     int   // Rows per file, or 0 for no limit
+	provenance  *Provenance
+	metadata    string       // Encoded provenance
+	fileSuffix  string       // Appended to file names, e.g. to keep them unique across table versions
+	nestedTypes *NestedTypes // Types of the nested columns of the files, if known up front
+	warnings    io.Writer    // Receives warnings of the files' ParquetWriters
+	partitions  map[string]*partition
+	unfinished  []*partition // Partitions whose current file is not finished
+	open        []*partition // Unfinished partitions whose file is open, least recently written first
+	maxOpen     int          // Files kept open at once
+	last        *partition   // Partition of the last row written
+	files       []datasetFile
+	values      []string            // Partition values of the current row
+	data        []mmdbtype.DataType // Data of the current row without partition columns
+}
+
+// partitionKey is a column or network property the dataset is partitioned
+// by.
+type partitionKey struct {
+	name   string
+	column int // Index of the data column, or -1 for ip_version and network_prefix
+}
+
+// partition is a directory of the dataset and its currently open file.
+type partition struct {
+	path      string   // Directory relative to the dataset root, "" if unpartitioned
+	values    []string // Partition values, in key order
+	ipVersion int
+	files     int // Files started so far
+	file      *partitionFile
+	writer    *ParquetWriter
+	rows      int // Rows written to the open file
+}
+
+// DatasetFile describes one file of a partitioned dataset in its manifest.
+type DatasetFile struct {
+	Path      string            `json:"path"`
+	Partition map[string]string `json:"partition,omitempty"`
+	Rows      int64             `json:"rows"`
+}
+
+// datasetFile is a finished file of the dataset.
+type datasetFile struct {
+	DatasetFile
+	values []string        // Partition values, in key order
+	schema *parquet.Schema // Schema of the file
+}
+
+// datasetManifest is the content of the manifest file.
+type datasetManifest struct {
+	PartitionBy []string      `json:"partition_by"`
+	Files       []DatasetFile `json:"files"`
+	Provenance  *Provenance   `json:"provenance,omitempty"`
+}
+
+// NewPartitionedWriter creates a writer of a partitioned Parquet dataset in
+// dir, which is created if needed and must otherwise be empty. The dataset is
+// partitioned by output.parquet.partition_by, and a new file is started in a
+// partition once output.parquet.max_rows_per_file rows have been written to
+// the current one. The provenance, if not nil, is recorded in each file and
+// in the manifest.
+func NewPartitionedWriter(
+	dir string,
+	cfg *config.Config,
+	provenance *Provenance,
+) (*PartitionedWriter, error) {
+	if err := createDatasetDir(dir); err != nil {
+		return nil, err
+	}
+	return newPartitionedWriter(dir, cfg, provenance, "")
+}
+
+// newPartitionedWriter creates a PartitionedWriter writing into the existing
+// directory dir. fileSuffix is appended to the name of each file.
+func newPartitionedWriter(
+	dir string,
+	cfg *config.Config,
+	provenance *Provenance,
+	fileSuffix string,
+) (*PartitionedWriter, error) {
+	columnIndex := make(map[string]int, len(cfg.Columns))
+	for i, col := range cfg.Columns {
+		columnIndex[string(col.Name)] = i
+	}
+
+	keys := make([]partitionKey, 0, len(cfg.Output.Parquet.PartitionBy))
+	partitioned := make([]bool, len(cfg.Columns))
+	for _, name := range cfg.Output.Parquet.PartitionBy {
+		if name == config.PartitionIPVersion || name == config.PartitionNetworkPrefix {
+			keys = append(keys, partitionKey{name: name, column: -1})
+			continue
+		}
+		i, ok := columnIndex[name]
+		if !ok {
+			return nil, fmt.Errorf("partition key '%s' is not a configured column", name)
+		}
+		keys = append(keys, partitionKey{name: name, column: i})
+		partitioned[i] = true
+	}
+
+	filesConfig := *cfg
+	filesConfig.Columns = nil
+	var keep []int
+	for i, col := range cfg.Columns {
+		if !partitioned[i] {
+			filesConfig.Columns = append(filesConfig.Columns, col)
+			keep = append(keep, i)
+		}
+	}
+
+	maxOpen := cfg.Output.Parquet.MaxOpenFiles
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenFiles
+	}
+
+	var metadata string
+	if provenance != nil {
+		encoded, err := provenance.JSON()
+		if err != nil {
+			return nil, err
+		}
+		metadata = encoded
+	}
+
+	return &PartitionedWriter{
+		dir:        dir,
+		config:     &filesConfig,
+		keys:       keys,
+		keep:       keep,
+		maxRows:    cfg.Output.Parquet.MaxRowsPerFile,
+		provenance: provenance,
+		metadata:   metadata,
+		fileSuffix: fileSuffix,
+		partitions: map[string]*partition{},
+		maxOpen:    maxOpen,
+		values:     make([]string, len(keys)),
+		data:       make([]mmdbtype.DataType, 0, len(keep)),
+	}, nil
+}
+
+// createDatasetDir creates dir, refusing to write into a non-empty directory
+// so that files of an earlier run don't become part of the dataset.
+func createDatasetDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	switch {
+	case err == nil && len(entries) > 0:
+		return fmt.Errorf("output directory %s is not empty", dir)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("reading output directory %s: %w", dir, err)
+	}
+	// #nosec G301 -- the dataset is meant to be read by other tools
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating output directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// SetWarnings sets the writer that receives warnings about values that are
+// not written as is (see ParquetWriter.SetWarnings).
+func (w *PartitionedWriter) SetWarnings(warnings io.Writer) {
+	w.warnings = warnings
+}
+
+// SetNestedTypes sets the types of the nested columns inferred by
+// InferNestedTypes, so that all files of the dataset share them rather than
+// each inferring its own from its rows. It must be called before any row is
+// written.
+func (w *PartitionedWriter) SetNestedTypes(types *NestedTypes) error {
+	if types == nil {
+		return nil
+	}
+	if len(w.partitions) > 0 {
+		return errors.New("nested types must be set before any row is written")
+	}
+	// The files don't have the partition columns.
+	w.nestedTypes = &NestedTypes{types: make([]*nestedType, len(w.keep))}
+	for j, i := range w.keep {
+		w.nestedTypes.types[j] = types.types[i]
+	}
+	return nil
+}
+
+// WriteRow writes the row to the file of its partition. When partitioning by
+// network_prefix, a network larger than the partition prefix is split into
+// one row per partition it spans.
+func (w *PartitionedWriter) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType) error {
+	if !slices.Contains(w.config.Output.Parquet.PartitionBy, config.PartitionNetworkPrefix) {
+		return w.writePartitionRow(prefix, data)
+	}
+
+	prefixes, err := network.SplitPrefix(prefix, w.partitionPrefixBits(prefix.Addr()))
+	if err != nil {
+		return fmt.Errorf("splitting network into partitions: %w", err)
+	}
+	for _, p := range prefixes {
+		if err := w.writePartitionRow(p, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitionPrefixBits returns the prefix length of network_prefix partitions
+// holding addr.
+func (w *PartitionedWriter) partitionPrefixBits(addr netip.Addr) int {
+	if addr.Is4() {
+		return w.config.Output.Parquet.IPv4PartitionPrefix
+	}
+	return w.config.Output.Parquet.IPv6PartitionPrefix
+}
+
+// writePartitionRow writes a row that lies within a single partition.
+func (w *PartitionedWriter) writePartitionRow(
+	prefix netip.Prefix,
+	data []mmdbtype.DataType,
+) error {
+	for i, key := range w.keys {
+		value, err := w.partitionValue(key, prefix, data)
+		if err != nil {
+			return err
+		}
+		w.values[i] = value
+	}
+
+	p, err := w.partition(prefix.Addr())
+	if err != nil {
+		return err
+	}
+
+	if p.writer != nil && w.maxRows > 0 && p.rows >= w.maxRows {
+		if err := w.finishFile(p); err != nil {
+			return err
+		}
+	}
+	if err := w.use(p); err != nil {
+		return err
+	}
+	if p.writer == nil {
+		if err := w.startFile(p); err != nil {
+			return err
+		}
+	}
+
+	w.data = w.data[:0]
+	for _, i := range w.keep {
+		w.data = append(w.data, data[i])
+	}
+	if err := p.writer.WriteRow(prefix, w.data); err != nil {
+		return fmt.Errorf("writing to partition %s: %w", p.path, err)
+	}
+	p.rows++
+	return nil
+}
+
+// partitionValue returns the value of key for the row.
+func (w *PartitionedWriter) partitionValue(
+	key partitionKey,
+	prefix netip.Prefix,
+	data []mmdbtype.DataType,
+) (string, error) {
+	if key.column < 0 {
+		addr := prefix.Addr()
+		if key.name == config.PartitionNetworkPrefix {
+			return netip.PrefixFrom(addr, w.partitionPrefixBits(addr)).Masked().String(), nil
+		}
+		if addr.Is4() {
+			return "4", nil
+		}
+		return "6", nil
+	}
+
+	value, err := expr.FormatScalar(data[key.column])
+	if err != nil {
+		return "", fmt.Errorf("partition key '%s': %w", key.name, err)
+	}
+	return value, nil
+}
+
+// partition returns the partition of the current row's values, creating it
+// if needed. Rows arrive in address order, so once the ip_version or
+// network_prefix value of the rows changes, every unfinished partition is
+// complete and its file is finished to free its memory.
+func (w *PartitionedWriter) partition(addr netip.Addr) (*partition, error) {
+	if w.last != nil && slices.Equal(w.last.values, w.values) {
+		return w.last, nil
+	}
+
+	if w.last != nil && w.networkValuesChanged() {
+		if err := w.finishAll(); err != nil {
+			return nil, err
+		}
+	}
+
+	segments := make([]string, len(w.keys))
+	for i, key := range w.keys {
+		segments[i] = key.name + "=" + escapePartitionValue(w.values[i])
+	}
+	path := strings.Join(segments, "/")
+
+	p, ok := w.partitions[path]
+	if !ok {
+		p = &partition{
+			path:   path,
+			values: slices.Clone(w.values),
+		}
+		if w.config.Output.Parquet.SplitsIPVersions() {
+			p.ipVersion = IPVersion6
+			if addr.Is4() {
+				p.ipVersion = IPVersion4
+			}
+		}
+		w.partitions[path] = p
+	}
+
+	w.last = p
+	return p, nil
+}
+
+// use makes the partition the most recently written one with an open file.
+// When maxOpen files are already open, the least recently written partition
+// is evicted: the rows buffered for its current row group are written out
+// and its file is closed, to be reopened for appending when the partition
+// is written again.
+func (w *PartitionedWriter) use(p *partition) error {
+	if n := len(w.open); n > 0 && w.open[n-1] == p {
+		return nil
+	}
+	if i := slices.Index(w.open, p); i >= 0 {
+		w.open = append(slices.Delete(w.open, i, i+1), p)
+		return nil
+	}
+
+	if len(w.open) >= w.maxOpen {
+		evicted := w.open[0]
+		if err := evicted.writer.spill(); err != nil {
+			return fmt.Errorf("writing buffered rows of partition %s: %w", evicted.path, err)
+		}
+		if err := evicted.file.Close(); err != nil {
+			return fmt.Errorf("closing %s: %w", evicted.file.path, err)
+		}
+		w.open = slices.Delete(w.open, 0, 1)
+	}
+	w.open = append(w.open, p)
+	return nil
+}
+
+// networkValuesChanged reports whether the current row's values of the keys
+// derived from the network differ from those of the last row.
+func (w *PartitionedWriter) networkValuesChanged() bool {
+	for i, key := range w.keys {
+		if key.column < 0 && w.values[i] != w.last.values[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// startFile creates the next file of the partition.
+func (w *PartitionedWriter) startFile(p *partition) error {
+	dir := filepath.Join(w.dir, filepath.FromSlash(p.path))
+	// #nosec G301 -- the dataset is meant to be read by other tools
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating partition directory %s: %w", dir, err)
+	}
+
+	name := fmt.Sprintf("part-%04d%s.parquet", p.files, w.fileSuffix)
+	path := filepath.Join(dir, name)
+	file, err := createFile(path)
+	if err != nil {
+		return err
+	}
+
+	out := &partitionFile{path: path, file: file}
+	writer, err := NewParquetWriterWithIPVersion(out, w.config, p.ipVersion)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("creating Parquet writer for partition %s: %w", p.path, err)
+	}
+	writer.SetWarnings(w.warnings)
+	if err := writer.SetNestedTypes(w.nestedTypes); err != nil {
+		file.Close()
+		return fmt.Errorf("creating Parquet writer for partition %s: %w", p.path, err)
+	}
+	if w.metadata != "" {
+		writer.SetKeyValueMetadata(ProvenanceKey, w.metadata)
+	}
+
+	p.files++
+	p.file = out
+	p.writer = writer
+	p.rows = 0
+	w.unfinished = append(w.unfinished, p)
+	return nil
+}
+
+// finishFile flushes and closes the open file of the partition and records
+// it for the manifest.
+func (w *PartitionedWriter) finishFile(p *partition) error {
+	if err := p.writer.Flush(); err != nil {
+		return fmt.Errorf("flushing partition %s: %w", p.path, err)
+	}
+	if err := p.file.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", p.file.path, err)
+	}
+
+	rel, err := filepath.Rel(w.dir, p.file.path)
+	if err != nil {
+		return fmt.Errorf("locating %s: %w", p.file.path, err)
+	}
+	file := datasetFile{
+		DatasetFile: DatasetFile{Path: filepath.ToSlash(rel), Rows: p.writer.NumRows()},
+		values:      p.values,
+		schema:      p.writer.schema,
+	}
+	if len(w.keys) > 0 {
+		file.Partition = make(map[string]string, len(w.keys))
+		for i, key := range w.keys {
+			file.Partition[key.name] = p.values[i]
+		}
+	}
+	w.files = append(w.files, file)
+
+	p.file = nil
+	p.writer = nil
+	if i := slices.Index(w.unfinished, p); i >= 0 {
+		w.unfinished = slices.Delete(w.unfinished, i, i+1)
+	}
+	if i := slices.Index(w.open, p); i >= 0 {
+		w.open = slices.Delete(w.open, i, i+1)
+	}
+	return nil
+}
+
+// Flush finishes the open file of every partition and writes the manifest
+// and, last, the _SUCCESS marker.
+func (w *PartitionedWriter) Flush() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	files := make([]DatasetFile, len(w.files))
+	for i, f := range w.files {
+		files[i] = f.DatasetFile
+	}
+	manifest := datasetManifest{
+		PartitionBy: w.config.Output.Parquet.PartitionBy,
+		Files:       files,
+		Provenance:  w.provenance,
+	}
+	if manifest.PartitionBy == nil {
+		manifest.PartitionBy = []string{}
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	b = append(b, '\n')
+
+	manifestPath := filepath.Join(w.dir, ManifestFile)
+	// #nosec G306 -- the manifest is not sensitive and sits next to the output
+	if err := os.WriteFile(manifestPath, b, 0o644); err != nil {
+		return fmt.Errorf("writing manifest %s: %w", manifestPath, err)
+	}
+
+	successPath := filepath.Join(w.dir, SuccessFile)
+	// #nosec G306 -- the marker is empty
+	if err := os.WriteFile(successPath, nil, 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", successPath, err)
+	}
+	return nil
+}
+
+// finish finishes the file of every partition and sorts the finished files
+// by path.
+func (w *PartitionedWriter) finish() error {
+	if err := w.finishAll(); err != nil {
+		return err
+	}
+
+	slices.SortFunc(w.files, func(a, b datasetFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return nil
+}
+
+// finishAll finishes the current file of every unfinished partition, in
+// path order.
+func (w *PartitionedWriter) finishAll() error {
+	unfinished := slices.Clone(w.unfinished)
+	slices.SortFunc(unfinished, func(a, b *partition) int {
+		return strings.Compare(a.path, b.path)
+	})
+	for _, p := range unfinished {
+		if err := w.finishFile(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the files left open when the dataset is not flushed, e.g.
+// after an error. It leaves the incomplete files in place.
+func (w *PartitionedWriter) Close() error {
+	var errs []error
+	for _, p := range w.unfinished {
+		errs = append(errs, p.file.Close())
+		p.file = nil
+		p.writer = nil
+	}
+	w.unfinished = nil
+	w.open = nil
+	return errors.Join(errs...)
+}
+
+// partitionFile is the file a partition's ParquetWriter writes to. It is
+// closed while the partition is evicted from the open files and reopened for
+// appending when the writer writes to it again.
+type partitionFile struct {
+	path string
+	file *os.File // nil while closed
+}
+
+// Write implements io.Writer.
+func (f *partitionFile) Write(b []byte) (int, error) {
+	if f.file == nil {
+		// #nosec G304 -- the file was created by this writer
+		file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return 0, fmt.Errorf("reopening %s: %w", f.path, err)
+		}
+		f.file = file
+	}
+	return f.file.Write(b)
+}
+
+// Close closes the file if it is open.
+func (f *partitionFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// createFile creates the file at path.
+func createFile(path string) (*os.File, error) {
+	// #nosec G304 -- paths come from trusted configuration
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// escapePartitionValue escapes a partition value for use in a directory name
+// the way Hive does, so that "1.0.0.0/8" becomes "1.0.0.0%2F8". Null and
+// empty values become __HIVE_DEFAULT_PARTITION__.
+func escapePartitionValue(value string) string {
+	if value == "" {
+		return defaultPartition
+	}
+	var b strings.Builder
+	for i := range len(value) {
+		c := value[i]
+		if c < 0x20 || c == 0x7F || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,348 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+)
+
+func partitionTestConfig(partitionBy []string, maxRows int) *config.Config {
+	return &config.Config{
+		Output: config.OutputConfig{
+			Parquet: config.ParquetConfig{
+				Compression:         "none",
+				RowGroupSize:        500000,
+				PartitionBy:         partitionBy,
+				IPv4PartitionPrefix: 8,
+				IPv6PartitionPrefix: 16,
+				MaxRowsPerFile:      maxRows,
+			},
+		},
+		Network: config.NetworkConfig{
+			Columns: []config.NetworkColumn{
+				{Name: "network", Type: "cidr"},
+			},
+		},
+		Columns: []config.Column{
+			{Name: "country", Type: "string"},
+			{Name: "city", Type: "string"},
+		},
+	}
+}
+
+func readManifest(t *testing.T, dir string) datasetManifest {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	require.NoError(t, err)
+	var manifest datasetManifest
+	require.NoError(t, json.Unmarshal(b, &manifest))
+	return manifest
+}
+
+func readDatasetFile(t *testing.T, dir, path string) []map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+	require.NoError(t, err)
+	return readParquetRows(t, bytes.NewBuffer(b))
+}
+
+func TestPartitionedWriter_PartitionsByColumnAndIPVersion(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dataset")
+	cfg := partitionTestConfig([]string{"ip_version", "country"}, 0)
+
+	w, err := NewPartitionedWriter(dir, cfg, &Provenance{Version: "1.2.3"})
+	require.NoError(t, err)
+
+	rows := []struct {
+		prefix string
+		data   []mmdbtype.DataType
+	}{
+		{"1.0.0.0/24", []mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.String("Dallas")}},
+		{"2.0.0.0/24", []mmdbtype.DataType{mmdbtype.String("DE"), mmdbtype.String("Berlin")}},
+		{"3.0.0.0/24", []mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.String("Austin")}},
+		{"4.0.0.0/24", []mmdbtype.DataType{nil, mmdbtype.String("Nowhere")}},
+		{"2001:db8::/32", []mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.String("Boston")}},
+	}
+	for _, row := range rows {
+		require.NoError(t, w.WriteRow(netip.MustParsePrefix(row.prefix), row.data))
+	}
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Close())
+
+	_, err = os.Stat(filepath.Join(dir, SuccessFile))
+	require.NoError(t, err)
+
+	manifest := readManifest(t, dir)
+	assert.Equal(t, []string{"ip_version", "country"}, manifest.PartitionBy)
+	require.NotNil(t, manifest.Provenance)
+	assert.Equal(t, "1.2.3", manifest.Provenance.Version)
+	assert.Equal(t, []DatasetFile{
+		{
+			Path:      "ip_version=4/country=DE/part-0000.parquet",
+			Partition: map[string]string{"ip_version": "4", "country": "DE"},
+			Rows:      1,
+		},
+		{
+			Path:      "ip_version=4/country=US/part-0000.parquet",
+			Partition: map[string]string{"ip_version": "4", "country": "US"},
+			Rows:      2,
+		},
+		{
+			Path:      "ip_version=4/country=__HIVE_DEFAULT_PARTITION__/part-0000.parquet",
+			Partition: map[string]string{"ip_version": "4", "country": ""},
+			Rows:      1,
+		},
+		{
+			Path:      "ip_version=6/country=US/part-0000.parquet",
+			Partition: map[string]string{"ip_version": "6", "country": "US"},
+			Rows:      1,
+		},
+	}, manifest.Files)
+
+	// The partition column is only in the directory name.
+	got := readDatasetFile(t, dir, "ip_version=4/country=US/part-0000.parquet")
+	assert.Equal(t, []map[string]any{
+		{"network": "1.0.0.0/24", "city": "Dallas"},
+		{"network": "3.0.0.0/24", "city": "Austin"},
+	}, got)
+
+	b, err := os.ReadFile(filepath.Join(dir, "ip_version=6", "country=US", "part-0000.parquet"))
+	require.NoError(t, err)
+	pf, err := parquet.OpenFile(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	provenance, ok := pf.Lookup(ProvenanceKey)
+	require.True(t, ok)
+	assert.Contains(t, provenance, `"mmdbconvert_version":"1.2.3"`)
+}
+
+func TestPartitionedWriter_MaxRowsPerFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig(nil, 2)
+
+	w, err := NewPartitionedWriter(dir, cfg, nil)
+	require.NoError(t, err)
+	for _, prefix := range []string{"1.0.0.0/24", "1.0.1.0/24", "1.0.2.0/24", "1.0.3.0/24", "1.0.4.0/24"} {
+		require.NoError(t, w.WriteRow(
+			netip.MustParsePrefix(prefix),
+			[]mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.String("Dallas")},
+		))
+	}
+	require.NoError(t, w.Flush())
+
+	manifest := readManifest(t, dir)
+	assert.Empty(t, manifest.PartitionBy)
+	assert.Nil(t, manifest.Provenance)
+	assert.Equal(t, []DatasetFile{
+		{Path: "part-0000.parquet", Rows: 2},
+		{Path: "part-0001.parquet", Rows: 2},
+		{Path: "part-0002.parquet", Rows: 1},
+	}, manifest.Files)
+
+	got := readDatasetFile(t, dir, "part-0001.parquet")
+	assert.Equal(t, []map[string]any{
+		{"network": "1.0.2.0/24", "country": "US", "city": "Dallas"},
+		{"network": "1.0.3.0/24", "country": "US", "city": "Dallas"},
+	}, got)
+}
+
+func TestPartitionedWriter_BoundsOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig([]string{"country"}, 0)
+	cfg.Output.Parquet.MaxOpenFiles = 3
+
+	w, err := NewPartitionedWriter(dir, cfg, nil)
+	require.NoError(t, err)
+
+	// Rows cycle through more partitions than files may be open, as rows in
+	// address order do for a column such as the country.
+	countries := []string{"AU", "BR", "CA", "DE", "FR", "JP", "US"}
+	const rounds = 3
+	for i := range rounds * len(countries) {
+		prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(i + 1), 0, 0, 0}), 24)
+		require.NoError(t, w.WriteRow(
+			prefix,
+			[]mmdbtype.DataType{mmdbtype.String(countries[i%len(countries)]), mmdbtype.String("City")},
+		))
+		assert.LessOrEqual(t, openPartitionFiles(w), 3, "after row %d", i)
+	}
+	require.NoError(t, w.Flush())
+
+	// Evicted partitions append to their file when they are written again,
+	// so each partition has a single file holding all its rows.
+	manifest := readManifest(t, dir)
+	require.Len(t, manifest.Files, len(countries))
+	for i, f := range manifest.Files {
+		assert.Equal(t, "country="+countries[i]+"/part-0000.parquet", f.Path)
+		assert.Equal(t, int64(rounds), f.Rows, f.Path)
+
+		got := readDatasetFile(t, dir, f.Path)
+		require.Len(t, got, rounds, f.Path)
+		for round, row := range got {
+			network := netip.PrefixFrom(
+				netip.AddrFrom4([4]byte{byte(round*len(countries) + i + 1), 0, 0, 0}),
+				24,
+			)
+			assert.Equal(t, network.String(), row["network"], f.Path)
+		}
+	}
+}
+
+func TestPartitionedWriter_FinishesFilesOfPassedNetworks(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig([]string{"network_prefix", "country"}, 0)
+
+	w, err := NewPartitionedWriter(dir, cfg, nil)
+	require.NoError(t, err)
+
+	rows := []struct {
+		prefix  string
+		country string
+		open    int
+	}{
+		{"1.0.0.0/24", "US", 1},
+		{"1.1.0.0/24", "DE", 2},
+		{"1.2.0.0/24", "US", 2},
+		// 1.0.0.0/8 is complete once a row of 2.0.0.0/8 arrives.
+		{"2.0.0.0/24", "US", 1},
+		{"2001:db8::/32", "US", 1},
+	}
+	for _, row := range rows {
+		require.NoError(t, w.WriteRow(
+			netip.MustParsePrefix(row.prefix),
+			[]mmdbtype.DataType{mmdbtype.String(row.country), mmdbtype.String("City")},
+		))
+		assert.Equal(t, row.open, openPartitionFiles(w), row.prefix)
+	}
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 0, openPartitionFiles(w))
+	assert.Len(t, readManifest(t, dir).Files, 4)
+}
+
+// openPartitionFiles returns the number of files the writer has open.
+func openPartitionFiles(w *PartitionedWriter) int {
+	n := 0
+	for _, p := range w.partitions {
+		if p.file != nil && p.file.file != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPartitionedWriter_SetNestedTypes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig([]string{"country"}, 0)
+	cfg.Columns[1] = config.Column{Name: "city", Path: config.Path{"city"}, Type: "struct"}
+	types, err := InferNestedTypes(cfg, func(
+		_ []int,
+		observe func(int, mmdbtype.DataType) error,
+	) error {
+		if err := observe(1, mmdbtype.Map{"name": mmdbtype.String("Dallas")}); err != nil {
+			return err
+		}
+		return observe(1, mmdbtype.Map{"geoname_id": mmdbtype.Uint32(2988507)})
+	})
+	require.NoError(t, err)
+
+	w, err := NewPartitionedWriter(dir, cfg, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.SetNestedTypes(types))
+	require.NoError(t, w.WriteRow(netip.MustParsePrefix("1.0.0.0/24"), []mmdbtype.DataType{
+		mmdbtype.String("US"), mmdbtype.Map{"name": mmdbtype.String("Dallas")},
+	}))
+	require.NoError(t, w.WriteRow(netip.MustParsePrefix("2.0.0.0/24"), []mmdbtype.DataType{
+		mmdbtype.String("FR"), mmdbtype.Map{"geoname_id": mmdbtype.Uint32(2988507)},
+	}))
+	require.NoError(t, w.Flush())
+
+	// Each file holds one of the fields, but both have the full schema.
+	require.Len(t, w.files, 2)
+	for _, f := range w.files {
+		_, ok := f.schema.Lookup("city", "name")
+		assert.True(t, ok, f.Path)
+		_, ok = f.schema.Lookup("city", "geoname_id")
+		assert.True(t, ok, f.Path)
+	}
+}
+
+func TestPartitionedWriter_NetworkPrefix(t *testing.T) {
+	dir := t.TempDir()
+	cfg := partitionTestConfig([]string{"network_prefix"}, 0)
+	cfg.Network.Columns = []config.NetworkColumn{
+		{Name: "start_int", Type: "start_int"},
+		{Name: "end_int", Type: "end_int"},
+	}
+
+	w, err := NewPartitionedWriter(dir, cfg, nil)
+	require.NoError(t, err)
+	data := []mmdbtype.DataType{mmdbtype.String("US"), mmdbtype.String("Dallas")}
+	// 2.0.0.0/7 spans two /8 partitions and is split between them.
+	for _, prefix := range []string{"1.2.0.0/16", "2.0.0.0/7", "2001:db8::/32"} {
+		require.NoError(t, w.WriteRow(netip.MustParsePrefix(prefix), data))
+	}
+	require.NoError(t, w.Flush())
+
+	manifest := readManifest(t, dir)
+	var paths []string
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{
+		"network_prefix=1.0.0.0%2F8/part-0000.parquet",
+		"network_prefix=2.0.0.0%2F8/part-0000.parquet",
+		"network_prefix=2001%3A%3A%2F16/part-0000.parquet",
+		"network_prefix=3.0.0.0%2F8/part-0000.parquet",
+	}, paths)
+	assert.Equal(t, "2001::/16", manifest.Files[2].Partition["network_prefix"])
+
+	// Each file holds a single IP version, so start_int works for IPv6 too.
+	got := readDatasetFile(t, dir, "network_prefix=3.0.0.0%2F8/part-0000.parquet")
+	require.Len(t, got, 1)
+	assert.Equal(t, ipv4ToInt64("3.0.0.0"), got[0]["start_int"])
+	assert.Equal(t, ipv4ToInt64("3.255.255.255"), got[0]["end_int"])
+}
+
+func TestPartitionedWriter_RejectsNonEmptyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.parquet"), nil, 0o600))
+
+	_, err := NewPartitionedWriter(dir, partitionTestConfig([]string{"country"}, 0), nil)
+	require.ErrorContains(t, err, "is not empty")
+}
+
+func TestPartitionedWriter_RejectsNonScalarPartitionValue(t *testing.T) {
+	w, err := NewPartitionedWriter(t.TempDir(), partitionTestConfig([]string{"country"}, 0), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	err = w.WriteRow(
+		netip.MustParsePrefix("1.0.0.0/24"),
+		[]mmdbtype.DataType{mmdbtype.Map{"iso_code": mmdbtype.String("US")}, nil},
+	)
+	require.ErrorContains(t, err, "partition key 'country'")
+}
+
+func TestEscapePartitionValue(t *testing.T) {
+	tests := map[string]string{
+		"":          "__HIVE_DEFAULT_PARTITION__",
+		"US":        "US",
+		"1.0.0.0/8": "1.0.0.0%2F8",
+		"2001::/16": "2001%3A%3A%2F16",
+		"a=b%c":     "a%3Db%25c",
+		"tab\there": "tab%09here",
+		"São Paulo": "São Paulo",
+	}
+	for value, want := range tests {
+		assert.Equal(t, want, escapePartitionValue(value), value)
+	}
+}
@@ -0,0 +1,53 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ProvenanceKey is the Parquet key-value metadata key and the MMDB
+// description key under which provenance is recorded.
+const ProvenanceKey = "mmdbconvert.provenance"
+
+// Provenance records which source databases, configuration, and mmdbconvert
+// version an output file was built from.
+type Provenance struct {
+	Version      string           `json:"mmdbconvert_version"`
+	ConfigSHA256 string           `json:"config_sha256"`
+	Databases    []SourceDatabase `json:"databases"`
+}
+
+// SourceDatabase describes one of the source databases of an output file.
+type SourceDatabase struct {
+	Name         string `json:"name"`
+	Path         string `json:"path"`
+	SHA256       string `json:"sha256"`
+	DatabaseType string `json:"database_type"`
+	BuildEpoch   uint   `json:"build_epoch"`
+	IPVersion    uint   `json:"ip_version"`
+}
+
+// JSON returns the compact JSON encoding of the provenance, as embedded in
+// Parquet and MMDB metadata.
+func (p *Provenance) JSON() (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("encoding provenance: %w", err)
+	}
+	return string(b), nil
+}
+
+// WriteProvenanceFile writes the provenance as indented JSON to path.
+func WriteProvenanceFile(path string, p *Provenance) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding provenance: %w", err)
+	}
+	b = append(b, '\n')
+	// #nosec G306 -- provenance is not sensitive and sits next to the output
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return fmt.Errorf("writing provenance file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package writer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteProvenanceFile(t *testing.T) {
+	provenance := &Provenance{
+		Version:      "1.0.0",
+		ConfigSHA256: "abc123",
+		Databases: []SourceDatabase{
+			{
+				Name:         "city",
+				Path:         "/data/GeoIP2-City.mmdb",
+				SHA256:       "def456",
+				DatabaseType: "GeoIP2-City",
+				BuildEpoch:   1700000000,
+				IPVersion:    6,
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "output.provenance.json")
+	require.NoError(t, WriteProvenanceFile(path, provenance))
+
+	content, err := os.ReadFile(filepath.Clean(path))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"mmdbconvert_version": "1.0.0",
+		"config_sha256": "abc123",
+		"databases": [{
+			"name": "city",
+			"path": "/data/GeoIP2-City.mmdb",
+			"sha256": "def456",
+			"database_type": "GeoIP2-City",
+			"build_epoch": 1700000000,
+			"ip_version": 6
+		}]
+	}`, string(content))
+
+	// The compact form embedded in Parquet and MMDB metadata decodes to the
+	// same provenance.
+	encoded, err := provenance.JSON()
+	require.NoError(t, err)
+	var decoded Provenance
+	require.NoError(t, json.Unmarshal([]byte(encoded), &decoded))
+	assert.Equal(t, *provenance, decoded)
+}
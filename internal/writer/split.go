@@ -3,6 +3,7 @@ package writer
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/netip"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
@@ -38,6 +39,29 @@ func (s *SplitRowWriter) WriteRow(prefix netip.Prefix, data []mmdbtype.DataType)
 	return s.ipv6.WriteRow(prefix, data)
 }
 
+// SetWarnings sets the writer that receives warnings about values that are
+// not written as is on the underlying writers that produce them.
+func (s *SplitRowWriter) SetWarnings(warnings io.Writer) {
+	for _, w := range []rowWriter{s.ipv4, s.ipv6} {
+		if setter, ok := w.(interface{ SetWarnings(io.Writer) }); ok {
+			setter.SetWarnings(warnings)
+		}
+	}
+}
+
+// SetNestedTypes sets the types of the nested columns inferred by
+// InferNestedTypes on the underlying writers that support them.
+func (s *SplitRowWriter) SetNestedTypes(types *NestedTypes) error {
+	for _, w := range []rowWriter{s.ipv4, s.ipv6} {
+		if setter, ok := w.(interface{ SetNestedTypes(*NestedTypes) error }); ok {
+			if err := setter.SetNestedTypes(types); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // WriteRange writes an IP range to the underlying IPv4 or IPv6 writer.
 // This method implements the merger.RangeRowWriter interface, enabling range
 // compression when the underlying writers support it.
@@ -1,7 +1,12 @@
 // Package writer provides output writers for CSV and Parquet formats.
 package writer
 
-import "github.com/maxmind/mmdbconvert/internal/config"
+import (
+	"net/netip"
+
+	"github.com/maxmind/mmdbconvert/internal/config"
+	"github.com/maxmind/mmdbconvert/internal/network"
+)
 
 // Network column type constants.
 const (
@@ -11,6 +16,13 @@ const (
 	NetworkColumnStartInt = "start_int"
 	NetworkColumnEndInt   = "end_int"
 	NetworkColumnBucket   = "network_bucket"
+
+	// 64-bit halves of the address as sortable int64 values (see
+	// network.IPToInt64Halves).
+	NetworkColumnStartIntHi = "start_int_hi"
+	NetworkColumnStartIntLo = "start_int_lo"
+	NetworkColumnEndIntHi   = "end_int_hi"
+	NetworkColumnEndIntLo   = "end_int_lo"
 )
 
 // hasNetworkBucketColumn returns true if a network_bucket column is configured.
@@ -22,3 +34,13 @@ func hasNetworkBucketColumn(cfg *config.Config) bool {
 	}
 	return false
 }
+
+// int64Half returns the upper half of addr for the *_hi network column types
+// and the lower half otherwise.
+func int64Half(addr netip.Addr, colType string) int64 {
+	hi, lo := network.IPToInt64Halves(addr)
+	if colType == NetworkColumnStartIntHi || colType == NetworkColumnEndIntHi {
+		return hi
+	}
+	return lo
+}
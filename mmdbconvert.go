@@ -3,11 +3,15 @@
 package mmdbconvert
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/maxmind/mmdbconvert/internal/config"
@@ -24,6 +28,15 @@ type Options struct {
 	// DisableCache disables MMDB unmarshaler caching to reduce memory usage.
 	// This makes processing several times slower but uses less memory.
 	DisableCache bool
+
+	// Version is the mmdbconvert version recorded in the provenance metadata
+	// of output files.
+	Version string
+
+	// Warnings receives warnings about values that are not written as is,
+	// such as nested Parquet fields missing from the rows sampled to infer
+	// the schema. If nil, warnings are discarded.
+	Warnings io.Writer
 }
 
 // Run performs the MMDB conversion using the specified options.
@@ -52,11 +65,29 @@ func Run(opts Options) error {
 	}
 	defer readers.Close()
 
+	if err := expandColumns(cfg, readers); err != nil {
+		return fmt.Errorf("expanding columns: %w", err)
+	}
+
+	if err := inheritMMDBMetadata(cfg, readers); err != nil {
+		return fmt.Errorf("inheriting MMDB metadata: %w", err)
+	}
+
 	if err := validateParquetNetworkColumns(cfg, readers); err != nil {
 		return fmt.Errorf("validating network columns: %w", err)
 	}
 
-	rowWriter, closers, err := prepareRowWriter(cfg, readers)
+	// Hashing reads every source database in full, so provenance is only
+	// built if the output records it.
+	var provenance *writer.Provenance
+	if recordsProvenance(cfg) {
+		provenance, err = buildProvenance(cfg, opts.ConfigPath, opts.Version, readers)
+		if err != nil {
+			return fmt.Errorf("recording provenance: %w", err)
+		}
+	}
+
+	rowWriter, closers, err := prepareRowWriter(cfg, readers, provenance)
 	if err != nil {
 		return err
 	}
@@ -70,6 +101,22 @@ func Run(opts Options) error {
 	if err != nil {
 		return fmt.Errorf("creating merger: %w", err)
 	}
+	if setter, ok := rowWriter.(interface{ SetWarnings(io.Writer) }); ok {
+		setter.SetWarnings(opts.Warnings)
+	}
+	// Parquet writers infer the types of nested columns from every source
+	// record rather than from the first rows written.
+	if setter, ok := rowWriter.(interface {
+		SetNestedTypes(*writer.NestedTypes) error
+	}); ok {
+		nestedTypes, err := writer.InferNestedTypes(cfg, m.ScanColumnValues)
+		if err != nil {
+			return fmt.Errorf("inferring nested Parquet types: %w", err)
+		}
+		if err := setter.SetNestedTypes(nestedTypes); err != nil {
+			return fmt.Errorf("inferring nested Parquet types: %w", err)
+		}
+	}
 	if err := m.Merge(); err != nil {
 		return fmt.Errorf("merging databases: %w", err)
 	}
@@ -80,12 +127,30 @@ func Run(opts Options) error {
 		}
 	}
 
+	if mmdbWriter, ok := rowWriter.(*writer.MMDBWriter); ok && cfg.Output.MMDB.Verify != "" {
+		sampleRate := 1.0
+		if cfg.Output.MMDB.Verify == config.VerifySample {
+			sampleRate = cfg.Output.MMDB.VerifySampleRate
+		}
+		if err := m.VerifyMMDB(cfg.Output.File, mmdbWriter, sampleRate); err != nil {
+			return fmt.Errorf("verifying output: %w", err)
+		}
+	}
+
+	if cfg.Output.Format == "csv" && cfg.Output.CSV.ProvenanceFile != "" {
+		err := writer.WriteProvenanceFile(cfg.Output.CSV.ProvenanceFile, provenance)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func prepareRowWriter(
 	cfg *config.Config,
 	readers *mmdb.Readers,
+	provenance *writer.Provenance,
 ) (merger.RowWriter, []io.Closer, error) {
 	var closers []io.Closer
 
@@ -132,6 +197,26 @@ func prepareRowWriter(
 		return writer.NewCSVWriter(outputFile, cfg), closers, nil
 
 	case "parquet":
+		if cfg.Output.Parquet.TableFormat == config.TableFormatDelta {
+			tableWriter, err := writer.NewDeltaTableWriter(cfg.Output.File, cfg, provenance)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("creating Delta table writer: %w", err)
+			}
+			closers = append(closers, tableWriter)
+			return tableWriter, closers, nil
+		}
+
+		if cfg.Output.Parquet.IsDataset() {
+			datasetWriter, err := writer.NewPartitionedWriter(cfg.Output.File, cfg, provenance)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("creating partitioned Parquet writer: %w", err)
+			}
+			closers = append(closers, datasetWriter)
+			return datasetWriter, closers, nil
+		}
+
 		if cfg.Output.IPv4File != "" && cfg.Output.IPv6File != "" {
 			ipv4Path, ipv6Path := splitConfiguredPaths(
 				cfg.Output.File,
@@ -171,6 +256,10 @@ func prepareRowWriter(
 				closeAll()
 				return nil, nil, fmt.Errorf("creating IPv6 Parquet writer: %w", err)
 			}
+			if err := setParquetProvenance(provenance, ipv4Writer, ipv6Writer); err != nil {
+				closeAll()
+				return nil, nil, err
+			}
 			return writer.NewSplitRowWriter(ipv4Writer, ipv6Writer), closers, nil
 		}
 
@@ -181,21 +270,33 @@ func prepareRowWriter(
 		}
 		closers = append(closers, outputFile)
 
-		parquetWriter, err := writer.NewParquetWriter(outputFile, cfg)
+		parquetWriter, err := writer.NewParquetWriterWithIPVersion(
+			outputFile,
+			cfg,
+			cfg.Output.IPVersion,
+		)
 		if err != nil {
 			closeAll()
 			return nil, nil, fmt.Errorf("creating Parquet writer: %w", err)
 		}
+		if err := setParquetProvenance(provenance, parquetWriter); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
 		return parquetWriter, closers, nil
 
 	case "mmdb":
-		ipVersion, err := detectIPVersionFromDatabases(cfg, readers)
-		if err != nil {
-			closeAll()
-			return nil, nil, fmt.Errorf("detecting IP version: %w", err)
+		ipVersion := cfg.Output.MMDB.IPVersion
+		if ipVersion == 0 {
+			detected, err := detectIPVersionFromDatabases(cfg, readers)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("detecting IP version: %w", err)
+			}
+			ipVersion = detected
 		}
 
-		mmdbWriter, err := writer.NewMMDBWriter(cfg.Output.File, cfg, ipVersion)
+		mmdbWriter, err := writer.NewMMDBWriter(cfg.Output.File, cfg, ipVersion, provenance)
 		if err != nil {
 			closeAll()
 			return nil, nil, fmt.Errorf("creating MMDB writer: %w", err)
@@ -208,6 +309,127 @@ func prepareRowWriter(
 	return nil, nil, fmt.Errorf("unsupported output format: %s", cfg.Output.Format)
 }
 
+// expandColumns expands expand = "keys" columns using the languages listed in
+// each database's metadata.
+func expandColumns(cfg *config.Config, readers *mmdb.Readers) error {
+	languages := make(map[string][]string, len(cfg.Databases))
+	for _, db := range cfg.Databases {
+		reader, ok := readers.Get(db.Name)
+		if !ok {
+			return fmt.Errorf("database '%s' not found", db.Name)
+		}
+		languages[db.Name] = reader.Metadata().Languages
+	}
+	return config.ExpandColumns(cfg, languages)
+}
+
+// inheritMMDBMetadata fills in the output.mmdb description and languages that
+// are not set from the metadata of the output.mmdb.metadata_from database.
+// Inherited languages are restricted to the configured locales.
+func inheritMMDBMetadata(cfg *config.Config, readers *mmdb.Readers) error {
+	from := cfg.Output.MMDB.MetadataFrom
+	if cfg.Output.Format != "mmdb" || from == "" {
+		return nil
+	}
+	reader, ok := readers.Get(from)
+	if !ok {
+		return fmt.Errorf("database '%s' not found", from)
+	}
+	metadata := reader.Metadata()
+	if len(cfg.Output.MMDB.Description) == 0 {
+		cfg.Output.MMDB.Description = maps.Clone(metadata.Description)
+	}
+	if len(cfg.Output.MMDB.Languages) == 0 {
+		cfg.Output.MMDB.Languages = config.RestrictToLocales(
+			slices.Clone(metadata.Languages),
+			cfg.Locales,
+		)
+	}
+	return nil
+}
+
+// buildProvenance records the source databases, configuration file hash, and
+// mmdbconvert version for the output metadata.
+func buildProvenance(
+	cfg *config.Config,
+	configPath string,
+	version string,
+	readers *mmdb.Readers,
+) (*writer.Provenance, error) {
+	configHash, err := hashFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	provenance := &writer.Provenance{
+		Version:      version,
+		ConfigSHA256: configHash,
+		Databases:    make([]writer.SourceDatabase, 0, len(cfg.Databases)),
+	}
+	for _, db := range cfg.Databases {
+		reader, ok := readers.Get(db.Name)
+		if !ok {
+			return nil, fmt.Errorf("database '%s' not found", db.Name)
+		}
+		dbHash, err := hashFile(db.Path)
+		if err != nil {
+			return nil, err
+		}
+		metadata := reader.Metadata()
+		provenance.Databases = append(provenance.Databases, writer.SourceDatabase{
+			Name:         db.Name,
+			Path:         db.Path,
+			SHA256:       dbHash,
+			DatabaseType: metadata.DatabaseType,
+			BuildEpoch:   metadata.BuildEpoch,
+			IPVersion:    metadata.IPVersion,
+		})
+	}
+	return provenance, nil
+}
+
+// recordsProvenance reports whether the output records the provenance: in the
+// metadata of Parquet and MMDB output with output.provenance, or in the
+// provenance file of CSV output.
+func recordsProvenance(cfg *config.Config) bool {
+	if cfg.Output.Format == "csv" {
+		return cfg.Output.CSV.ProvenanceFile != ""
+	}
+	return cfg.Output.Provenance
+}
+
+// openForHashing opens the files hashed for the provenance. Tests replace it
+// to observe which files are hashed.
+var openForHashing = os.Open
+
+// hashFile returns the hex-encoded SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := openForHashing(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// setParquetProvenance records the provenance in the key-value metadata of
+// each Parquet writer.
+func setParquetProvenance(provenance *writer.Provenance, writers ...*writer.ParquetWriter) error {
+	encoded, err := provenance.JSON()
+	if err != nil {
+		return err
+	}
+	for _, w := range writers {
+		w.SetKeyValueMetadata(writer.ProvenanceKey, encoded)
+	}
+	return nil
+}
+
 func createOutputFile(path string) (*os.File, error) {
 	// #nosec G304 -- paths come from trusted configuration
 	file, err := os.Create(path)
@@ -217,26 +439,28 @@ func createOutputFile(path string) (*os.File, error) {
 	return file, nil
 }
 
+// detectIPVersionFromDatabases returns 6 if any configured database is an
+// IPv6 database, as IPv4-only databases merged with IPv6 ones are mapped into
+// the IPv6 space, and 4 otherwise.
 func detectIPVersionFromDatabases(cfg *config.Config, readers *mmdb.Readers) (int, error) {
-	// Get the first database from config to detect IP version
-	// In practice, all databases in the merge should have the same IP version
-	// due to validation in merger
 	if len(cfg.Databases) == 0 {
 		return 0, errors.New("no databases configured")
 	}
 
-	firstDB := cfg.Databases[0].Name
-	reader, ok := readers.Get(firstDB)
-	if !ok {
-		return 0, fmt.Errorf("database '%s' not found", firstDB)
-	}
-
-	metadata := reader.Metadata()
-	//nolint:gosec // IPVersion is always 4 or 6, no overflow risk
-	ipVersion := int(metadata.IPVersion)
+	ipVersion := 4
+	for _, db := range cfg.Databases {
+		reader, ok := readers.Get(db.Name)
+		if !ok {
+			return 0, fmt.Errorf("database '%s' not found", db.Name)
+		}
 
-	if ipVersion != 4 && ipVersion != 6 {
-		return 0, fmt.Errorf("invalid IP version %d in database '%s'", ipVersion, firstDB)
+		switch version := reader.Metadata().IPVersion; version {
+		case 4:
+		case 6:
+			ipVersion = 6
+		default:
+			return 0, fmt.Errorf("invalid IP version %d in database '%s'", version, db.Name)
+		}
 	}
 
 	return ipVersion, nil
@@ -276,8 +500,10 @@ func validateParquetNetworkColumns(cfg *config.Config, readers *mmdb.Readers) er
 		return nil
 	}
 
-	// Already split output, so integer columns are safe (each writer enforces a single IP family).
-	if cfg.Output.IPv4File != "" && cfg.Output.IPv6File != "" {
+	// Already split or restricted output, so integer columns are safe (each
+	// writer enforces a single IP family).
+	if (cfg.Output.IPv4File != "" && cfg.Output.IPv6File != "") ||
+		cfg.Output.Parquet.SplitsIPVersions() || cfg.Output.IPVersion != 0 {
 		return nil
 	}
 
@@ -288,7 +514,7 @@ func validateParquetNetworkColumns(cfg *config.Config, readers *mmdb.Readers) er
 
 	if ipVersion == 6 {
 		return errors.New(
-			"network column types 'start_int' and 'end_int' require split IPv4/IPv6 outputs when processing IPv6 databases; set output.ipv4_file and output.ipv6_file or switch to start_ip/end_ip",
+			"network column types 'start_int' and 'end_int' require split IPv4/IPv6 outputs when processing IPv6 databases; set output.ipv4_file and output.ipv6_file, partition the dataset by ip_version, or switch to start_ip/end_ip",
 		)
 	}
 
@@ -1,16 +1,20 @@
 package mmdbconvert
 
 import (
+	"encoding/json"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/maxmind/mmdbconvert/internal/config"
 	"github.com/maxmind/mmdbconvert/internal/mmdb"
+	"github.com/maxmind/mmdbconvert/internal/writer"
 )
 
 const testDataDir = "testdata/MaxMind-DB/test-data"
@@ -184,6 +188,188 @@ path = ["country", "iso_code"]
 	assert.Positive(t, info.Size())
 }
 
+func TestRun_ExpandKeys(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "output.csv")
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+
+	absTestDataDir, err := filepath.Abs(testDataDir)
+	require.NoError(t, err)
+
+	configContent := `
+[output]
+format = "csv"
+file = "` + tomlPath(outputFile) + `"
+
+[[databases]]
+name = "city"
+path = "` + tomlPath(filepath.Join(absTestDataDir, "GeoIP2-City-Test.mmdb")) + `"
+
+[[columns]]
+name = "city_name"
+database = "city"
+path = ["city", "names"]
+expand = "keys"
+`
+
+	err = os.WriteFile(configFile, []byte(configContent), 0o600)
+	require.NoError(t, err)
+
+	err = Run(Options{ConfigPath: configFile})
+	require.NoError(t, err)
+
+	reader, err := mmdb.Open(filepath.Join(absTestDataDir, "GeoIP2-City-Test.mmdb"))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	expectedHeader := []string{"network"}
+	for _, lang := range reader.Metadata().Languages {
+		expectedHeader = append(expectedHeader, "city_name_"+strings.ReplaceAll(lang, "-", "_"))
+	}
+
+	content, err := os.ReadFile(filepath.Clean(outputFile))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, strings.Join(expectedHeader, ","), lines[0])
+}
+
+func TestRun_CSVProvenanceFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputFile := filepath.Join(tmpDir, "output.csv")
+	provenanceFile := filepath.Join(tmpDir, "output.provenance.json")
+	configFile := filepath.Join(tmpDir, "config.toml")
+
+	absTestDataDir, err := filepath.Abs(testDataDir)
+	require.NoError(t, err)
+	dbPath := filepath.Join(absTestDataDir, "GeoIP2-City-Test.mmdb")
+
+	configContent := `
+[output]
+format = "csv"
+file = "` + tomlPath(outputFile) + `"
+
+[output.csv]
+provenance_file = "` + tomlPath(provenanceFile) + `"
+
+[[databases]]
+name = "city"
+path = "` + tomlPath(dbPath) + `"
+
+[[columns]]
+name = "country_code"
+database = "city"
+path = ["country", "iso_code"]
+`
+
+	err = os.WriteFile(configFile, []byte(configContent), 0o600)
+	require.NoError(t, err)
+
+	err = Run(Options{ConfigPath: configFile, Version: "1.2.3"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Clean(provenanceFile))
+	require.NoError(t, err)
+
+	var provenance writer.Provenance
+	require.NoError(t, json.Unmarshal(content, &provenance))
+
+	configHash, err := hashFile(configFile)
+	require.NoError(t, err)
+	dbHash, err := hashFile(dbPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "1.2.3", provenance.Version)
+	assert.Equal(t, configHash, provenance.ConfigSHA256)
+	require.Len(t, provenance.Databases, 1)
+	assert.Equal(t, "city", provenance.Databases[0].Name)
+	assert.Equal(t, dbPath, provenance.Databases[0].Path)
+	assert.Equal(t, dbHash, provenance.Databases[0].SHA256)
+	assert.Equal(t, "GeoIP2-City", provenance.Databases[0].DatabaseType)
+	assert.Equal(t, uint(6), provenance.Databases[0].IPVersion)
+	assert.NotZero(t, provenance.Databases[0].BuildEpoch)
+}
+
+func TestRun_ProvenanceOnlyHashedWhenRecorded(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := writeSourceMMDB(t, filepath.Join(dir, "source.mmdb"), map[string]string{
+		"1.0.0.0/24": "AU",
+	})
+	configFile := filepath.Join(dir, "config.toml")
+
+	var hashed []string
+	openForHashing = func(path string) (*os.File, error) {
+		hashed = append(hashed, path)
+		return os.Open(path) // #nosec G304 -- test file
+	}
+	t.Cleanup(func() { openForHashing = os.Open })
+
+	run := func(output string) {
+		t.Helper()
+		hashed = nil
+		configContent := `
+[output]
+` + output + `
+
+[[databases]]
+name = "source"
+path = "` + tomlPath(sourcePath) + `"
+
+[[columns]]
+name = "country"
+database = "source"
+path = ["country"]
+`
+		require.NoError(t, os.WriteFile(configFile, []byte(configContent), 0o600))
+		require.NoError(t, Run(Options{ConfigPath: configFile}))
+	}
+	mmdbDescription := func(path string) map[string]string {
+		t.Helper()
+		reader, err := mmdb.Open(path)
+		require.NoError(t, err)
+		defer reader.Close()
+		return reader.Metadata().Description
+	}
+
+	csvFile := filepath.Join(dir, "output.csv")
+	run(`format = "csv"
+file = "` + tomlPath(csvFile) + `"`)
+	assert.Empty(t, hashed, "a plain CSV run must not hash its inputs")
+
+	run(`format = "csv"
+file = "` + tomlPath(csvFile) + `"
+
+[output.csv]
+provenance_file = "` + tomlPath(filepath.Join(dir, "provenance.json")) + `"`)
+	assert.Equal(t, []string{configFile, sourcePath}, hashed)
+
+	run(`format = "parquet"
+file = "` + tomlPath(filepath.Join(dir, "output.parquet")) + `"
+
+[network]
+columns = [{ name = "network", type = "cidr" }]`)
+	assert.Empty(t, hashed, "provenance is opt-in for Parquet")
+
+	mmdbFile := filepath.Join(dir, "output.mmdb")
+	mmdbOutput := `format = "mmdb"
+file = "` + tomlPath(mmdbFile) + `"
+`
+	run(mmdbOutput + `
+[output.mmdb]
+database_type = "Test"
+description = { en = "Test database" }`)
+	assert.Empty(t, hashed, "provenance is opt-in for MMDB")
+	assert.Equal(t, map[string]string{"en": "Test database"}, mmdbDescription(mmdbFile))
+
+	run(mmdbOutput + `provenance = true
+
+[output.mmdb]
+database_type = "Test"
+description = { en = "Test database" }`)
+	assert.Equal(t, []string{configFile, sourcePath}, hashed)
+	description := mmdbDescription(mmdbFile)
+	assert.Equal(t, "Test database", description["en"])
+	assert.Contains(t, description, writer.ProvenanceKey)
+}
+
 func TestRun_SplitIPv4IPv6Output(t *testing.T) {
 	tmpDir := t.TempDir()
 	ipv4File := filepath.Join(tmpDir, "ipv4.csv")
@@ -322,6 +508,179 @@ func TestValidateParquetNetworkColumns_IPv4SingleFileAllowed(t *testing.T) {
 	require.NoError(t, validateParquetNetworkColumns(cfg, readers))
 }
 
+func TestInheritMMDBMetadata(t *testing.T) {
+	recordSize := 28
+	includeReserved := false
+	sourceCfg := &config.Config{
+		Output: config.OutputConfig{
+			MMDB: config.MMDBConfig{
+				DatabaseType:            "Source-DB",
+				Description:             map[string]string{"en": "Source", "de": "Quelle"},
+				Languages:               []string{"de", "en", "fr"},
+				RecordSize:              &recordSize,
+				IncludeReservedNetworks: &includeReserved,
+			},
+		},
+		Columns: []config.Column{{Name: "country"}},
+	}
+	sourcePath := filepath.Join(t.TempDir(), "source.mmdb")
+	source, err := writer.NewMMDBWriter(sourcePath, sourceCfg, 6, nil)
+	require.NoError(t, err)
+	require.NoError(t, source.Flush())
+
+	tests := []struct {
+		name            string
+		mmdb            config.MMDBConfig
+		wantDescription map[string]string
+		wantLanguages   []string
+	}{
+		{
+			name:            "both inherited",
+			mmdb:            config.MMDBConfig{MetadataFrom: "source"},
+			wantDescription: map[string]string{"en": "Source", "de": "Quelle"},
+			wantLanguages:   []string{"de", "en", "fr"},
+		},
+		{
+			name: "description set",
+			mmdb: config.MMDBConfig{
+				MetadataFrom: "source",
+				Description:  map[string]string{"en": "Merged"},
+			},
+			wantDescription: map[string]string{"en": "Merged"},
+			wantLanguages:   []string{"de", "en", "fr"},
+		},
+		{
+			name: "languages set",
+			mmdb: config.MMDBConfig{
+				MetadataFrom: "source",
+				Languages:    []string{"en"},
+			},
+			wantDescription: map[string]string{"en": "Source", "de": "Quelle"},
+			wantLanguages:   []string{"en"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Output:    config.OutputConfig{Format: "mmdb", MMDB: tt.mmdb},
+				Databases: []config.Database{{Name: "source", Path: sourcePath}},
+			}
+			readers := openTestReaders(t, cfg)
+
+			require.NoError(t, inheritMMDBMetadata(cfg, readers))
+			assert.Equal(t, tt.wantDescription, cfg.Output.MMDB.Description)
+			assert.Equal(t, tt.wantLanguages, cfg.Output.MMDB.Languages)
+		})
+	}
+}
+
+func TestRun_MMDBVerify(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := writeSourceMMDB(t, filepath.Join(dir, "source.mmdb"), map[string]string{
+		"1.0.0.0/24": "AU",
+		"2.0.0.0/16": "FR",
+		"2a02::/16":  "DE",
+	})
+
+	outputFile := filepath.Join(dir, "output.mmdb")
+	configFile := filepath.Join(dir, "config.toml")
+	configContent := `
+[output]
+format = "mmdb"
+file = "` + tomlPath(outputFile) + `"
+
+[output.mmdb]
+database_type = "Merged"
+verify = "full"
+
+[[databases]]
+name = "source"
+path = "` + tomlPath(sourcePath) + `"
+
+[[columns]]
+name = "country_code"
+database = "source"
+path = ["country"]
+`
+	require.NoError(t, os.WriteFile(configFile, []byte(configContent), 0o600))
+
+	require.NoError(t, Run(Options{ConfigPath: configFile}))
+}
+
+func TestRun_MMDBIPVersion4(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := writeSourceMMDB(t, filepath.Join(dir, "source.mmdb"), map[string]string{
+		"1.0.0.0/24": "AU",
+		"2a02::/16":  "DE",
+	})
+
+	outputFile := filepath.Join(dir, "output.mmdb")
+	configFile := filepath.Join(dir, "config.toml")
+	configContent := `
+[output]
+format = "mmdb"
+file = "` + tomlPath(outputFile) + `"
+
+[output.mmdb]
+database_type = "Merged"
+ip_version = 4
+verify = "full"
+
+[[databases]]
+name = "source"
+path = "` + tomlPath(sourcePath) + `"
+
+[[columns]]
+name = "country_code"
+database = "source"
+path = ["country"]
+`
+	require.NoError(t, os.WriteFile(configFile, []byte(configContent), 0o600))
+	require.NoError(t, Run(Options{ConfigPath: configFile}))
+
+	output, err := mmdb.Open(outputFile)
+	require.NoError(t, err)
+	defer output.Close()
+	assert.Equal(t, uint(4), output.Metadata().IPVersion)
+
+	var networks []string
+	for result := range output.Networks() {
+		require.NoError(t, result.Err())
+		networks = append(networks, result.Prefix().String())
+	}
+	assert.Equal(t, []string{"1.0.0.0/24"}, networks)
+}
+
+// writeSourceMMDB writes an IPv6 MMDB with a country value per network to
+// path, for use as a source database.
+func writeSourceMMDB(t *testing.T, path string, countries map[string]string) string {
+	t.Helper()
+
+	recordSize := 28
+	includeReserved := false
+	sourceCfg := &config.Config{
+		Output: config.OutputConfig{
+			MMDB: config.MMDBConfig{
+				DatabaseType:            "Source-DB",
+				RecordSize:              &recordSize,
+				IncludeReservedNetworks: &includeReserved,
+			},
+		},
+		Columns: []config.Column{{Name: "country"}},
+	}
+	source, err := writer.NewMMDBWriter(path, sourceCfg, 6, nil)
+	require.NoError(t, err)
+	for network, country := range countries {
+		err := source.WriteRow(
+			netip.MustParsePrefix(network),
+			[]mmdbtype.DataType{mmdbtype.String(country)},
+		)
+		require.NoError(t, err)
+	}
+	require.NoError(t, source.Flush())
+	return path
+}
+
 func openTestReaders(t *testing.T, cfg *config.Config) *mmdb.Readers {
 	paths := make(map[string]string, len(cfg.Databases))
 	for _, db := range cfg.Databases {